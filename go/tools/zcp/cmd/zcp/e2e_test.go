@@ -286,6 +286,871 @@ func TestCLIFlagsE2E(t *testing.T) {
 		}
 	})
 
+	t.Run("buffer_size_auto", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.bin")
+		destinationFile := filepath.Join(tempDir, "destination.bin")
+
+		expectedBytes := bytes.Repeat([]byte("a"), 257*1024)
+		if err := os.WriteFile(sourceFile, expectedBytes, 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "--buffer-size", "auto", sourceFile, destinationFile)
+		if err != nil {
+			t.Fatalf("buffer-size auto copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if stderr != "" {
+			t.Fatalf("expected empty stderr, got %q", stderr)
+		}
+
+		actualBytes, err := os.ReadFile(destinationFile)
+		if err != nil {
+			t.Fatalf("read destination file: %v", err)
+		}
+		if !bytes.Equal(actualBytes, expectedBytes) {
+			t.Fatalf("destination content mismatch after buffer-size auto copy")
+		}
+	})
+
+	t.Run("archive_flags", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "src")
+		if err := os.MkdirAll(sourceDir, 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(sourceDir, "payload.txt"), []byte("archived"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		archivePath := filepath.Join(tempDir, "bundle.tar.gz")
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "--to-archive", sourceDir, archivePath)
+		if err != nil {
+			t.Fatalf("to-archive copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+
+		extractDir := filepath.Join(tempDir, "extracted")
+		stdout, stderr, err = runCLI(t, tempDir, "-q", "--from-archive", archivePath, extractDir)
+		if err != nil {
+			t.Fatalf("from-archive copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if !strings.Contains(stdout, "Copied 1 file(s)") {
+			t.Fatalf("expected summary in stdout, got %q", stdout)
+		}
+
+		actualBytes, err := os.ReadFile(filepath.Join(extractDir, "src", "payload.txt"))
+		if err != nil {
+			t.Fatalf("read extracted file: %v", err)
+		}
+		if string(actualBytes) != "archived" {
+			t.Fatalf("unexpected extracted contents: %q", actualBytes)
+		}
+	})
+
+	t.Run("compress_flags", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "app.log")
+		expectedBytes := bytes.Repeat([]byte("log line\n"), 1024)
+		if err := os.WriteFile(sourceFile, expectedBytes, 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		compressedFile := filepath.Join(tempDir, "archived.log")
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "--compress=zstd", sourceFile, compressedFile)
+		if err != nil {
+			t.Fatalf("compress copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if !strings.Contains(stdout, "compression ratio") {
+			t.Fatalf("expected compression ratio in summary, got %q", stdout)
+		}
+
+		restoredFile := filepath.Join(tempDir, "restored.log")
+		stdout, stderr, err = runCLI(t, tempDir, "-q", "--decompress", compressedFile+".zst", restoredFile+".zst")
+		if err != nil {
+			t.Fatalf("decompress copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+
+		actualBytes, err := os.ReadFile(restoredFile)
+		if err != nil {
+			t.Fatalf("read restored file: %v", err)
+		}
+		if !bytes.Equal(actualBytes, expectedBytes) {
+			t.Fatalf("restored content mismatch")
+		}
+
+		_, stderr, err = runCLI(t, tempDir, "--compress=lz4", sourceFile, filepath.Join(tempDir, "x"))
+		if err == nil || !strings.Contains(stderr, "unsupported compression") {
+			t.Fatalf("expected unsupported compression error, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("fsync_flag", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("durable"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		for _, mode := range []string{"none", "file", "end"} {
+			destinationFile := filepath.Join(tempDir, "destination-"+mode+".txt")
+			stdout, stderr, err := runCLI(t, tempDir, "--fsync="+mode, sourceFile, destinationFile)
+			if err != nil {
+				t.Fatalf("fsync=%s copy failed: %v (stdout=%q, stderr=%q)", mode, err, stdout, stderr)
+			}
+
+			actualBytes, err := os.ReadFile(destinationFile)
+			if err != nil {
+				t.Fatalf("read destination file: %v", err)
+			}
+			if string(actualBytes) != "durable" {
+				t.Fatalf("unexpected destination contents for fsync=%s: %q", mode, actualBytes)
+			}
+		}
+
+		_, stderr, err := runCLI(t, tempDir, "--fsync=always", sourceFile, filepath.Join(tempDir, "x"))
+		if err == nil || !strings.Contains(stderr, "unsupported fsync mode") {
+			t.Fatalf("expected fsync validation error, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("direct_flag", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.bin")
+		destinationFile := filepath.Join(tempDir, "destination.bin")
+
+		expectedBytes := bytes.Repeat([]byte("d"), 2*1024*1024+3)
+		if err := os.WriteFile(sourceFile, expectedBytes, 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "--direct", sourceFile, destinationFile)
+		if err != nil {
+			t.Fatalf("direct copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+
+		actualBytes, err := os.ReadFile(destinationFile)
+		if err != nil {
+			t.Fatalf("read destination file: %v", err)
+		}
+		if !bytes.Equal(actualBytes, expectedBytes) {
+			t.Fatalf("destination content mismatch after direct copy")
+		}
+	})
+
+	t.Run("stdin_stdout", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		stdout, stderr, err := runCLIWithStdin(t, tempDir, "streamed data", "-", "out.txt")
+		if err != nil {
+			t.Fatalf("stdin copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		got, err := os.ReadFile(filepath.Join(tempDir, "out.txt"))
+		if err != nil || string(got) != "streamed data" {
+			t.Fatalf("unexpected destination contents %q: %v", got, err)
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, "out.txt", "-")
+		if err != nil {
+			t.Fatalf("stdout copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if stdout != "streamed data" {
+			t.Fatalf("expected only file data on stdout, got %q", stdout)
+		}
+		if !strings.Contains(stderr, "Copied 1 file(s)") {
+			t.Fatalf("expected summary on stderr, got %q", stderr)
+		}
+	})
+
+	t.Run("files_from", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		for path, content := range map[string]string{
+			"src/keep.txt":         "keep",
+			"src/nested/odd\nname": "odd",
+			"src/skip.txt":         "skip",
+		} {
+			path = filepath.Join(tempDir, filepath.FromSlash(path))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create source directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		list := "src/keep.txt\x00src/nested/odd\nname\x00"
+		stdout, stderr, err := runCLIWithStdin(t, tempDir, list, "--files-from", "-", "-0", "out")
+		if err != nil {
+			t.Fatalf("files-from copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if !strings.Contains(stdout, "Copied 2 file(s)") {
+			t.Fatalf("expected 2 files copied, got stdout=%q", stdout)
+		}
+
+		for path, want := range map[string]string{"out/src/keep.txt": "keep", "out/src/nested/odd\nname": "odd"} {
+			got, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(path)))
+			if err != nil {
+				t.Fatalf("read %q: %v", path, err)
+			}
+			if string(got) != want {
+				t.Fatalf("unexpected contents for %q: %q", path, got)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "out", "src", "skip.txt")); !os.IsNotExist(err) {
+			t.Fatalf("expected unlisted file not to be copied, stat returned %v", err)
+		}
+
+		// Standard input cannot hold both the list and a source.
+		_, stderr, err = runCLIWithStdin(t, tempDir, list, "--files-from", "-", "-0", "-", "out")
+		if err == nil || !strings.Contains(stderr, "cannot both read standard input") {
+			t.Fatalf("expected --files-from - with a - SOURCE to be rejected, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("retry_flags", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("reliable"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		destinationFile := filepath.Join(tempDir, "destination.txt")
+		stdout, stderr, err := runCLI(t, tempDir, "--retries", "3", "--retry-delay", "10ms", sourceFile, destinationFile)
+		if err != nil {
+			t.Fatalf("retries copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if strings.Contains(stdout, "transient I/O error") {
+			t.Fatalf("expected no retries in summary, got %q", stdout)
+		}
+
+		_, stderr, err = runCLI(t, tempDir, "--retries", "-1", sourceFile, filepath.Join(tempDir, "x"))
+		if err == nil || !strings.Contains(stderr, "retries must not be negative") {
+			t.Fatalf("expected retries validation error, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("on_change_flag", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("steady"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "--on-change=fail", sourceFile, filepath.Join(tempDir, "destination.txt"))
+		if err != nil {
+			t.Fatalf("on-change copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if strings.Contains(stdout, "changed while being copied") {
+			t.Fatalf("expected no changed files in summary, got %q", stdout)
+		}
+
+		_, stderr, err = runCLI(t, tempDir, "--on-change=ignore", sourceFile, filepath.Join(tempDir, "x"))
+		if err == nil || !strings.Contains(stderr, "unsupported on-change policy") {
+			t.Fatalf("expected on-change validation error, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("filters_and_dry_run", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		for path, content := range map[string]string{
+			"src/large.bin":        strings.Repeat("x", 2048),
+			"src/small.txt":        "small",
+			"src/nested/large.bin": strings.Repeat("y", 2048),
+		} {
+			path = filepath.Join(tempDir, filepath.FromSlash(path))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create source directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		args := []string{"-r", "--min-size", "1K", "--max-depth", "1", "--newer-than", "1d", "src", "out"}
+		stdout, stderr, err := runCLI(t, tempDir, append([]string{"--dry-run"}, args...)...)
+		if err != nil {
+			t.Fatalf("dry run failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if !strings.Contains(stdout, "Would copy 1 file(s)") ||
+			!strings.Contains(stdout, "Filtered out 1 entry(ies): 1 by size") {
+			t.Fatalf("expected planned and filtered counts, got stdout=%q", stdout)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "out")); !os.IsNotExist(err) {
+			t.Fatalf("expected dry run not to create the destination, stat returned %v", err)
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, append([]string{"-q"}, args...)...)
+		if err != nil {
+			t.Fatalf("filtered copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if !strings.Contains(stdout, "Copied 1 file(s)") || !strings.Contains(stdout, "Filtered out 1 entry(ies)") {
+			t.Fatalf("expected filtered counts in summary, got stdout=%q", stdout)
+		}
+		// The directory at the maximum depth is created, but not read.
+		entries, err := os.ReadDir(filepath.Join(tempDir, "out"))
+		if err != nil || len(entries) != 2 || entries[0].Name() != "large.bin" || entries[1].Name() != "nested" {
+			t.Fatalf("expected large.bin and nested to be copied, got %v (%v)", entries, err)
+		}
+		if entries, err := os.ReadDir(filepath.Join(tempDir, "out", "nested")); err != nil || len(entries) != 0 {
+			t.Fatalf("expected nested to be empty, got %v (%v)", entries, err)
+		}
+
+		_, stderr, err = runCLI(t, tempDir, "-r", "--newer-than", "soon", "src", "x")
+		if err == nil || !strings.Contains(stderr, "invalid time") {
+			t.Fatalf("expected newer-than validation error, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("order_flag", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		for name, size := range map[string]int{"a.bin": 300, "b.bin": 100, "c.bin": 200} {
+			if err := os.MkdirAll(filepath.Join(tempDir, "src"), 0o755); err != nil {
+				t.Fatalf("create source directory: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(tempDir, "src", name), make([]byte, size), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "-n", "-r", "--order=size-desc", "src", "out")
+		if err != nil {
+			t.Fatalf("ordered dry run failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		first, second, third := strings.Index(stdout, "a.bin"), strings.Index(stdout, "c.bin"), strings.Index(stdout, "b.bin")
+		if first < 0 || first > second || second > third {
+			t.Fatalf("expected files largest first, got stdout=%q", stdout)
+		}
+
+		for _, order := range []string{"inode", "physical"} {
+			stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "--order", order, "src", "out-"+order)
+			if err != nil || !strings.Contains(stdout, "Copied 3 file(s)") {
+				t.Fatalf("%s copy failed: %v (stdout=%q, stderr=%q)", order, err, stdout, stderr)
+			}
+		}
+
+		_, stderr, err = runCLI(t, tempDir, "-r", "--order=random", "src", "x")
+		if err == nil || !strings.Contains(stderr, "unsupported order") {
+			t.Fatalf("expected order validation error, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("compare", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tempDir, "src", "nested"), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		for _, name := range []string{"a.txt", "nested/b.txt"} {
+			if err := os.WriteFile(filepath.Join(tempDir, "src", filepath.FromSlash(name)), []byte("data"), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+		if _, stderr, err := runCLI(t, tempDir, "-q", "-r", "-p", "src", "out"); err != nil {
+			t.Fatalf("copy failed: %v (stderr=%q)", err, stderr)
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "--compare", "--checksum", "src", "out")
+		if err != nil || !strings.Contains(stdout, "Compared 2 file(s), hashing 8 B: no differences.") {
+			t.Fatalf("expected identical trees, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+
+		if err := os.WriteFile(filepath.Join(tempDir, "out", "nested", "b.txt"), []byte("DATA"), 0o644); err != nil {
+			t.Fatalf("modify destination file: %v", err)
+		}
+		stdout, stderr, err = runCLI(t, tempDir, "-q", "--compare", "--checksum", "src", "out")
+		if err == nil || !strings.Contains(stderr, "out differs from its source") {
+			t.Fatalf("expected compare to fail, got err=%v stderr=%q", err, stderr)
+		}
+		if !strings.Contains(stdout, "content  "+filepath.Join("out", "nested", "b.txt")) {
+			t.Fatalf("expected the modified file to be listed, got stdout=%q", stdout)
+		}
+	})
+
+	t.Run("manifest", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tempDir, "src", "nested"), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		for _, name := range []string{"a.txt", "nested/b.txt"} {
+			if err := os.WriteFile(filepath.Join(tempDir, "src", filepath.FromSlash(name)), []byte(name), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		for _, manifest := range []string{"SHA256SUMS", "manifest.json"} {
+			destination := "out-" + manifest
+			stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "--manifest", manifest, "src", destination)
+			if err != nil || !strings.Contains(stdout, "Wrote manifest of 2 file(s)") {
+				t.Fatalf("copy with manifest failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+			}
+
+			content, err := os.ReadFile(filepath.Join(tempDir, manifest))
+			if err != nil {
+				t.Fatalf("read manifest: %v", err)
+			}
+			if !strings.Contains(string(content), "nested/b.txt") {
+				t.Fatalf("expected relative paths in manifest, got %q", content)
+			}
+
+			stdout, stderr, err = runCLI(t, tempDir, "--check-manifest", manifest, destination)
+			if err != nil || !strings.Contains(stdout, "Checked 2 file(s), hashing 17 B: all match.") {
+				t.Fatalf("check manifest failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+			}
+		}
+
+		if err := os.WriteFile(filepath.Join(tempDir, "out-SHA256SUMS", "a.txt"), []byte("A.TXT"), 0o644); err != nil {
+			t.Fatalf("modify destination file: %v", err)
+		}
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "--check-manifest", "SHA256SUMS", "out-SHA256SUMS")
+		if err == nil || !strings.Contains(stdout, "content  "+filepath.Join("out-SHA256SUMS", "a.txt")) {
+			t.Fatalf("expected a content mismatch, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+	})
+
+	t.Run("dedupe", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tempDir, "src", "nested"), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		for _, name := range []string{"a.txt", "nested/b.txt"} {
+			if err := os.WriteFile(filepath.Join(tempDir, "src", filepath.FromSlash(name)), []byte("same contents"), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "--dedupe=hardlink", "src", "first")
+		if err != nil || !strings.Contains(stdout, "Deduplicated 1 file(s) by hard link, saving 13 B.") {
+			t.Fatalf("dedupe failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+
+		first, err := os.Stat(filepath.Join(tempDir, "first", "a.txt"))
+		if err != nil {
+			t.Fatalf("stat first copy: %v", err)
+		}
+		second, err := os.Stat(filepath.Join(tempDir, "first", "nested", "b.txt"))
+		if err != nil {
+			t.Fatalf("stat second copy: %v", err)
+		}
+		if !os.SameFile(first, second) {
+			t.Fatalf("expected the identical files to be linked")
+		}
+	})
+
+	t.Run("link_dest_snapshots", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tempDir, "src", "nested"), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		for _, name := range []string{"a.txt", "nested/b.txt", "c.txt"} {
+			if err := os.WriteFile(filepath.Join(tempDir, "src", filepath.FromSlash(name)), []byte(name), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "-p", "src", "day1")
+		if err != nil {
+			t.Fatalf("first snapshot failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		modTime := time.Now().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(tempDir, "src", "c.txt"), modTime, modTime); err != nil {
+			t.Fatalf("touch source file: %v", err)
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, "-n", "-r", "--link-dest", "day1", "src", "day2")
+		if err != nil || !strings.Contains(stdout, "link  "+filepath.Join("day1", "nested", "b.txt")+" -> "+filepath.Join("day2", "nested", "b.txt")) ||
+			!strings.Contains(stdout, "Would copy 1 file(s), 5 B total.\nWould link 2 unchanged file(s), 17 B.") {
+			t.Fatalf("dry run with link-dest failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, "-q", "-r", "--link-dest", "day1", "src", "day2")
+		if err != nil || !strings.Contains(stdout, "Copied 1 file(s), 5 B total.\nLinked 2 unchanged file(s), 17 B, from day1.") {
+			t.Fatalf("snapshot with link-dest failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		for name, linked := range map[string]bool{"a.txt": true, "nested/b.txt": true, "c.txt": false} {
+			previous, err := os.Stat(filepath.Join(tempDir, "day1", filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatalf("stat first snapshot: %v", err)
+			}
+			current, err := os.Stat(filepath.Join(tempDir, "day2", filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatalf("stat second snapshot: %v", err)
+			}
+			if os.SameFile(previous, current) != linked {
+				t.Fatalf("expected %s linked=%v", name, linked)
+			}
+		}
+	})
+
+	t.Run("target_fs_names", func(t *testing.T) {
+		t.Parallel()
+
+		if runtime.GOOS == "windows" {
+			t.Skip("Windows cannot create the source names")
+		}
+		tempDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tempDir, "src"), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		for _, name := range []string{"a:b.txt", "ok.txt", "OK.txt"} {
+			if err := os.WriteFile(filepath.Join(tempDir, "src", name), []byte(name), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "--target-fs=exfat", "src", "out")
+		if err == nil || !strings.Contains(stderr, "2 name(s) cannot be created on exfat") ||
+			!strings.Contains(stdout, "character "+filepath.Join("out", "a:b.txt")) ||
+			!strings.Contains(stdout, "case      "+filepath.Join("out", "ok.txt")+" (same as "+filepath.Join("out", "OK.txt")+")") {
+			t.Fatalf("expected incompatible names to be reported, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "out")); !os.IsNotExist(err) {
+			t.Fatalf("expected nothing to be copied, got %v", err)
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, "-q", "-r", "--target-fs=exfat", "--sanitize", "names.json", "src", "out")
+		if err != nil || !strings.Contains(stdout, "Renamed 2 name(s) for the destination filesystem; the originals are in names.json.") {
+			t.Fatalf("sanitized copy failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		for _, name := range []string{"a：b.txt", "OK.txt", "ok~2.txt"} {
+			if _, err := os.Stat(filepath.Join(tempDir, "out", name)); err != nil {
+				t.Fatalf("expected sanitized name %q: %v", name, err)
+			}
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, "--restore-names", "names.json", "out")
+		if err != nil || !strings.Contains(stdout, "Restored 2 name(s).") {
+			t.Fatalf("restore names failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		for _, name := range []string{"a:b.txt", "ok.txt", "OK.txt"} {
+			if _, err := os.Stat(filepath.Join(tempDir, "out", name)); err != nil {
+				t.Fatalf("expected restored name %q: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("sanitize_map_written_on_failure", func(t *testing.T) {
+		t.Parallel()
+
+		if runtime.GOOS == "windows" {
+			t.Skip("Windows cannot create the source names")
+		}
+		tempDir := t.TempDir()
+		for _, name := range []string{"src/a:b.txt", "src/ok.txt", "src/OK.txt", "src/zz.txt", "out/src/zz.txt"} {
+			path := filepath.Join(tempDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+		}
+
+		// out exists, so src is copied into it, where zz.txt already is: the
+		// copy fails after the renamed files were written.
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "-r", "--target-fs=exfat", "--sanitize", "names.json", "src", "out")
+		if err == nil || !strings.Contains(stderr, "destination file exists") ||
+			!strings.Contains(stdout, "Names renamed for the destination filesystem are listed in names.json.") {
+			t.Fatalf("expected the copy to fail and keep the name map, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+
+		stdout, stderr, err = runCLI(t, tempDir, "--restore-names", "names.json", "out")
+		if err != nil || !strings.Contains(stdout, "Restored 2 name(s).") {
+			t.Fatalf("restore names failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "out", "src", "a:b.txt")); err != nil {
+			t.Fatalf("expected a:b.txt to be restored: %v", err)
+		}
+	})
+
+	t.Run("conflicting_sources", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		for _, name := range []string{"a/config", "b/config", "dir/file"} {
+			path := filepath.Join(tempDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create source directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+		if err := os.Mkdir(filepath.Join(tempDir, "dest"), 0o755); err != nil {
+			t.Fatalf("create destination: %v", err)
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "-q", "-f", filepath.Join("a", "config"), filepath.Join("b", "config"), "dest")
+		want := "duplicate " + filepath.Join("dest", "config") + " (from " + filepath.Join("a", "config") + ", " + filepath.Join("b", "config") + ")"
+		if err == nil || !strings.Contains(stdout, want) || !strings.Contains(stderr, "1 destination path(s) are planned for more than one source") {
+			t.Fatalf("expected the conflict to be reported, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "dest", "config")); !os.IsNotExist(err) {
+			t.Fatalf("expected nothing to be copied, got %v", err)
+		}
+
+		// A file listed along with its directory is copied once.
+		list := "dir\n" + filepath.Join("dir", "file") + "\n"
+		stdout, stderr, err = runCLIWithStdin(t, tempDir, list, "-q", "-r", "--files-from", "-", "dest")
+		if err != nil || !strings.Contains(stdout, "Copied 1 file(s)") {
+			t.Fatalf("copy of a file listed twice failed: %v (stdout=%q, stderr=%q)", err, stdout, stderr)
+		}
+	})
+
+	t.Run("log_file", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tempDir, "source.txt"), []byte("logged"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		for range 2 {
+			runCLI(t, tempDir, "-q", "--log-file", "zcp.log", "source.txt", "copy.txt")
+		}
+		content, err := os.ReadFile(filepath.Join(tempDir, "zcp.log"))
+		if err != nil {
+			t.Fatalf("read log: %v", err)
+		}
+		for _, want := range []string{
+			"level=INFO msg=copy source=source.txt destination=copy.txt size=6 written=6",
+			"level=ERROR msg=error source=source.txt destination=copy.txt",
+			"level=ERROR msg=finish",
+		} {
+			if !strings.Contains(string(content), want) {
+				t.Fatalf("expected %q in log, got %q", want, content)
+			}
+		}
+
+		_, stderr, err := runCLI(t, tempDir, "--log-file", "zcp.log", "--log-format", "xml", "source.txt", "other.txt")
+		if err == nil || !strings.Contains(stderr, `unsupported log format "xml"`) {
+			t.Fatalf("expected an invalid log format to fail, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("config_and_profiles", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		configDir := filepath.Join(tempDir, "config")
+		if err := os.MkdirAll(filepath.Join(configDir, "zcp"), 0o755); err != nil {
+			t.Fatalf("create config directory: %v", err)
+		}
+		config := "preserve = true\nquiet = true\n\n[profile.backup]\nlog-file = \"backup.log\"\nretries = 3\n"
+		if err := os.WriteFile(filepath.Join(configDir, "zcp", "config.toml"), []byte(config), 0o644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("configured"), 0o600); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		if err := os.Chtimes(sourceFile, modTime, modTime); err != nil {
+			t.Fatalf("set modtime: %v", err)
+		}
+		env := []string{"XDG_CONFIG_HOME=" + configDir, "ZCP_OPTIONS=--retries 4 --log-format json"}
+
+		stdout, stderr, err := runCLIWithEnv(t, tempDir, "", env, "--profile", "backup", "--retries", "5", "--print-config")
+		if err != nil {
+			t.Fatalf("print config: %v, stderr=%q", err, stderr)
+		}
+		for _, want := range []string{
+			"# Config file: " + filepath.Join(configDir, "zcp", "config.toml") + "\n",
+			`log-file = "backup.log"`,
+			"# profile backup",
+			`log-format = "json"`,
+			"# ZCP_OPTIONS",
+			"retries = 5",
+			"# command line",
+			"preserve = true",
+			"# config",
+			`order = "walk"`,
+			"# default",
+		} {
+			if !strings.Contains(stdout, want) {
+				t.Fatalf("expected %q in the effective settings, got %q", want, stdout)
+			}
+		}
+
+		stdout, stderr, err = runCLIWithEnv(t, tempDir, "", env, "--profile", "backup", "source.txt", "copy.txt")
+		if err != nil {
+			t.Fatalf("copy with profile: %v, stderr=%q", err, stderr)
+		}
+		info, err := os.Stat(filepath.Join(tempDir, "copy.txt"))
+		if err != nil || !info.ModTime().Equal(modTime) {
+			t.Fatalf("expected the config file's -p to keep the modification time, got %v, %v", info, err)
+		}
+		if strings.Contains(stdout, "%") {
+			t.Fatalf("expected the config file's -q to hide progress, got %q", stdout)
+		}
+		if log, err := os.ReadFile(filepath.Join(tempDir, "backup.log")); err != nil || !strings.Contains(string(log), `"msg":"copy"`) {
+			t.Fatalf("expected the profile's JSON log, got %q, %v", log, err)
+		}
+
+		_, stderr, err = runCLIWithEnv(t, tempDir, "", env, "--profile", "nightly", "source.txt", "other.txt")
+		if err == nil || !strings.Contains(stderr, `unknown profile "nightly"`) {
+			t.Fatalf("expected an unknown profile to fail, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("completion_and_man", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		for shell, want := range map[string]string{
+			"bash": "complete -o filenames -F _zcp zcp",
+			"zsh":  "#compdef zcp",
+			"fish": "complete -c zcp -s p -l preserve",
+		} {
+			stdout, stderr, err := runCLI(t, tempDir, "completion", shell)
+			if err != nil || !strings.Contains(stdout, want) {
+				t.Fatalf("expected the %s completion to contain %q, got err=%v stdout=%q stderr=%q", shell, want, err, stdout, stderr)
+			}
+		}
+		_, stderr, err := runCLI(t, tempDir, "completion", "tcsh")
+		if err == nil || !strings.Contains(stderr, `unsupported shell "tcsh"`) {
+			t.Fatalf("expected an unsupported shell to fail, got err=%v stderr=%q", err, stderr)
+		}
+
+		stdout, stderr, err := runCLI(t, tempDir, "--man")
+		if err != nil || !strings.HasPrefix(stdout, ".TH ZCP 1") || !strings.Contains(stdout, `\fB\-\-link\-dest\fR=\fIDIR\fR`) {
+			t.Fatalf("expected a man page, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+
+		// A file named completion is still copied when written as a path,
+		// and makes the bare subcommand ambiguous.
+		if err := os.WriteFile(filepath.Join(tempDir, "completion"), []byte("not a command"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+		_, stderr, err = runCLI(t, tempDir, "completion", "bash")
+		if err == nil || !strings.Contains(stderr, "write ./completion to copy the file") {
+			t.Fatalf("expected an ambiguous completion command to fail, got err=%v stderr=%q", err, stderr)
+		}
+		if _, stderr, err := runCLI(t, tempDir, "-q", "./completion", "bash"); err != nil {
+			t.Fatalf("copy a file named completion: %v, stderr=%q", err, stderr)
+		}
+		if content, err := os.ReadFile(filepath.Join(tempDir, "bash")); err != nil || string(content) != "not a command" {
+			t.Fatalf("expected the file to be copied, got %q, %v", content, err)
+		}
+	})
+
+	t.Run("term_progress", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tempDir, "source.iso"), bytes.Repeat([]byte("t"), 4096), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		// Escape sequences are only written to terminals.
+		stdout, stderr, err := runCLIWithEnv(t, tempDir, "", []string{"WT_SESSION=1"}, "--term-progress=on", "source.iso", "copy.iso")
+		if err != nil || strings.Contains(stdout, "\x1b") {
+			t.Fatalf("expected a copy without escape sequences, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+
+		_, stderr, err = runCLI(t, tempDir, "--term-progress=always", "source.iso", "other.iso")
+		if err == nil || !strings.Contains(stderr, `unsupported term-progress "always"`) {
+			t.Fatalf("expected an invalid --term-progress to fail, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tempDir, "source.iso"), bytes.Repeat([]byte("q"), 4096), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+		// Socket paths are limited to about 100 bytes, which test
+		// directories can exceed.
+		socketDir, err := os.MkdirTemp("", "zcpq")
+		if err != nil {
+			t.Fatalf("create socket directory: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(socketDir) })
+		env := []string{"ZCP_QUEUE_SOCKET=" + filepath.Join(socketDir, "queue.sock")}
+
+		stdout, _, err := runCLIWithEnv(t, tempDir, "", env, "queue", "ls")
+		if err != nil || !strings.Contains(stdout, "No queued jobs.") {
+			t.Fatalf("expected an empty queue without a daemon, got err=%v stdout=%q", err, stdout)
+		}
+
+		daemon := exec.Command(zcpBinary(t), "queue", "daemon")
+		daemon.Env = append(os.Environ(), env...)
+		if err := daemon.Start(); err != nil {
+			t.Fatalf("start queue daemon: %v", err)
+		}
+		t.Cleanup(func() {
+			daemon.Process.Kill()
+			daemon.Wait()
+		})
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+			if _, statErr := os.Stat(filepath.Join(socketDir, "queue.sock")); statErr == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("queue daemon did not start listening")
+			}
+		}
+
+		stdout, stderr, err := runCLIWithEnv(t, tempDir, "", env, "--queue", "-q", "source.iso", "copy.iso")
+		if err != nil || !strings.Contains(stdout, "Queued job 1.") || !strings.Contains(stdout, "Copied 1 file(s), 4.0 KiB total.") {
+			t.Fatalf("expected the queued copy to run, got err=%v stdout=%q stderr=%q", err, stdout, stderr)
+		}
+		if copied, err := os.ReadFile(filepath.Join(tempDir, "copy.iso")); err != nil || len(copied) != 4096 {
+			t.Fatalf("expected the queued copy in the working directory, got %d bytes, err=%v", len(copied), err)
+		}
+
+		stdout, _, err = runCLIWithEnv(t, tempDir, "", env, "queue", "ls")
+		if err != nil || !strings.Contains(stdout, "done") || !strings.Contains(stdout, filepath.Join(tempDir, "copy.iso")) {
+			t.Fatalf("expected the finished job to be listed, got err=%v stdout=%q", err, stdout)
+		}
+
+		_, stderr, err = runCLIWithEnv(t, tempDir, "", env, "queue", "cancel", "7")
+		if err == nil || !strings.Contains(stderr, "no job 7") {
+			t.Fatalf("expected canceling an unknown job to fail, got err=%v stderr=%q", err, stderr)
+		}
+
+		_, stderr, err = runCLIWithEnv(t, tempDir, "", env, "--queue", "-", "copy.txt")
+		if err == nil || !strings.Contains(stderr, "--queue cannot copy standard input") {
+			t.Fatalf("expected queuing a stream to fail, got err=%v stderr=%q", err, stderr)
+		}
+
+		if err := os.WriteFile(filepath.Join(tempDir, "queue"), []byte("not a command"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+		_, stderr, err = runCLIWithEnv(t, tempDir, "", env, "queue", "ls")
+		if err == nil || !strings.Contains(stderr, "write ./queue to copy the file") {
+			t.Fatalf("expected an ambiguous queue command to fail, got err=%v stderr=%q", err, stderr)
+		}
+	})
+
 	t.Run("buffer_size_validation", func(t *testing.T) {
 		t.Parallel()
 
@@ -309,9 +1174,24 @@ func TestCLIFlagsE2E(t *testing.T) {
 
 func runCLI(t *testing.T, workingDirectory string, args ...string) (string, string, error) {
 	t.Helper()
+	return runCLIWithStdin(t, workingDirectory, "", args...)
+}
+
+func runCLIWithStdin(t *testing.T, workingDirectory string, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+	return runCLIWithEnv(t, workingDirectory, stdin, nil, args...)
+}
+
+// runCLIWithEnv runs zcp with env added to its environment. Without env, no
+// config file or ZCP_OPTIONS apply.
+func runCLIWithEnv(t *testing.T, workingDirectory string, stdin string, env []string, args ...string) (string, string, error) {
+	t.Helper()
 
 	command := exec.Command(zcpBinary(t), args...)
 	command.Dir = workingDirectory
+	command.Stdin = strings.NewReader(stdin)
+	command.Env = append(os.Environ(), "XDG_CONFIG_HOME="+filepath.Join(workingDirectory, "no-config"), "ZCP_OPTIONS=")
+	command.Env = append(command.Env, env...)
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	if err := zcp.Run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+	if err := zcp.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
 		fmt.Fprintf(os.Stderr, "zcp: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,463 @@
+package copier
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type archiveFormat int
+
+const (
+	archiveTar archiveFormat = iota + 1
+	archiveTarGzip
+	archiveTarZstd
+	archiveZip
+)
+
+func detectArchiveFormat(path string) (archiveFormat, error) {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar, nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGzip, nil
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return archiveTarZstd, nil
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip, nil
+	default:
+		return 0, fmt.Errorf("unsupported archive %q (expected .tar, .tar.gz, .tar.zst or .zip)", path)
+	}
+}
+
+// compression returns how a tar archive's stream is compressed.
+func (f archiveFormat) compression() Compression {
+	switch f {
+	case archiveTarGzip:
+		return CompressionGzip
+	case archiveTarZstd:
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// openArchiveStream opens a tar archive and wraps it in its decompressor. The
+// closers must be closed in reverse order once the stream is no longer needed.
+func openArchiveStream(path string, format archiveFormat) (io.Reader, []io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open archive %q: %w", path, err)
+	}
+
+	compression := format.compression()
+	if compression == CompressionNone {
+		return file, []io.Closer{file}, nil
+	}
+
+	decompressor, err := newDecompressor(compression, file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("open archive %q: %w", path, err)
+	}
+	return decompressor, []io.Closer{file, decompressor}, nil
+}
+
+// sourceArchive is an archive given as a SOURCE, opened as a filesystem.
+type sourceArchive struct {
+	path   string
+	info   fs.FileInfo
+	fsys   fs.FS
+	closer io.Closer
+}
+
+type sourceArchives []sourceArchive
+
+func openSourceArchives(paths []string) (sourceArchives, error) {
+	archives := make(sourceArchives, 0, len(paths))
+	for _, path := range paths {
+		archive, err := openSourceArchive(filepath.Clean(path))
+		if err != nil {
+			archives.Close()
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return archives, nil
+}
+
+func openSourceArchive(path string) (sourceArchive, error) {
+	format, err := detectArchiveFormat(path)
+	if err != nil {
+		return sourceArchive{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return sourceArchive{}, fmt.Errorf("stat source %q: %w", path, err)
+	}
+	if info.IsDir() {
+		return sourceArchive{}, fmt.Errorf("archive source %q is a directory", path)
+	}
+
+	if format == archiveZip {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return sourceArchive{}, fmt.Errorf("open archive %q: %w", path, err)
+		}
+		return sourceArchive{path: path, info: info, fsys: reader, closer: reader}, nil
+	}
+
+	fsys, err := newTarFS(path, format, info.ModTime())
+	if err != nil {
+		return sourceArchive{}, err
+	}
+	return sourceArchive{path: path, info: info, fsys: fsys, closer: fsys}, nil
+}
+
+func (a sourceArchives) Close() error {
+	var errs []error
+	for _, archive := range a {
+		errs = append(errs, archive.closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// planArchiveSources plans extracting each archive's contents directly into
+// the destination directory, like tar -x -C DEST, leaving out the entries
+// filter excludes.
+func planArchiveSources(archives sourceArchives, dest planDestination, filter Filter) (Plan, error) {
+	if dest.exists && !dest.isDir {
+		return Plan{}, fmt.Errorf("cannot extract archive into non-directory %q", dest.path)
+	}
+
+	plan := Plan{Operations: make([]Operation, 0, 16)}
+
+	for _, archive := range archives {
+		start := len(plan.Operations)
+		err := fs.WalkDir(archive.fsys, ".", func(path string, entry fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+
+			if entry.Type()&fs.ModeSymlink != 0 {
+				return fmt.Errorf("symbolic links are not supported: %q", path)
+			}
+
+			entryInfo, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			if reason := filter.excludes(path, "/", entryInfo, &plan.Filtered); reason != "" {
+				filter.skip(path, reason, entryInfo)
+				return skipEntry(entry)
+			}
+
+			destinationPath := filepath.Join(dest.path, filepath.FromSlash(path))
+
+			if entry.IsDir() {
+				mode, modTime := entryInfo.Mode(), entryInfo.ModTime()
+				if path == "." {
+					mode, modTime = fs.ModeDir|0o755, archive.info.ModTime()
+				}
+				plan.Operations = append(plan.Operations, Operation{
+					Kind:        OperationCreateDirectory,
+					Source:      path,
+					Destination: destinationPath,
+					Mode:        mode,
+					ModTime:     modTime,
+					fsys:        archive.fsys,
+				})
+				if filter.stopsAt(path, "/") {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			size := entryInfo.Size()
+			if size < 0 {
+				size = 0
+			}
+
+			plan.Operations = append(plan.Operations, Operation{
+				Kind:        OperationCopyFile,
+				Source:      path,
+				Destination: destinationPath,
+				Mode:        entryInfo.Mode(),
+				ModTime:     entryInfo.ModTime(),
+				Size:        uint64(size),
+				fsys:        archive.fsys,
+			})
+			plan.TotalBytes += uint64(size)
+			return nil
+		})
+		if err != nil {
+			return Plan{}, fmt.Errorf("walk archive %q: %w", archive.path, err)
+		}
+		if tar, ok := archive.fsys.(*tarFS); ok {
+			tar.orderEntries(plan.Operations[start:])
+		}
+	}
+
+	return plan, nil
+}
+
+// archiveWriter writes plan operations as entries of an archive.
+type archiveWriter interface {
+	createDirectory(name string, op Operation) error
+	createFile(name string, op Operation) (io.Writer, error)
+	Close() error
+}
+
+// executeArchivePlan writes a plan whose destinations are entry names into the
+// archive at archivePath instead of onto the filesystem. Modes and
+// modification times are always recorded.
+func executeArchivePlan(
+	ctx context.Context,
+	plan []Operation,
+	archivePath string,
+	opts Options,
+	observer Observer,
+) (Stats, error) {
+	e := newExecution(plan, opts, observer)
+	err := e.runArchive(ctx, plan, archivePath)
+	e.stats.Retries = e.retries.Load()
+	return e.stats, err
+}
+
+func (e *execution) runArchive(ctx context.Context, plan []Operation, archivePath string) error {
+	format, err := detectArchiveFormat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	if err := e.mkdirAll(filepath.Dir(archivePath), 0o755); err != nil {
+		return fmt.Errorf("create destination parent for %q: %w", archivePath, err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !e.opts.Force {
+		flags |= os.O_EXCL
+	}
+
+	archiveFile, err := e.backend.OpenFile(archivePath, flags, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("destination file exists (use -f to overwrite): %q", archivePath)
+		}
+		return fmt.Errorf("open destination file %q: %w", archivePath, err)
+	}
+	destination := e.newResumingWriter(ctx, archivePath, 0o644, archiveFile, false)
+	defer destination.Close()
+
+	writer, err := newArchiveWriter(destination, format)
+	if err != nil {
+		return fmt.Errorf("create archive %q: %w", archivePath, err)
+	}
+
+	if err := e.writeArchiveEntries(ctx, plan, writer); err != nil {
+		writer.Close()
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("finish archive %q: %w", archivePath, err)
+	}
+	if err := e.syncOpenFile(destination.file, archivePath); err != nil {
+		return err
+	}
+	if err := destination.Close(); err != nil {
+		return fmt.Errorf("close destination file %q: %w", archivePath, err)
+	}
+	if err := e.syncWrittenFile(archivePath); err != nil {
+		return err
+	}
+	return e.syncAll(archivePath)
+}
+
+func (e *execution) writeArchiveEntries(ctx context.Context, plan []Operation, writer archiveWriter) error {
+	for _, op := range plan {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(op.Destination)
+
+		switch op.Kind {
+		case OperationCreateDirectory:
+			if name == "" || name == "." {
+				continue
+			}
+			event := e.startEvent(EventMkdir, op)
+			if err := writer.createDirectory(name, op); err != nil {
+				err = fmt.Errorf("write archive entry %q: %w", name, err)
+				e.finishEvent(event, err)
+				e.observer.Error(op, err)
+				return err
+			}
+			e.finishEvent(event, nil)
+
+		case OperationCopyFile:
+			e.observer.FileStart(op)
+			event := e.startEvent(EventCopy, op)
+			err := e.writeArchiveFile(ctx, name, op, writer)
+			e.finishEvent(event, err)
+			if err != nil {
+				e.observer.Error(op, err)
+				return err
+			}
+			e.observer.FileDone(op)
+
+		default:
+			return fmt.Errorf("unsupported copy operation: %v", op.Kind)
+		}
+	}
+	return nil
+}
+
+func (e *execution) writeArchiveFile(ctx context.Context, name string, op Operation, writer archiveWriter) error {
+	// The entry's header records its size, so a source that changed since
+	// it was planned is checked first, while it can still be archived as it
+	// is now.
+	changed := len(e.stats.Changed)
+	updated, retry, err := e.checkSource(op, 0, true)
+	if err != nil {
+		return err
+	}
+	if retry {
+		op = updated
+	}
+
+	entry, err := writer.createFile(name, op)
+	if err != nil {
+		return fmt.Errorf("write archive entry %q: %w", name, err)
+	}
+	sized := &sizedEntryWriter{writer: entry, remaining: op.Size}
+	if err := e.transfer(ctx, op, sized); err != nil {
+		return err
+	}
+	if err := sized.pad(); err != nil {
+		return fmt.Errorf("write archive entry %q: %w", name, err)
+	}
+
+	// Entries already written cannot be rewritten, so changes are never
+	// retried, and a source already listed as changed is not listed again.
+	if len(e.stats.Changed) > changed && !retry {
+		return nil
+	}
+	_, _, err = e.checkSource(op, 1, false)
+	return err
+}
+
+// sizedEntryWriter writes exactly the size an archive entry's header
+// records, dropping what a source that grew while it was read adds and
+// padding one that shrank with zeros. checkSource reports such changes.
+type sizedEntryWriter struct {
+	writer    io.Writer
+	remaining uint64
+}
+
+func (w *sizedEntryWriter) Write(p []byte) (int, error) {
+	data := p[:min(uint64(len(p)), w.remaining)]
+	n, err := w.writer.Write(data)
+	w.remaining -= uint64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
+
+// pad writes zeros for what the source did not provide.
+func (w *sizedEntryWriter) pad() error {
+	zeros := make([]byte, min(w.remaining, 32*1024))
+	for w.remaining > 0 {
+		if _, err := w.Write(zeros[:min(w.remaining, uint64(len(zeros)))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newArchiveWriter(destination io.Writer, format archiveFormat) (archiveWriter, error) {
+	if format == archiveZip {
+		return &zipArchiveWriter{writer: zip.NewWriter(destination)}, nil
+	}
+
+	compression := format.compression()
+	if compression == CompressionNone {
+		return &tarArchiveWriter{writer: tar.NewWriter(destination)}, nil
+	}
+
+	compressor, err := newCompressor(compression, destination)
+	if err != nil {
+		return nil, err
+	}
+	return &tarArchiveWriter{writer: tar.NewWriter(compressor), compressor: compressor}, nil
+}
+
+type tarArchiveWriter struct {
+	writer     *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (w *tarArchiveWriter) createDirectory(name string, op Operation) error {
+	return w.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(op.Mode.Perm()),
+		ModTime:  op.ModTime,
+	})
+}
+
+func (w *tarArchiveWriter) createFile(name string, op Operation) (io.Writer, error) {
+	err := w.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(op.Mode.Perm()),
+		ModTime:  op.ModTime,
+		Size:     int64(op.Size),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w.writer, nil
+}
+
+func (w *tarArchiveWriter) Close() error {
+	err := w.writer.Close()
+	if w.compressor != nil {
+		err = errors.Join(err, w.compressor.Close())
+	}
+	return err
+}
+
+type zipArchiveWriter struct {
+	writer *zip.Writer
+}
+
+func (w *zipArchiveWriter) createDirectory(name string, op Operation) error {
+	header := &zip.FileHeader{Name: name + "/", Method: zip.Store, Modified: op.ModTime}
+	header.SetMode(op.Mode)
+	_, err := w.writer.CreateHeader(header)
+	return err
+}
+
+func (w *zipArchiveWriter) createFile(name string, op Operation) (io.Writer, error) {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: op.ModTime}
+	header.SetMode(op.Mode)
+	header.UncompressedSize64 = op.Size
+	return w.writer.CreateHeader(header)
+}
+
+func (w *zipArchiveWriter) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,215 @@
+package copier
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchives(t *testing.T) {
+	t.Parallel()
+
+	writeTree := func(t *testing.T, root string, modTime time.Time) {
+		t.Helper()
+
+		files := map[string]string{
+			"top.txt":           "top-level",
+			"nested/deeper.txt": "nested contents",
+			"nested/b/last.txt": strings.Repeat("z", 4096),
+		}
+		for name, contents := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("mkdir %q: %v", filepath.Dir(path), err)
+			}
+			if err := os.WriteFile(path, []byte(contents), 0o640); err != nil {
+				t.Fatalf("write %q: %v", path, err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("set times on %q: %v", path, err)
+			}
+		}
+	}
+
+	for _, extension := range []string{".tar", ".tar.gz", ".tar.zst", ".zip"} {
+		t.Run("round_trip"+strings.ReplaceAll(extension, ".", "_"), func(t *testing.T) {
+			t.Parallel()
+
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "tree")
+			modTime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+			writeTree(t, sourceDir, modTime)
+
+			archivePath := filepath.Join(tempDir, "bundle"+extension)
+			packer := New(Options{Recursive: true, ToArchive: true}, nil)
+			packed, err := packer.Copy(context.Background(), []string{sourceDir}, archivePath)
+			if err != nil {
+				t.Fatalf("write archive: %v", err)
+			}
+
+			extractDir := filepath.Join(tempDir, "extracted")
+			unpacker := New(Options{Preserve: true, FromArchive: true}, nil)
+			unpacked, err := unpacker.Copy(context.Background(), []string{archivePath}, extractDir)
+			if err != nil {
+				t.Fatalf("extract archive: %v", err)
+			}
+
+			if unpacked.Plan.Files() != 3 || unpacked.Plan.TotalBytes != packed.Plan.TotalBytes {
+				t.Fatalf(
+					"expected 3 files and %d bytes extracted, got %d and %d",
+					packed.Plan.TotalBytes,
+					unpacked.Plan.Files(),
+					unpacked.Plan.TotalBytes,
+				)
+			}
+
+			for _, name := range []string{"top.txt", "nested/deeper.txt", "nested/b/last.txt"} {
+				expected, err := os.ReadFile(filepath.Join(sourceDir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatalf("read source %q: %v", name, err)
+				}
+
+				extracted := filepath.Join(extractDir, "tree", filepath.FromSlash(name))
+				actual, err := os.ReadFile(extracted)
+				if err != nil {
+					t.Fatalf("read extracted %q: %v", name, err)
+				}
+				if string(actual) != string(expected) {
+					t.Fatalf("content mismatch for %q", name)
+				}
+
+				info, err := os.Stat(extracted)
+				if err != nil {
+					t.Fatalf("stat extracted %q: %v", name, err)
+				}
+				if info.Mode().Perm() != 0o640 {
+					t.Fatalf("expected mode 0640 for %q, got %o", name, info.Mode().Perm())
+				}
+				if !info.ModTime().Equal(modTime) {
+					t.Fatalf("expected modtime %v for %q, got %v", modTime, name, info.ModTime())
+				}
+			}
+		})
+	}
+
+	t.Run("reads_tar_entries_out_of_order", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		archivePath := filepath.Join(tempDir, "unordered.tar")
+		file, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatalf("create archive: %v", err)
+		}
+		writer := tar.NewWriter(file)
+		for _, entry := range []struct{ name, body string }{
+			{name: "./z/last.txt", body: "written first"},
+			{name: "a.txt", body: "written second"},
+		} {
+			header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.body))}
+			if err := writer.WriteHeader(header); err != nil {
+				t.Fatalf("write header: %v", err)
+			}
+			if _, err := writer.Write([]byte(entry.body)); err != nil {
+				t.Fatalf("write body: %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("close tar writer: %v", err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("close archive: %v", err)
+		}
+
+		// Files are planned in the order they are stored, after the
+		// directories they go in, so the stream is read in one pass.
+		extractDir := filepath.Join(tempDir, "out")
+		plan, err := New(Options{FromArchive: true}, nil).Plan(context.Background(), []string{archivePath}, extractDir)
+		if err != nil {
+			t.Fatalf("plan: %v", err)
+		}
+		var planned []string
+		for _, op := range plan.Operations {
+			planned = append(planned, op.Source)
+		}
+		if want := []string{".", "z", "z/last.txt", "a.txt"}; !slices.Equal(planned, want) {
+			t.Fatalf("expected entries planned in archive order %v, got %v", want, planned)
+		}
+
+		_, err = New(Options{FromArchive: true}, nil).Copy(context.Background(), []string{archivePath}, extractDir)
+		if err != nil {
+			t.Fatalf("extract archive: %v", err)
+		}
+
+		for name, want := range map[string]string{"z/last.txt": "written first", "a.txt": "written second"} {
+			actual, err := os.ReadFile(filepath.Join(extractDir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatalf("read %q: %v", name, err)
+			}
+			if string(actual) != want {
+				t.Fatalf("unexpected contents for %q: %q", name, actual)
+			}
+		}
+	})
+
+	t.Run("rejects_unknown_archive_extension", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("data"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		_, err := New(Options{ToArchive: true}, nil).Copy(
+			context.Background(),
+			[]string{sourceFile},
+			filepath.Join(tempDir, "out.rar"),
+		)
+		if err == nil || !strings.Contains(err.Error(), "unsupported archive") {
+			t.Fatalf("expected unsupported archive error, got %v", err)
+		}
+	})
+
+	t.Run("rejects_compressing_entries", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		writeTree(t, filepath.Join(tempDir, "tree"), time.Now())
+
+		for _, opts := range []Options{
+			{Recursive: true, ToArchive: true, Compress: CompressionGzip},
+			{Recursive: true, ToArchive: true, Decompress: true},
+		} {
+			archivePath := filepath.Join(tempDir, "out.tar")
+			_, err := New(opts, nil).Copy(context.Background(), []string{filepath.Join(tempDir, "tree")}, archivePath)
+			if err == nil || !strings.Contains(err.Error(), "cannot be used when writing an archive") {
+				t.Fatalf("expected compression into an archive to be rejected, got %v", err)
+			}
+			if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+				t.Fatalf("expected no archive to be written, got %v", err)
+			}
+		}
+	})
+
+	t.Run("refuses_archive_inside_source_directory", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		writeTree(t, tempDir, time.Now())
+
+		_, err := New(Options{Recursive: true, ToArchive: true}, nil).Copy(
+			context.Background(),
+			[]string{tempDir},
+			filepath.Join(tempDir, "self.tar"),
+		)
+		if err == nil || !strings.Contains(err.Error(), "into itself") {
+			t.Fatalf("expected error for archive inside source, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package copier
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Backend is the filesystem a copy writes to. Paths are the destination paths
+// from the plan. Errors for files that already exist must wrap fs.ErrExist and
+// errors for missing files fs.ErrNotExist.
+type Backend interface {
+	// OpenFile opens a file for writing with os.OpenFile flags, creating it
+	// with perm if needed.
+	OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error)
+	// MkdirAll creates a directory and any missing parents.
+	MkdirAll(name string, perm fs.FileMode) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Stat(name string) (fs.FileInfo, error)
+	Rename(oldName string, newName string) error
+}
+
+// LocalBackend writes to the local filesystem. It is the default Backend.
+type LocalBackend struct{}
+
+func (LocalBackend) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (LocalBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (LocalBackend) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (LocalBackend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (LocalBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (LocalBackend) Rename(oldName string, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func isLocalBackend(backend Backend) bool {
+	_, ok := backend.(LocalBackend)
+	return ok
+}
+
+// SyncBackend is implemented by backends that can flush written data to stable
+// storage, which --fsync needs.
+type SyncBackend interface {
+	Backend
+	// SyncFile flushes a file that has already been written and closed.
+	SyncFile(name string) error
+	// SyncDir flushes a directory's entries, so files created in it survive
+	// a crash.
+	SyncDir(name string) error
+	// SyncFS flushes the whole filesystem containing name. It returns
+	// errors.ErrUnsupported where that is not possible.
+	SyncFS(name string) error
+}
+
+func (LocalBackend) SyncFile(name string) error {
+	return syncFile(name)
+}
+
+func (LocalBackend) SyncDir(name string) error {
+	return syncDirectory(name)
+}
+
+func (LocalBackend) SyncFS(name string) error {
+	return syncFilesystem(name)
+}
@@ -0,0 +1,111 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// ChangePolicy says what happens when a source file changes while it is being
+// copied.
+type ChangePolicy string
+
+const (
+	// ChangeWarn keeps the copy and lists the file in Stats.Changed.
+	ChangeWarn ChangePolicy = ""
+	// ChangeRetry copies the file again, up to maxChangeRetries times.
+	ChangeRetry ChangePolicy = "retry"
+	// ChangeFail stops the copy with an error.
+	ChangeFail ChangePolicy = "fail"
+)
+
+// RetryObserver is an Observer that is told when a file is copied again
+// because its source changed. The n bytes of op already passed to Bytes are
+// read, and passed on, again, so a progress total can take them back.
+type RetryObserver interface {
+	Observer
+	FileRetry(op Operation, n uint64)
+}
+
+// maxChangeRetries bounds ChangeRetry for files that never stop changing,
+// such as busy logs.
+const maxChangeRetries = 3
+
+// ParseChangePolicy parses an --on-change value.
+func ParseChangePolicy(value string) (ChangePolicy, error) {
+	switch value {
+	case "", "warn":
+		return ChangeWarn, nil
+	case string(ChangeRetry), string(ChangeFail):
+		return ChangePolicy(value), nil
+	default:
+		return ChangeWarn, fmt.Errorf("unsupported on-change policy %q (expected warn, retry or fail)", value)
+	}
+}
+
+// sourceChanged re-stats op's source and reports whether it differs from when
+// the plan was built: replaced by another file, or a different size or
+// modification time. It also returns the source's current info, which is nil
+// if it has been removed. Sources inside archives cannot change.
+func sourceChanged(op Operation) (bool, fs.FileInfo, error) {
+	if op.fsys != nil || op.info == nil {
+		return false, nil, nil
+	}
+
+	current, err := os.Stat(op.Source)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("stat source %q: %w", op.Source, err)
+	}
+
+	changed := !os.SameFile(op.info, current) ||
+		current.Size() != op.info.Size() ||
+		!current.ModTime().Equal(op.info.ModTime())
+	return changed, current, nil
+}
+
+// checkSource applies the OnChange policy once op has been copied. It returns
+// op updated to the source's current state when the file should be copied
+// again.
+func (e *execution) checkSource(op Operation, attempt int, canRetry bool) (Operation, bool, error) {
+	changed, current, err := sourceChanged(op)
+	if err != nil || !changed {
+		return op, false, err
+	}
+
+	switch e.opts.OnChange {
+	case ChangeFail:
+		return op, false, fmt.Errorf("source file %q changed while it was being copied", op.Source)
+	case ChangeRetry:
+		if !canRetry {
+			return op, false, fmt.Errorf("source file %q changed while it was being copied and cannot be copied again", op.Source)
+		}
+		if current == nil || attempt >= maxChangeRetries {
+			return op, false, fmt.Errorf("source file %q kept changing while it was being copied", op.Source)
+		}
+		if attempt == 0 {
+			e.stats.Changed = append(e.stats.Changed, op.Source)
+		}
+		return withSourceInfo(op, current), true, nil
+	default:
+		e.stats.Changed = append(e.stats.Changed, op.Source)
+		return op, false, nil
+	}
+}
+
+// withSourceInfo returns op planned from the source's info.
+func withSourceInfo(op Operation, info fs.FileInfo) Operation {
+	size := info.Size()
+	if size < 0 {
+		size = 0
+	}
+
+	op.Mode = info.Mode()
+	op.ModTime = info.ModTime()
+	op.Size = uint64(size)
+	op.info = info
+	return op
+}
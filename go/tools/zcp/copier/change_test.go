@@ -0,0 +1,246 @@
+package copier
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// changingObserver changes a source file while it is being copied, the first
+// times bytes are reported: by appending to it, or by only touching it so the
+// extra data is not read. With onStart, it changes the file as its copy
+// starts instead, before anything is read. It also tallies the progress it is
+// told of, which must not go past the plan's total when the size stays the
+// same.
+type changingObserver struct {
+	NopObserver
+	t         *testing.T
+	path      string
+	touch     bool
+	onStart   bool
+	changes   int
+	total     uint64
+	completed uint64
+}
+
+func (o *changingObserver) PlanReady(plan Plan) {
+	o.total = plan.TotalBytes
+}
+
+func (o *changingObserver) FileStart(Operation) {
+	if o.onStart {
+		o.change()
+	}
+}
+
+func (o *changingObserver) Bytes(n uint64) {
+	o.completed += n
+	if o.touch && o.completed > o.total {
+		o.t.Errorf("progress went past the total: %d of %d", o.completed, o.total)
+	}
+	if !o.onStart {
+		o.change()
+	}
+}
+
+func (o *changingObserver) FileRetry(_ Operation, n uint64) {
+	o.completed -= n
+}
+
+func (o *changingObserver) change() {
+	if o.changes == 0 {
+		return
+	}
+	o.changes--
+
+	if o.touch {
+		modTime := time.Unix(int64(1_000_000+o.changes), 0)
+		if err := os.Chtimes(o.path, modTime, modTime); err != nil {
+			o.t.Errorf("touch source: %v", err)
+		}
+		return
+	}
+
+	file, err := os.OpenFile(o.path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		o.t.Errorf("open source for append: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.WriteString(" and more"); err != nil {
+		o.t.Errorf("append to source: %v", err)
+	}
+}
+
+func TestSourceChanges(t *testing.T) {
+	t.Parallel()
+
+	copyChanging := func(t *testing.T, policy ChangePolicy, observer *changingObserver) (string, Stats, error) {
+		t.Helper()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "growing.log")
+		if err := os.WriteFile(sourceFile, []byte("log line"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		destinationFile := filepath.Join(tempDir, "copy.log")
+		observer.t = t
+		observer.path = sourceFile
+		result, err := New(Options{OnChange: policy}, observer).Copy(context.Background(), []string{sourceFile}, destinationFile)
+		return destinationFile, result.Stats, err
+	}
+
+	t.Run("warn_lists_changed_files", func(t *testing.T) {
+		t.Parallel()
+
+		_, stats, err := copyChanging(t, ChangeWarn, &changingObserver{changes: 1})
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if len(stats.Changed) != 1 || filepath.Base(stats.Changed[0]) != "growing.log" {
+			t.Fatalf("expected growing.log to be listed as changed, got %v", stats.Changed)
+		}
+	})
+
+	t.Run("unchanged_files_are_not_listed", func(t *testing.T) {
+		t.Parallel()
+
+		_, stats, err := copyChanging(t, ChangeFail, &changingObserver{})
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if len(stats.Changed) != 0 {
+			t.Fatalf("expected no changed files, got %v", stats.Changed)
+		}
+	})
+
+	t.Run("fail_stops_the_copy", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := copyChanging(t, ChangeFail, &changingObserver{changes: 1})
+		if err == nil || !strings.Contains(err.Error(), "changed while it was being copied") {
+			t.Fatalf("expected change error, got %v", err)
+		}
+	})
+
+	t.Run("retry_copies_the_new_contents", func(t *testing.T) {
+		t.Parallel()
+
+		destinationFile, stats, err := copyChanging(t, ChangeRetry, &changingObserver{changes: 1})
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		got, err := os.ReadFile(destinationFile)
+		if err != nil {
+			t.Fatalf("read destination file: %v", err)
+		}
+		if string(got) != "log line and more" {
+			t.Fatalf("expected retried copy to have the new contents, got %q", got)
+		}
+		if len(stats.Changed) != 1 {
+			t.Fatalf("expected the retried file to be listed once, got %v", stats.Changed)
+		}
+	})
+
+	t.Run("retry_does_not_count_progress_twice", func(t *testing.T) {
+		t.Parallel()
+
+		observer := &changingObserver{touch: true, changes: maxChangeRetries}
+		_, stats, err := copyChanging(t, ChangeRetry, observer)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if observer.completed != observer.total {
+			t.Fatalf("expected progress to end at the total, got %d of %d", observer.completed, observer.total)
+		}
+		if stats.BytesRead != observer.total*(maxChangeRetries+1) {
+			t.Fatalf("expected every attempt to be read, got %d bytes", stats.BytesRead)
+		}
+	})
+
+	t.Run("retry_gives_up_on_files_that_keep_changing", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := copyChanging(t, ChangeRetry, &changingObserver{touch: true, changes: maxChangeRetries + 1})
+		if err == nil || !strings.Contains(err.Error(), "kept changing") {
+			t.Fatalf("expected retries to give up, got %v", err)
+		}
+	})
+
+	t.Run("archive_entries_follow_the_policy", func(t *testing.T) {
+		t.Parallel()
+
+		archiveChanging := func(t *testing.T, policy ChangePolicy, observer *changingObserver) (string, Stats, error) {
+			t.Helper()
+
+			tempDir := t.TempDir()
+			sourceFile := filepath.Join(tempDir, "growing.log")
+			if err := os.WriteFile(sourceFile, []byte("log line"), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+			archivePath := filepath.Join(tempDir, "logs.tar")
+			observer.t = t
+			observer.path = sourceFile
+			result, err := New(Options{OnChange: policy, ToArchive: true}, observer).Copy(context.Background(), []string{sourceFile}, archivePath)
+			return archivePath, result.Stats, err
+		}
+		readEntry := func(t *testing.T, archivePath string) string {
+			t.Helper()
+
+			extractDir := filepath.Join(filepath.Dir(archivePath), "out")
+			if _, err := New(Options{FromArchive: true}, nil).Copy(context.Background(), []string{archivePath}, extractDir); err != nil {
+				t.Fatalf("extract archive: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(extractDir, "growing.log"))
+			if err != nil {
+				t.Fatalf("read extracted file: %v", err)
+			}
+			return string(got)
+		}
+
+		// A source growing while it is archived keeps its planned size.
+		archivePath, stats, err := archiveChanging(t, ChangeWarn, &changingObserver{changes: 1})
+		if err != nil || len(stats.Changed) != 1 {
+			t.Fatalf("expected the change to be listed once, got changed=%v err=%v", stats.Changed, err)
+		}
+		if got := readEntry(t, archivePath); got != "log line" {
+			t.Fatalf("expected the entry to keep its planned size, got %q", got)
+		}
+
+		_, _, err = archiveChanging(t, ChangeFail, &changingObserver{changes: 1})
+		if err == nil || !strings.Contains(err.Error(), "changed while it was being copied") {
+			t.Fatalf("expected change error, got %v", err)
+		}
+
+		// Before its header is written, the entry can still take the new
+		// contents.
+		archivePath, stats, err = archiveChanging(t, ChangeRetry, &changingObserver{onStart: true, changes: 1})
+		if err != nil || len(stats.Changed) != 1 {
+			t.Fatalf("expected the retried file to be listed once, got changed=%v err=%v", stats.Changed, err)
+		}
+		if got := readEntry(t, archivePath); got != "log line and more" {
+			t.Fatalf("expected the entry to have the new contents, got %q", got)
+		}
+
+		archivePath, stats, err = archiveChanging(t, ChangeWarn, &changingObserver{onStart: true, changes: 1})
+		if err != nil || len(stats.Changed) != 1 {
+			t.Fatalf("expected the change to be listed once, got changed=%v err=%v", stats.Changed, err)
+		}
+		if got := readEntry(t, archivePath); got != "log line" {
+			t.Fatalf("expected the entry to keep its planned size, got %q", got)
+		}
+	})
+
+	t.Run("rejects_unknown_policy", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := ParseChangePolicy("ignore"); err == nil {
+			t.Fatalf("expected unknown policy to be rejected")
+		}
+	})
+}
@@ -0,0 +1,296 @@
+package copier
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DifferenceKind says how a destination entry differs from its source.
+type DifferenceKind string
+
+const (
+	// DifferenceMissing marks a source entry with nothing at its
+	// destination.
+	DifferenceMissing DifferenceKind = "missing"
+	// DifferenceExtra marks a destination entry with no source.
+	DifferenceExtra DifferenceKind = "extra"
+	// DifferenceType marks a file where a directory is expected, or the
+	// other way round.
+	DifferenceType DifferenceKind = "type"
+	// DifferenceSize, DifferenceModTime and DifferenceContent mark files
+	// whose sizes, modification times (to the second) or contents differ.
+	DifferenceSize    DifferenceKind = "size"
+	DifferenceModTime DifferenceKind = "mtime"
+	DifferenceContent DifferenceKind = "content"
+)
+
+// Difference is one way a destination differs from its sources. Source is
+// empty for extra entries.
+type Difference struct {
+	Kind        DifferenceKind
+	Source      string
+	Destination string
+}
+
+// Comparison is the outcome of Copier.Compare.
+type Comparison struct {
+	// Plan is the copy that would bring the destination up to date.
+	Plan Plan
+	// Differences are sorted by destination path.
+	Differences []Difference
+	// BytesHashed counts source bytes hashed with Options.Checksum.
+	BytesHashed uint64
+}
+
+// Compare checks an existing copy of sources at destination, reporting
+// missing, extra and different entries. A single directory source is compared
+// with destination itself, like diff -r, rather than with a directory of the
+// same name inside it. With Options.Checksum, files of equal size are hashed
+// too, and the observer follows the hashing as if it were a copy of those
+// files.
+func (c *Copier) Compare(ctx context.Context, sources []string, destination string) (Comparison, error) {
+	opts := c.opts
+	opts.Recursive = true
+	if len(sources) == 0 {
+		return Comparison{}, fmt.Errorf("expected at least one source")
+	}
+	if opts.ToArchive || opts.Compress != CompressionNone || opts.Decompress {
+		return Comparison{}, fmt.Errorf("compare cannot be combined with writing archives or compression")
+	}
+	if !isLocalBackend(opts.backend()) || slices.Contains(sources, streamPath) || destination == streamPath {
+		return Comparison{}, fmt.Errorf("compare only works between local files and directories")
+	}
+	if err := opts.Filter.validate(); err != nil {
+		return Comparison{}, err
+	}
+
+	destination = filepath.Clean(destination)
+	dest, err := statDestination(LocalBackend{}, destination)
+	if err != nil {
+		return Comparison{}, err
+	}
+	if len(sources) == 1 && !opts.FromArchive {
+		if info, err := os.Stat(sources[0]); err == nil && info.IsDir() {
+			dest = planDestination{path: destination}
+		}
+	}
+
+	planner := &Copier{opts: opts, observer: c.observer}
+	plan, archives, err := planner.planInto(sources, dest)
+	if err != nil {
+		return Comparison{}, err
+	}
+	defer archives.Close()
+
+	comparison := Comparison{Plan: plan}
+	hashes, err := compareEntries(ctx, plan.Operations, opts.Filter, opts.Checksum, &comparison)
+	if err != nil {
+		return comparison, err
+	}
+
+	c.observer.PlanReady(hashes)
+	for _, op := range hashes.Operations {
+		if err := ctx.Err(); err != nil {
+			return comparison, err
+		}
+
+		c.observer.FileStart(op)
+		same, err := sameContent(op, c.observer)
+		if err != nil {
+			c.observer.Error(op, err)
+			return comparison, err
+		}
+		comparison.BytesHashed += op.Size
+		if !same {
+			comparison.Differences = append(comparison.Differences, Difference{
+				Kind:        DifferenceContent,
+				Source:      op.Source,
+				Destination: op.Destination,
+			})
+		}
+		c.observer.FileDone(op)
+	}
+
+	slices.SortStableFunc(comparison.Differences, func(a, b Difference) int {
+		return cmp.Compare(a.Destination, b.Destination)
+	})
+	return comparison, nil
+}
+
+// compareEntries compares the metadata of every planned entry with what is at
+// its destination, recording differences in comparison, and returns the files
+// whose contents still need hashing when checksum is set. Destination entries
+// filter leaves out are not extra, as their sources were left out too.
+func compareEntries(ctx context.Context, plan []Operation, filter Filter, checksum bool, comparison *Comparison) (Plan, error) {
+	var hashes Plan
+	var skipped []string
+	var directories []string
+	expected := make(map[string]bool, len(plan))
+	// relative maps each planned directory to its path below the source
+	// directory it was planned from, for filtering what is inside it.
+	relative := map[string]string{}
+	report := func(kind DifferenceKind, op Operation) {
+		comparison.Differences = append(comparison.Differences, Difference{
+			Kind:        kind,
+			Source:      op.Source,
+			Destination: op.Destination,
+		})
+	}
+
+	for _, op := range plan {
+		if err := ctx.Err(); err != nil {
+			return Plan{}, err
+		}
+		expected[op.Destination] = true
+		if op.Kind == OperationCreateDirectory {
+			relative[op.Destination] = "."
+			if parent, ok := relative[filepath.Dir(op.Destination)]; ok {
+				relative[op.Destination] = filepath.Join(parent, filepath.Base(op.Destination))
+			}
+		}
+		// Entries below a missing or mismatched directory were reported
+		// with it.
+		if slices.ContainsFunc(skipped, func(directory string) bool { return isBelow(op.Destination, directory) }) {
+			continue
+		}
+
+		info, err := os.Lstat(op.Destination)
+		if errors.Is(err, fs.ErrNotExist) {
+			report(DifferenceMissing, op)
+			if op.Kind == OperationCreateDirectory {
+				skipped = append(skipped, op.Destination)
+			}
+			continue
+		}
+		if err != nil {
+			return Plan{}, fmt.Errorf("stat destination %q: %w", op.Destination, err)
+		}
+
+		isDirectory := op.Kind == OperationCreateDirectory
+		if info.IsDir() != isDirectory || !info.IsDir() && !info.Mode().IsRegular() {
+			report(DifferenceType, op)
+			if isDirectory {
+				skipped = append(skipped, op.Destination)
+			}
+			continue
+		}
+		if isDirectory {
+			directories = append(directories, op.Destination)
+			continue
+		}
+
+		if uint64(info.Size()) != op.Size {
+			report(DifferenceSize, op)
+			continue
+		}
+		if info.ModTime().Unix() != op.ModTime.Unix() {
+			report(DifferenceModTime, op)
+		}
+		if checksum {
+			hashes.Operations = append(hashes.Operations, op)
+			hashes.TotalBytes += op.Size
+		}
+	}
+
+	// Anything else inside a compared directory has no source.
+	for _, directory := range directories {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			return Plan{}, fmt.Errorf("read destination directory %q: %w", directory, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(directory, entry.Name())
+			if expected[path] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return Plan{}, fmt.Errorf("stat destination %q: %w", path, err)
+			}
+			relativePath := filepath.Join(relative[directory], entry.Name())
+			if filter.excludes(relativePath, string(filepath.Separator), info, &FilterCounts{}) != "" {
+				continue
+			}
+			comparison.Differences = append(comparison.Differences, Difference{Kind: DifferenceExtra, Destination: path})
+		}
+	}
+	return hashes, nil
+}
+
+func isBelow(path string, directory string) bool {
+	return strings.HasPrefix(path, directory+string(filepath.Separator))
+}
+
+// sameContent hashes op's source and destination side by side, reporting the
+// source bytes read to observer.
+func sameContent(op Operation, observer Observer) (bool, error) {
+	type result struct {
+		sum []byte
+		err error
+	}
+	destination := make(chan result, 1)
+	go func() {
+		sum, err := hashFile(op.Destination, NopObserver{})
+		destination <- result{sum: sum, err: err}
+	}()
+
+	file, err := openSource(op)
+	if err != nil {
+		<-destination
+		return false, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, observedReader{reader: file, observer: observer}); err != nil {
+		<-destination
+		return false, fmt.Errorf("read source file %q: %w", op.Source, err)
+	}
+
+	destinationResult := <-destination
+	if destinationResult.err != nil {
+		return false, destinationResult.err
+	}
+	return bytes.Equal(hash.Sum(nil), destinationResult.sum), nil
+}
+
+// hashFile returns the SHA-256 digest of a local file, reporting the bytes
+// read to observer.
+func hashFile(path string, observer Observer) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("hash file %q: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, observedReader{reader: file, observer: observer}); err != nil {
+		return nil, fmt.Errorf("hash file %q: %w", path, err)
+	}
+	return hash.Sum(nil), nil
+}
+
+// observedReader reports every read to an Observer as it happens, from the
+// reading goroutine.
+type observedReader struct {
+	reader   io.Reader
+	observer Observer
+}
+
+func (r observedReader) Read(buffer []byte) (int, error) {
+	n, err := r.reader.Read(buffer)
+	if n > 0 {
+		r.observer.Bytes(uint64(n))
+	}
+	return n, err
+}
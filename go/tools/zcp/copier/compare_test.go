@@ -0,0 +1,167 @@
+package copier
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	writeTree := func(t *testing.T, root string, files map[string]string) {
+		t.Helper()
+
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		for path, content := range files {
+			path = filepath.Join(root, filepath.FromSlash(path))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("set modtime: %v", err)
+			}
+		}
+	}
+
+	sourceFiles := map[string]string{
+		"same.txt":         "same",
+		"content.txt":      "abcd",
+		"size.txt":         "short",
+		"missing.txt":      "gone",
+		"gone/inside.txt":  "gone too",
+		"nested/kept.txt":  "kept",
+		"nested/dir/x.txt": "x",
+	}
+
+	t.Run("reports_every_kind_of_difference", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		writeTree(t, sourceRoot, sourceFiles)
+		writeTree(t, destinationRoot, map[string]string{
+			"same.txt":        "same",
+			"content.txt":     "abce",
+			"size.txt":        "longer",
+			"extra.txt":       "extra",
+			"nested/kept.txt": "kept",
+			"nested/dir":      "a file where a directory belongs",
+		})
+		if err := os.Chtimes(filepath.Join(destinationRoot, "same.txt"), time.Now(), time.Now()); err != nil {
+			t.Fatalf("set modtime: %v", err)
+		}
+
+		observer := &recordingObserver{}
+		comparison, err := New(Options{Checksum: true}, observer).Compare(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("compare: %v", err)
+		}
+
+		var got []string
+		for _, difference := range comparison.Differences {
+			relative, err := filepath.Rel(destinationRoot, difference.Destination)
+			if err != nil {
+				t.Fatalf("relative destination: %v", err)
+			}
+			got = append(got, string(difference.Kind)+" "+filepath.ToSlash(relative))
+		}
+		want := []string{
+			"content content.txt",
+			"extra extra.txt",
+			"missing gone",
+			"missing missing.txt",
+			"type nested/dir",
+			"mtime same.txt",
+			"size size.txt",
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected differences %v, got %v", want, got)
+		}
+
+		// Only the three files of matching size are hashed.
+		if comparison.BytesHashed != 12 || observer.bytes != 12 {
+			t.Fatalf("expected 12 bytes hashed and observed, got %d and %d", comparison.BytesHashed, observer.bytes)
+		}
+	})
+
+	t.Run("matches_a_fresh_copy", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		writeTree(t, sourceRoot, sourceFiles)
+
+		opts := Options{Recursive: true, Preserve: true}
+		if _, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot); err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		// The copy now exists, yet the trees themselves are compared.
+		opts.Checksum = true
+		comparison, err := New(opts, nil).Compare(context.Background(), []string{sourceRoot}, destinationRoot+string(filepath.Separator))
+		if err != nil {
+			t.Fatalf("compare: %v", err)
+		}
+		if len(comparison.Differences) != 0 || comparison.Plan.Files() != len(sourceFiles) {
+			t.Fatalf("expected no differences over %d files, got %+v", len(sourceFiles), comparison)
+		}
+	})
+
+	t.Run("filters_both_sides", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		files := map[string]string{
+			"small.txt":         "small",
+			"large.txt":         strings.Repeat("large", 10),
+			"nested/kept.txt":   "kept",
+			"nested/deep/x.txt": "x",
+		}
+		writeTree(t, sourceRoot, files)
+		files["extra.txt"] = "extra"
+		writeTree(t, destinationRoot, files)
+
+		// Entries the filter leaves out of the source are not extra in the
+		// destination, while a file with no source still is.
+		opts := Options{Filter: Filter{MaxSize: 10, MaxDepth: 2}}
+		comparison, err := New(opts, nil).Compare(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("compare: %v", err)
+		}
+		if len(comparison.Differences) != 1 || comparison.Differences[0].Kind != DifferenceExtra ||
+			filepath.Base(comparison.Differences[0].Destination) != "extra.txt" {
+			t.Fatalf("expected only extra.txt to differ, got %+v", comparison.Differences)
+		}
+	})
+
+	t.Run("rejects_streams_and_compression", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		for _, testCase := range []struct {
+			opts    Options
+			sources []string
+			want    string
+		}{
+			{sources: []string{"-"}, want: "local files"},
+			{opts: Options{Compress: CompressionGzip}, sources: []string{tempDir}, want: "compression"},
+		} {
+			_, err := New(testCase.opts, nil).Compare(context.Background(), testCase.sources, tempDir)
+			if err == nil || !strings.Contains(err.Error(), testCase.want) {
+				t.Fatalf("expected error containing %q, got %v", testCase.want, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package copier
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names a compression format for file contents.
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression parses a --compress value.
+func ParseCompression(value string) (Compression, error) {
+	switch compression := Compression(value); compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return compression, nil
+	default:
+		return CompressionNone, fmt.Errorf("unsupported compression %q (expected gzip or zstd)", value)
+	}
+}
+
+// Suffix returns the file name suffix files compressed with c get.
+func (c Compression) Suffix() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// compressionForName returns the compression a file name's suffix indicates.
+func compressionForName(name string) Compression {
+	lower := strings.ToLower(name)
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		if strings.HasSuffix(lower, compression.Suffix()) {
+			return compression
+		}
+	}
+	return CompressionNone
+}
+
+func newCompressor(compression Compression, destination io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(destination), nil
+	case CompressionZstd:
+		return zstd.NewWriter(destination)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+func newDecompressor(compression Compression, source io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(source)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(source)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// applyCompression marks the file operations in plan to be compressed, adding
+// the format's suffix to their destination, or decompressed, stripping the
+// suffix from files that have one.
+func applyCompression(plan []Operation, compress Compression, decompress bool) {
+	for i := range plan {
+		op := &plan[i]
+		if op.Kind != OperationCopyFile {
+			continue
+		}
+
+		switch {
+		case compress != CompressionNone:
+			op.compress = compress
+			if op.Destination != streamPath {
+				op.Destination += compress.Suffix()
+			}
+		case decompress:
+			// Standard output has no name to take the format from.
+			name := op.Destination
+			if name == streamPath {
+				name = op.Source
+			}
+			compression := compressionForName(name)
+			if compression == CompressionNone {
+				continue
+			}
+			op.decompress = compression
+			if op.Destination != streamPath {
+				op.Destination = op.Destination[:len(op.Destination)-len(compression.Suffix())]
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run("round_trip_"+string(compression), func(t *testing.T) {
+			t.Parallel()
+
+			tempDir := t.TempDir()
+			sourceDir := filepath.Join(tempDir, "logs")
+			if err := os.MkdirAll(sourceDir, 0o755); err != nil {
+				t.Fatalf("mkdir source: %v", err)
+			}
+			expected := bytes.Repeat([]byte("2026-10-19 INFO request served\n"), 4096)
+			if err := os.WriteFile(filepath.Join(sourceDir, "app.log"), expected, 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+
+			compressedDir := filepath.Join(tempDir, "cold")
+			observer := &recordingObserver{}
+			compressed, err := New(Options{Recursive: true, Compress: compression}, observer).Copy(
+				context.Background(),
+				[]string{sourceDir},
+				compressedDir,
+			)
+			if err != nil {
+				t.Fatalf("compress copy: %v", err)
+			}
+
+			if compressed.Stats.BytesRead != uint64(len(expected)) || observer.bytes != uint64(len(expected)) {
+				t.Fatalf(
+					"expected %d source bytes read and observed, got %d and %d",
+					len(expected),
+					compressed.Stats.BytesRead,
+					observer.bytes,
+				)
+			}
+			if compressed.Stats.BytesWritten >= compressed.Stats.BytesRead {
+				t.Fatalf("expected output smaller than input, wrote %d bytes", compressed.Stats.BytesWritten)
+			}
+			if compressed.Stats.CompressionRatio() <= 1 {
+				t.Fatalf("expected ratio above 1, got %f", compressed.Stats.CompressionRatio())
+			}
+
+			compressedFile := filepath.Join(compressedDir, "app.log"+compression.Suffix())
+			info, err := os.Stat(compressedFile)
+			if err != nil {
+				t.Fatalf("stat compressed file: %v", err)
+			}
+			if uint64(info.Size()) != compressed.Stats.BytesWritten {
+				t.Fatalf("expected %d bytes on disk, got %d", compressed.Stats.BytesWritten, info.Size())
+			}
+
+			restoredDir := filepath.Join(tempDir, "restored")
+			restored, err := New(Options{Recursive: true, Decompress: true}, nil).Copy(
+				context.Background(),
+				[]string{compressedDir},
+				restoredDir,
+			)
+			if err != nil {
+				t.Fatalf("decompress copy: %v", err)
+			}
+			if restored.Stats.BytesRead != compressed.Stats.BytesWritten {
+				t.Fatalf("expected to read %d compressed bytes, got %d", compressed.Stats.BytesWritten, restored.Stats.BytesRead)
+			}
+
+			actual, err := os.ReadFile(filepath.Join(restoredDir, "app.log"))
+			if err != nil {
+				t.Fatalf("read restored file: %v", err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Fatalf("restored contents differ from the original")
+			}
+		})
+	}
+
+	t.Run("decompress_copies_other_files_unchanged", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "notes.txt")
+		if err := os.WriteFile(sourceFile, []byte("plain"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		destinationFile := filepath.Join(tempDir, "copy.txt")
+		if _, err := New(Options{Decompress: true}, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		actual, err := os.ReadFile(destinationFile)
+		if err != nil {
+			t.Fatalf("read destination file: %v", err)
+		}
+		if string(actual) != "plain" {
+			t.Fatalf("unexpected destination contents: %q", actual)
+		}
+	})
+
+	t.Run("rejects_unknown_compression", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := ParseCompression("brotli"); err == nil {
+			t.Fatalf("expected error for unsupported compression")
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package copier
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+)
+
+// ConflictKind says how sources clash at a destination path.
+type ConflictKind string
+
+const (
+	// ConflictDuplicate marks a path several source files are copied to,
+	// such as a/config and b/config copied into the same directory.
+	ConflictDuplicate ConflictKind = "duplicate"
+	// ConflictType marks a path that is a file for one source and a
+	// directory, or the parent of other entries, for another.
+	ConflictType ConflictKind = "type"
+)
+
+// Conflict is a destination path more than one source is planned for.
+type Conflict struct {
+	Kind        ConflictKind
+	Destination string
+	// Sources lists the clashing sources in plan order.
+	Sources []string
+}
+
+// ConflictError reports every conflicting destination path in a plan. It is
+// returned before anything is written.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d destination path(s) are planned for more than one source", len(e.Conflicts))
+}
+
+// findConflicts checks that every destination path in plan is planned once,
+// except for directories, which merge. The same file planned twice, as when
+// a file list names both a directory and a file inside it, is only copied
+// once.
+func findConflicts(plan *Plan) error {
+	type planned struct {
+		isDir bool
+		// local marks a local source file, rather than an archive entry.
+		local   bool
+		sources []string
+		kind    ConflictKind
+	}
+	entries := make(map[string]*planned, len(plan.Operations))
+	var conflicting []string
+	conflict := func(destination string, entry *planned, kind ConflictKind, source string) {
+		if entry.kind == "" {
+			conflicting = append(conflicting, destination)
+		}
+		if entry.kind != ConflictType {
+			entry.kind = kind
+		}
+		entry.sources = append(entry.sources, source)
+	}
+
+	operations := plan.Operations[:0]
+	for _, op := range plan.Operations {
+		isDir := op.Kind == OperationCreateDirectory
+		entry, ok := entries[op.Destination]
+		switch {
+		case !ok:
+			entries[op.Destination] = &planned{isDir: isDir, local: op.fsys == nil, sources: []string{op.Source}}
+		case isDir && entry.isDir:
+		case !isDir && !entry.isDir && entry.local && op.fsys == nil && entry.sources[0] == op.Source:
+			plan.TotalBytes -= op.Size
+			continue
+		case isDir != entry.isDir:
+			conflict(op.Destination, entry, ConflictType, op.Source)
+		default:
+			conflict(op.Destination, entry, ConflictDuplicate, op.Source)
+		}
+		operations = append(operations, op)
+	}
+	plan.Operations = operations
+
+	// A file also clashes with anything planned below it.
+	for _, op := range plan.Operations {
+		for parent := filepath.Dir(op.Destination); parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+			if entry, ok := entries[parent]; ok && !entry.isDir {
+				if entry.kind != ConflictType {
+					conflict(parent, entry, ConflictType, op.Source)
+				}
+				break
+			}
+		}
+	}
+
+	if len(conflicting) == 0 {
+		return nil
+	}
+	conflicts := make([]Conflict, 0, len(conflicting))
+	for _, destination := range conflicting {
+		entry := entries[destination]
+		conflicts = append(conflicts, Conflict{Kind: entry.kind, Destination: destination, Sources: entry.sources})
+	}
+	slices.SortFunc(conflicts, func(a, b Conflict) int { return cmp.Compare(a.Destination, b.Destination) })
+	return &ConflictError{Conflicts: conflicts}
+}
@@ -0,0 +1,105 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConflicts(t *testing.T) {
+	t.Parallel()
+
+	writeFiles := func(t *testing.T, root string, files ...string) {
+		t.Helper()
+
+		for _, name := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+		}
+	}
+
+	t.Run("reports_every_conflict_before_copying", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		writeFiles(t, tempDir,
+			"a/config", "b/config",
+			"x/tree/same/one", "x/tree/f", "x/tree/sub/inside", "x/tree/parent",
+			"y/tree/same/two", "y/tree/f/g", "y/tree/sub", "y/tree/parent/below/deep",
+		)
+		destinationRoot := filepath.Join(tempDir, "dest")
+		if err := os.Mkdir(destinationRoot, 0o755); err != nil {
+			t.Fatalf("create destination: %v", err)
+		}
+
+		sources := []string{"a/config", "b/config", "x/tree", "y/tree"}
+		for i, source := range sources {
+			sources[i] = filepath.Join(tempDir, filepath.FromSlash(source))
+		}
+		_, err := New(Options{Recursive: true, Force: true}, nil).Copy(context.Background(), sources, destinationRoot)
+
+		var conflictErr *ConflictError
+		if !errors.As(err, &conflictErr) {
+			t.Fatalf("expected a ConflictError, got %v", err)
+		}
+		var got []string
+		for _, conflict := range conflictErr.Conflicts {
+			relative, err := filepath.Rel(destinationRoot, conflict.Destination)
+			if err != nil {
+				t.Fatalf("relative destination: %v", err)
+			}
+			var names []string
+			for _, source := range conflict.Sources {
+				name, err := filepath.Rel(tempDir, source)
+				if err != nil {
+					t.Fatalf("relative source: %v", err)
+				}
+				names = append(names, filepath.ToSlash(name))
+			}
+			got = append(got, string(conflict.Kind)+" "+filepath.ToSlash(relative)+" "+strings.Join(names, ","))
+		}
+		want := []string{
+			"duplicate config a/config,b/config",
+			"type tree/f x/tree/f,y/tree/f",
+			"type tree/parent x/tree/parent,y/tree/parent",
+			"type tree/sub x/tree/sub,y/tree/sub",
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected conflicts %v, got %v", want, got)
+		}
+
+		entries, err := os.ReadDir(destinationRoot)
+		if err != nil || len(entries) != 0 {
+			t.Fatalf("expected nothing to be written, got %d entries, %v", len(entries), err)
+		}
+	})
+
+	t.Run("merges_directories", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		writeFiles(t, tempDir, "x/tree/one", "x/tree/same/a", "y/tree/two", "y/tree/same/b")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		if err := os.Mkdir(destinationRoot, 0o755); err != nil {
+			t.Fatalf("create destination: %v", err)
+		}
+
+		sources := []string{filepath.Join(tempDir, "x", "tree"), filepath.Join(tempDir, "y", "tree")}
+		result, err := New(Options{Recursive: true}, nil).Copy(context.Background(), sources, destinationRoot)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if result.Plan.Files() != 4 {
+			t.Fatalf("expected four files merged into one tree, got %d", result.Plan.Files())
+		}
+	})
+}
@@ -0,0 +1,450 @@
+// Package copier is the copy engine behind the zcp command. It plans a copy of
+// one or more sources into a destination and executes that plan, reporting
+// progress to an Observer.
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultBufferSize is the copy buffer size used when Options.BufferSize is 0.
+const DefaultBufferSize = 1024 * 1024
+
+// Options mirrors the zcp command-line flags that affect how a copy is done.
+type Options struct {
+	// Recursive allows directories to be copied (-r).
+	Recursive bool
+	// Force overwrites existing destination files (-f).
+	Force bool
+	// Preserve copies mode and modification time (-p).
+	Preserve bool
+	// BufferSize is the copy buffer size in bytes, or BufferSizeAuto to size
+	// it per file (--buffer-size).
+	BufferSize int
+	// Relative keeps each source's path, relative to the current directory,
+	// under the destination directory, as for --files-from. Directories are
+	// only created, not copied, unless Recursive is set.
+	Relative bool
+	// FromArchive treats every source as a .tar, .tar.gz, .tar.zst or .zip
+	// archive whose contents are extracted into the destination
+	// (--from-archive).
+	FromArchive bool
+	// ToArchive writes the copy into a new archive at the destination, whose
+	// format is chosen by its extension (--to-archive).
+	ToArchive bool
+	// Compress compresses each destination file, adding the format's suffix
+	// to its name (--compress).
+	Compress Compression
+	// Decompress decompresses source files ending in .gz or .zst, removing
+	// the suffix; other files are copied unchanged (--decompress).
+	Decompress bool
+	// SkipSpaceCheck skips checking that the destination has room for the
+	// copy before starting (--no-space-check).
+	SkipSpaceCheck bool
+	// NoPreallocate skips reserving each destination file's space before
+	// writing it (--no-preallocate).
+	NoPreallocate bool
+	// Direct bypasses the page cache for local files, with direct I/O where
+	// the filesystem supports it and by dropping copied data from the cache
+	// otherwise (--direct).
+	Direct bool
+	// OnChange says what to do when a source file changes while it is being
+	// copied (--on-change).
+	OnChange ChangePolicy
+	// Retries is how many times reading, and writing, each file is retried
+	// after failing with EIO, EAGAIN or ETIMEDOUT before the copy fails
+	// (--retries). Writes are retried on a newly opened destination.
+	Retries int
+	// RetryDelay is the wait before the first retry, doubling for each
+	// further one (--retry-delay).
+	RetryDelay time.Duration
+	// Order selects the order files from local sources are copied in
+	// (--order). Tar entries are extracted in the order they are stored in,
+	// which is the only order a stream can be read in cheaply; zip entries
+	// in walk order.
+	Order Order
+	// Hash records the SHA-256 digest of every file written in Stats.Hashes,
+	// computed as it is written (--manifest).
+	Hash bool
+	// Checksum makes Compare hash the contents of files whose size matches
+	// (--checksum).
+	Checksum bool
+	// Dedupe stores files identical to one already written during the copy,
+	// or to one below LinkDest, as links to it instead of copying them
+	// again. Only the local filesystem supports it (--dedupe).
+	Dedupe DedupeMode
+	// LinkDest is an earlier snapshot of the destination. Files unchanged
+	// since, with the same size, modification time and mode at the same
+	// path, are hard-linked to it instead of copied, and with Dedupe any of
+	// its files may be linked to (--link-dest).
+	LinkDest string
+	// TargetFS is the filesystem whose name rules destination names are
+	// checked against before copying; TargetAuto detects it for local
+	// destinations (--target-fs).
+	TargetFS TargetFS
+	// Sanitize renames destination names the target cannot hold, listing
+	// the renames in Plan.Renamed, instead of failing with a NameError
+	// (--sanitize).
+	Sanitize bool
+	// Filter selects which entries of source directories are copied.
+	Filter Filter
+	// Fsync controls flushing written data to stable storage (--fsync).
+	Fsync FsyncMode
+	// Stdin is read for a "-" source and Stdout written for a "-"
+	// destination. Nil means os.Stdin and os.Stdout.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// StreamSize is the expected size of a "-" source, or 0 if unknown
+	// (--size).
+	StreamSize uint64
+	// Backend is the filesystem the destination lives on. Nil means the local
+	// filesystem.
+	Backend Backend
+}
+
+func (o Options) backend() Backend {
+	if o.Backend == nil {
+		return LocalBackend{}
+	}
+	return o.Backend
+}
+
+// Plan is the ordered list of operations a copy will perform.
+type Plan struct {
+	Operations []Operation
+	TotalBytes uint64
+	// SizeUnknown is set when a source is a stream of unknown length, so
+	// TotalBytes only counts the other sources.
+	SizeUnknown bool
+	// Filtered counts the entries Options.Filter left out.
+	Filtered FilterCounts
+	// Linked counts the files hard-linked from Options.LinkDest, whose
+	// LinkedBytes are not part of TotalBytes.
+	Linked      int
+	LinkedBytes uint64
+	// Renamed lists the destination paths renamed by Options.Sanitize, in
+	// plan order.
+	Renamed []RenamedPath
+}
+
+// Files returns the number of files the plan copies.
+func (p Plan) Files() int {
+	count := 0
+	for _, op := range p.Operations {
+		if op.Kind == OperationCopyFile {
+			count++
+		}
+	}
+	return count
+}
+
+// Stats counts the bytes a copy moved.
+type Stats struct {
+	// BytesRead is the number of bytes read from source files.
+	BytesRead uint64
+	// BytesWritten is the number of bytes written to destination files,
+	// after any compression or decompression.
+	BytesWritten uint64
+	// Changed lists source files that changed while they were being copied.
+	Changed []string
+	// Retries counts reads and writes retried after transient I/O errors.
+	Retries uint64
+	// Hashes lists the digest of every file written, in copy order, with
+	// Options.Hash.
+	Hashes []FileHash
+	// Linked counts files hard-linked from Options.LinkDest.
+	Linked int
+	// Deduplicated counts files linked to an identical file with
+	// Options.Dedupe, which saved BytesSaved bytes of storage.
+	Deduplicated int
+	BytesSaved   uint64
+}
+
+// CompressionRatio returns uncompressed bytes per compressed byte, whichever
+// direction the copy went, or 0 if nothing was copied.
+func (s Stats) CompressionRatio() float64 {
+	if s.BytesRead == 0 || s.BytesWritten == 0 {
+		return 0
+	}
+	if s.BytesRead > s.BytesWritten {
+		return float64(s.BytesRead) / float64(s.BytesWritten)
+	}
+	return float64(s.BytesWritten) / float64(s.BytesRead)
+}
+
+// Result describes a finished copy.
+type Result struct {
+	Plan  Plan
+	Stats Stats
+}
+
+// Observer receives progress notifications while a Copier runs. Callbacks are
+// made from the goroutine calling Copy.
+type Observer interface {
+	// PlanReady is called once the plan is built, before anything is written.
+	PlanReady(plan Plan)
+	// FileStart is called before a file starts copying.
+	FileStart(op Operation)
+	// Bytes is called each time n more bytes have been read from a source
+	// file.
+	Bytes(n uint64)
+	// FileDone is called after a file has been copied.
+	FileDone(op Operation)
+	// Error is called with the operation that failed and its error, right
+	// before Copy returns that error.
+	Error(op Operation, err error)
+	// FlushStart and FlushDone bracket waiting for written data to reach
+	// stable storage, which can take a long time on slow media.
+	FlushStart()
+	FlushDone()
+}
+
+// NopObserver ignores every notification. Embed it to implement only the
+// Observer methods you need.
+type NopObserver struct{}
+
+func (NopObserver) PlanReady(Plan)         {}
+func (NopObserver) FileStart(Operation)    {}
+func (NopObserver) Bytes(uint64)           {}
+func (NopObserver) FileDone(Operation)     {}
+func (NopObserver) Error(Operation, error) {}
+func (NopObserver) FlushStart()            {}
+func (NopObserver) FlushDone()             {}
+
+// Copier copies files and directories according to its Options.
+type Copier struct {
+	opts     Options
+	observer Observer
+}
+
+// New returns a Copier. A nil observer is replaced with NopObserver.
+func New(opts Options, observer Observer) *Copier {
+	if observer == nil {
+		observer = NopObserver{}
+	}
+	if events, ok := observer.(EventObserver); ok {
+		opts.Filter.skipped = skipReporter(events)
+	}
+	return &Copier{opts: opts, observer: observer}
+}
+
+// Plan builds the copy plan for sources and destination without writing
+// anything.
+func (c *Copier) Plan(ctx context.Context, sources []string, destination string) (Plan, error) {
+	plan, archives, err := c.buildPlan(ctx, sources, destination)
+	if err != nil {
+		return Plan{}, err
+	}
+	archives.Close()
+	return plan, nil
+}
+
+// Copy plans and executes a copy of sources into destination. With several
+// sources, destination must be an existing directory.
+func (c *Copier) Copy(ctx context.Context, sources []string, destination string) (Result, error) {
+	opts := c.opts
+	if opts.BufferSize == 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.BufferSize < 0 && opts.BufferSize != BufferSizeAuto {
+		return Result{}, fmt.Errorf("buffer size must be greater than 0")
+	}
+	if opts.Retries < 0 || opts.RetryDelay < 0 {
+		return Result{}, fmt.Errorf("retries and retry delay must not be negative")
+	}
+	if len(sources) == 0 {
+		return Result{}, fmt.Errorf("expected at least one source")
+	}
+	if _, err := ParseCompression(string(opts.Compress)); err != nil {
+		return Result{}, err
+	}
+	if _, err := ParseFsyncMode(string(opts.Fsync)); err != nil {
+		return Result{}, err
+	}
+	if _, err := ParseChangePolicy(string(opts.OnChange)); err != nil {
+		return Result{}, err
+	}
+	if _, err := ParseOrder(string(opts.Order)); err != nil {
+		return Result{}, err
+	}
+	if opts.Compress != CompressionNone && opts.Decompress {
+		return Result{}, fmt.Errorf("compress and decompress cannot be used together")
+	}
+	// Archive entries are sized from the source files; compress the archive
+	// itself by naming it .tar.gz or .tar.zst instead.
+	if opts.ToArchive && (opts.Compress != CompressionNone || opts.Decompress) {
+		return Result{}, fmt.Errorf("compress and decompress cannot be used when writing an archive")
+	}
+	if _, err := ParseTargetFS(string(opts.TargetFS)); err != nil {
+		return Result{}, err
+	}
+	if _, err := ParseDedupeMode(string(opts.Dedupe)); err != nil {
+		return Result{}, err
+	}
+	if (opts.Dedupe != DedupeNone || opts.LinkDest != "") && (opts.ToArchive || destination == streamPath || !isLocalBackend(opts.backend())) {
+		return Result{}, fmt.Errorf("dedupe and link-dest only work when copying to local files")
+	}
+
+	if destination == streamPath {
+		opts.Backend = streamBackend{writer: opts.stdout()}
+	}
+	if opts.Fsync != FsyncNone && !canSync(opts.backend()) {
+		return Result{}, fmt.Errorf("fsync mode %q is not supported by this destination", opts.Fsync)
+	}
+
+	plan, archives, err := c.buildPlan(ctx, sources, destination)
+	if err != nil {
+		return Result{}, err
+	}
+	defer archives.Close()
+
+	if !opts.SkipSpaceCheck {
+		if err := checkFreeSpace(opts.backend(), plan, destination, opts); err != nil {
+			return Result{Plan: plan}, err
+		}
+	}
+	c.observer.PlanReady(plan)
+
+	var stats Stats
+	if opts.ToArchive {
+		stats, err = executeArchivePlan(ctx, plan.Operations, destination, opts, c.observer)
+	} else {
+		stats, err = executePlan(ctx, plan.Operations, opts, c.observer)
+	}
+	return Result{Plan: plan, Stats: stats}, err
+}
+
+// buildPlan plans the copy. Any source archives it opens stay open, since the
+// plan reads from them, and must be closed by the caller.
+func (c *Copier) buildPlan(
+	ctx context.Context,
+	sources []string,
+	destination string,
+) (Plan, sourceArchives, error) {
+	if err := ctx.Err(); err != nil {
+		return Plan{}, nil, err
+	}
+	if err := c.opts.Filter.validate(); err != nil {
+		return Plan{}, nil, err
+	}
+
+	var dest planDestination
+	if c.opts.ToArchive {
+		if _, err := detectArchiveFormat(destination); err != nil {
+			return Plan{}, nil, err
+		}
+		// Entries are named relative to the archive root, which acts as an
+		// existing directory.
+		dest = planDestination{exists: true, isDir: true, virtual: true}
+	} else {
+		var err error
+		dest, err = statDestination(c.opts.backend(), destination)
+		if err != nil {
+			return Plan{}, nil, err
+		}
+	}
+
+	if c.opts.ToArchive && isLocalBackend(c.opts.backend()) {
+		for _, source := range sources {
+			if info, err := os.Stat(source); err == nil && info.IsDir() {
+				if err := ensureDestinationOutsideSource(source, destination); err != nil {
+					return Plan{}, nil, err
+				}
+			}
+		}
+	}
+
+	plan, archives, err := c.planInto(sources, dest)
+	if err != nil {
+		return Plan{}, nil, err
+	}
+	if !dest.stream {
+		if err := findConflicts(&plan); err != nil {
+			archives.Close()
+			return Plan{}, nil, err
+		}
+	}
+	if err := c.checkNames(&plan, dest); err != nil {
+		archives.Close()
+		return Plan{}, nil, err
+	}
+	if c.opts.LinkDest != "" && !dest.virtual {
+		if err := linkUnchanged(&plan, c.opts.LinkDest, dest.path); err != nil {
+			archives.Close()
+			return Plan{}, nil, err
+		}
+	}
+	return plan, archives, nil
+}
+
+// checkNames checks the names plan creates against the target filesystem,
+// or renames them with Sanitize. Archives and streams are not checked, and
+// remote destinations only against an explicit target.
+func (c *Copier) checkNames(plan *Plan, dest planDestination) error {
+	if c.opts.ToArchive || dest.stream {
+		return nil
+	}
+
+	target := c.opts.TargetFS
+	if target == TargetAuto {
+		if !isLocalBackend(c.opts.backend()) {
+			return nil
+		}
+		existing, err := nearestExistingPath(c.opts.backend(), dest.path)
+		if err != nil {
+			return err
+		}
+		target, err = detectTargetFS(existing)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("detect filesystem of %q: %w", existing, err)
+		}
+	}
+	if target == TargetPOSIX {
+		return nil
+	}
+
+	// Names are checked below the destination directory, or from the
+	// destination itself when it is the file being copied.
+	root := dest.path
+	for _, op := range plan.Operations {
+		if op.Kind == OperationCopyFile && op.Destination == dest.path {
+			root = filepath.Dir(dest.path)
+		}
+	}
+	return checkTargetNames(plan, root, target, c.opts.Sanitize)
+}
+
+// planInto plans copying sources into an already resolved destination.
+func (c *Copier) planInto(sources []string, dest planDestination) (Plan, sourceArchives, error) {
+	if c.opts.FromArchive {
+		archives, err := openSourceArchives(sources)
+		if err != nil {
+			return Plan{}, nil, err
+		}
+		plan, err := planArchiveSources(archives, dest, c.opts.Filter)
+		if err != nil {
+			archives.Close()
+			return Plan{}, nil, err
+		}
+		applyCompression(plan.Operations, c.opts.Compress, c.opts.Decompress)
+		return plan, archives, nil
+	}
+
+	plan, err := planSources(sources, dest, c.opts)
+	if err != nil {
+		return Plan{}, nil, err
+	}
+	orderPlan(plan.Operations, c.opts.Order)
+	applyCompression(plan.Operations, c.opts.Compress, c.opts.Decompress)
+	plan.SizeUnknown = sizeUnknown(plan.Operations)
+	return plan, nil, nil
+}
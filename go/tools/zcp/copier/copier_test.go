@@ -0,0 +1,218 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type recordingObserver struct {
+	NopObserver
+	events []string
+	bytes  uint64
+	plan   Plan
+}
+
+func (o *recordingObserver) PlanReady(plan Plan) {
+	o.plan = plan
+	o.events = append(o.events, "plan")
+}
+
+func (o *recordingObserver) FileStart(op Operation) {
+	o.events = append(o.events, "start:"+filepath.Base(op.Source))
+}
+
+func (o *recordingObserver) Bytes(n uint64) {
+	o.bytes += n
+}
+
+func (o *recordingObserver) FileDone(op Operation) {
+	o.events = append(o.events, "done:"+filepath.Base(op.Source))
+}
+
+func (o *recordingObserver) Error(op Operation, err error) {
+	o.events = append(o.events, "error:"+filepath.Base(op.Source))
+}
+
+type flushCountingObserver struct {
+	NopObserver
+	flushes int
+}
+
+func (o *flushCountingObserver) FlushStart() {
+	o.flushes++
+}
+
+// syncRecordingBackend is the local filesystem, recording the directories
+// it flushes and, when noSyncFS is set, unable to flush a whole filesystem.
+type syncRecordingBackend struct {
+	LocalBackend
+	noSyncFS    bool
+	directories *[]string
+}
+
+func (b syncRecordingBackend) SyncDir(name string) error {
+	*b.directories = append(*b.directories, name)
+	return b.LocalBackend.SyncDir(name)
+}
+
+func (b syncRecordingBackend) SyncFS(name string) error {
+	if b.noSyncFS {
+		return errors.ErrUnsupported
+	}
+	return b.LocalBackend.SyncFS(name)
+}
+
+func TestCopier(t *testing.T) {
+	t.Parallel()
+
+	t.Run("notifies_observer", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("observed"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		observer := &recordingObserver{}
+		copier := New(Options{BufferSize: 3}, observer)
+		result, err := copier.Copy(context.Background(), []string{sourceFile}, filepath.Join(tempDir, "dest.txt"))
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		want := "plan,start:source.txt,done:source.txt"
+		if got := strings.Join(observer.events, ","); got != want {
+			t.Fatalf("unexpected events: got %q, want %q", got, want)
+		}
+		if observer.bytes != 8 || observer.plan.TotalBytes != 8 {
+			t.Fatalf("expected 8 bytes observed and planned, got %d and %d", observer.bytes, observer.plan.TotalBytes)
+		}
+		if result.Plan.Files() != 1 {
+			t.Fatalf("expected 1 file in result, got %d", result.Plan.Files())
+		}
+	})
+
+	t.Run("reports_errors_to_observer", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		destinationFile := filepath.Join(tempDir, "dest.txt")
+		for _, path := range []string{sourceFile, destinationFile} {
+			if err := os.WriteFile(path, []byte("exists"), 0o644); err != nil {
+				t.Fatalf("write %q: %v", path, err)
+			}
+		}
+
+		observer := &recordingObserver{}
+		_, err := New(Options{}, observer).Copy(context.Background(), []string{sourceFile}, destinationFile)
+		if err == nil {
+			t.Fatalf("expected overwrite error without Force")
+		}
+		if got := observer.events[len(observer.events)-1]; got != "error:source.txt" {
+			t.Fatalf("expected error event last, got %q", got)
+		}
+	})
+
+	t.Run("stops_when_context_is_cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.txt")
+		if err := os.WriteFile(sourceFile, []byte("cancelled"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := New(Options{}, nil).Copy(ctx, []string{sourceFile}, filepath.Join(tempDir, "dest.txt"))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("fsync_modes", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := []struct {
+			mode        FsyncMode
+			wantFlushes int
+		}{
+			{mode: FsyncNone, wantFlushes: 0},
+			{mode: FsyncFile, wantFlushes: 3},
+			{mode: FsyncEnd, wantFlushes: 1},
+		}
+
+		for _, testCase := range testCases {
+			testCase := testCase
+			t.Run("mode_"+string(testCase.mode), func(t *testing.T) {
+				t.Parallel()
+
+				tempDir := t.TempDir()
+				sourceDir := filepath.Join(tempDir, "source")
+				if err := os.MkdirAll(sourceDir, 0o755); err != nil {
+					t.Fatalf("mkdir source: %v", err)
+				}
+				for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+					if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name), 0o444); err != nil {
+						t.Fatalf("write %q: %v", name, err)
+					}
+				}
+
+				observer := &flushCountingObserver{}
+				opts := Options{Recursive: true, Preserve: true, Fsync: testCase.mode}
+				destinationDir := filepath.Join(tempDir, "dest")
+				if _, err := New(opts, observer).Copy(context.Background(), []string{sourceDir}, destinationDir); err != nil {
+					t.Fatalf("copy: %v", err)
+				}
+				if observer.flushes != testCase.wantFlushes {
+					t.Fatalf("expected %d flushes, got %d", testCase.wantFlushes, observer.flushes)
+				}
+			})
+		}
+	})
+
+	t.Run("fsync_syncs_created_directories", func(t *testing.T) {
+		t.Parallel()
+
+		for _, mode := range []FsyncMode{FsyncFile, FsyncEnd} {
+			t.Run("mode_"+string(mode), func(t *testing.T) {
+				t.Parallel()
+
+				tempDir := t.TempDir()
+				sourceFile := filepath.Join(tempDir, "source.txt")
+				if err := os.WriteFile(sourceFile, []byte("data"), 0o644); err != nil {
+					t.Fatalf("write source file: %v", err)
+				}
+
+				var synced []string
+				backend := syncRecordingBackend{noSyncFS: true, directories: &synced}
+				opts := Options{Fsync: mode, Backend: backend}
+				destinationFile := filepath.Join(tempDir, "dest", "a", "b", "file.txt")
+				if _, err := New(opts, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err != nil {
+					t.Fatalf("copy: %v", err)
+				}
+
+				// The entry of each new directory lives in its parent, up to
+				// tempDir, which already existed.
+				for _, directory := range []string{
+					tempDir,
+					filepath.Join(tempDir, "dest"),
+					filepath.Join(tempDir, "dest", "a"),
+					filepath.Join(tempDir, "dest", "a", "b"),
+				} {
+					if !slices.Contains(synced, directory) {
+						t.Fatalf("expected %q to be synced, got %v", directory, synced)
+					}
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,682 @@
+package copier
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OperationKind identifies what a planned Operation does.
+type OperationKind int
+
+const (
+	OperationCreateDirectory OperationKind = iota
+	OperationCopyFile
+)
+
+// Operation is a single step of a copy plan.
+type Operation struct {
+	Kind        OperationKind
+	Source      string
+	Destination string
+	Mode        fs.FileMode
+	ModTime     time.Time
+	Size        uint64
+	// LinkFrom is the unchanged file in Options.LinkDest that Destination
+	// is hard-linked to instead of copying Source, if any.
+	LinkFrom string
+
+	// fsys is the filesystem Source lives in, or nil for the local one.
+	fsys fs.FS
+	// stream is standard input for a "-" source, read instead of opening
+	// Source.
+	stream io.Reader
+	// info is the local source's stat when the plan was built, used to
+	// notice it changing during the copy.
+	info fs.FileInfo
+	// compress and decompress select how file contents are transformed on
+	// the way to Destination.
+	compress   Compression
+	decompress Compression
+}
+
+// planDestination describes what a plan's targets are joined onto.
+type planDestination struct {
+	path   string
+	exists bool
+	isDir  bool
+	// virtual marks destinations that are not local paths, such as the root
+	// of an archive being written, so same-file checks are skipped.
+	virtual bool
+	// stream marks a "-" destination: every file is written to standard
+	// output.
+	stream bool
+}
+
+func statDestination(backend Backend, destination string) (planDestination, error) {
+	if destination == streamPath {
+		return planDestination{path: streamPath, virtual: true, stream: true}, nil
+	}
+
+	destInfo, destErr := backend.Stat(destination)
+	destExists := destErr == nil
+	if destErr != nil && !errors.Is(destErr, os.ErrNotExist) {
+		return planDestination{}, fmt.Errorf("stat destination %q: %w", destination, destErr)
+	}
+
+	return planDestination{
+		path:   destination,
+		exists: destExists,
+		isDir:  destExists && destInfo.IsDir(),
+		// Same-file and nesting checks only make sense on the local
+		// filesystem.
+		virtual: !isLocalBackend(backend),
+	}, nil
+}
+
+func planSources(sources []string, dest planDestination, opts Options) (Plan, error) {
+	destination := dest.path
+	destExists := dest.exists
+	destIsDir := dest.isDir
+	if opts.Relative && destExists && !destIsDir {
+		return Plan{}, fmt.Errorf("destination %q must be a directory when keeping relative paths", destination)
+	}
+	if len(sources) > 1 && !destIsDir && !opts.Relative && !dest.stream {
+		return Plan{}, fmt.Errorf("destination %q must be an existing directory when copying multiple sources", destination)
+	}
+
+	plan := Plan{Operations: make([]Operation, 0, len(sources))}
+	readStdin := false
+
+	for _, source := range sources {
+		if source == streamPath {
+			if readStdin {
+				return Plan{}, fmt.Errorf("standard input can only be copied once")
+			}
+			readStdin = true
+
+			if destIsDir {
+				return Plan{}, fmt.Errorf("cannot copy standard input into directory %q; name the destination file", destination)
+			}
+			plan.Operations = append(plan.Operations, streamOperation(destination, opts))
+			plan.TotalBytes += opts.StreamSize
+			continue
+		}
+
+		source = filepath.Clean(source)
+
+		sourceInfo, err := os.Lstat(source)
+		if err != nil {
+			return Plan{}, fmt.Errorf("stat source %q: %w", source, err)
+		}
+
+		if sourceInfo.Mode()&os.ModeSymlink != 0 {
+			return Plan{}, fmt.Errorf("symbolic links are not supported: %q", source)
+		}
+
+		target := destination
+		if dest.stream {
+			if sourceInfo.IsDir() {
+				return Plan{}, fmt.Errorf("cannot copy directory %q to standard output", source)
+			}
+		} else if opts.Relative {
+			relative, err := relativeSourcePath(source)
+			if err != nil {
+				return Plan{}, err
+			}
+			target = filepath.Join(destination, relative)
+		} else if len(sources) > 1 || destIsDir {
+			target = filepath.Join(destination, filepath.Base(source))
+		}
+
+		if sourceInfo.IsDir() {
+			if !opts.Recursive && opts.Relative {
+				plan.Operations = append(plan.Operations, Operation{
+					Kind:        OperationCreateDirectory,
+					Source:      source,
+					Destination: target,
+					Mode:        sourceInfo.Mode(),
+					ModTime:     sourceInfo.ModTime(),
+				})
+				continue
+			}
+			if !opts.Recursive {
+				return Plan{}, fmt.Errorf("omitting directory %q (use -r or --recursive)", source)
+			}
+
+			if destExists && !destIsDir && len(sources) == 1 {
+				return Plan{}, fmt.Errorf("cannot overwrite non-directory %q with directory %q", destination, source)
+			}
+
+			if !dest.virtual {
+				if err := ensureDestinationOutsideSource(source, target); err != nil {
+					return Plan{}, err
+				}
+			}
+
+			directoryPlan, err := collectDirectoryOperations(source, target, opts.Filter)
+			if err != nil {
+				return Plan{}, err
+			}
+
+			plan.Operations = append(plan.Operations, directoryPlan.Operations...)
+			plan.TotalBytes += directoryPlan.TotalBytes
+			plan.Filtered.merge(directoryPlan.Filtered)
+			continue
+		}
+
+		if !dest.virtual {
+			sameFile, err := refersToSameFile(source, target)
+			if err != nil {
+				return Plan{}, err
+			}
+			if sameFile {
+				return Plan{}, fmt.Errorf("%q and %q are the same file", source, target)
+			}
+		}
+
+		size := sourceInfo.Size()
+		if size < 0 {
+			size = 0
+		}
+
+		plan.Operations = append(plan.Operations, Operation{
+			Kind:        OperationCopyFile,
+			Source:      source,
+			Destination: target,
+			Mode:        sourceInfo.Mode(),
+			ModTime:     sourceInfo.ModTime(),
+			Size:        uint64(size),
+			info:        sourceInfo,
+		})
+		plan.TotalBytes += uint64(size)
+	}
+
+	return plan, nil
+}
+
+// relativeSourcePath returns the path a source keeps under the destination
+// with Options.Relative: the cleaned source without any leading root. Sources
+// outside the current directory have no such path.
+func relativeSourcePath(source string) (string, error) {
+	relative := source
+	if filepath.IsAbs(relative) {
+		relative = relative[len(filepath.VolumeName(relative)):]
+		relative = strings.TrimLeft(relative, string(filepath.Separator))
+	}
+
+	if relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("cannot keep the relative path of %q, which is outside the current directory", source)
+	}
+	if relative == "" {
+		return ".", nil
+	}
+	return relative, nil
+}
+
+// collectDirectoryOperations plans copying the directory sourceRoot to
+// destinationRoot, leaving out the entries filter excludes.
+func collectDirectoryOperations(sourceRoot string, destinationRoot string, filter Filter) (Plan, error) {
+	plan := Plan{Operations: make([]Operation, 0, 16)}
+	var rootDevice uint64
+
+	err := filepath.WalkDir(sourceRoot, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		if entry.Type()&os.ModeSymlink != 0 {
+			return fmt.Errorf("symbolic links are not supported: %q", path)
+		}
+
+		entryInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(sourceRoot, path)
+		if err != nil {
+			return err
+		}
+
+		if filter.OneFileSystem {
+			device, ok := deviceOf(entryInfo)
+			if !ok {
+				return fmt.Errorf("cannot tell which filesystem %q is on to stay on one filesystem", path)
+			}
+			if relativePath == "." {
+				rootDevice = device
+			} else if device != rootDevice {
+				plan.Filtered.FileSystem++
+				filter.skip(path, "one-file-system", entryInfo)
+				return skipEntry(entry)
+			}
+		}
+		if reason := filter.excludes(relativePath, string(filepath.Separator), entryInfo, &plan.Filtered); reason != "" {
+			filter.skip(path, reason, entryInfo)
+			return skipEntry(entry)
+		}
+
+		destinationPath := destinationRoot
+		if relativePath != "." {
+			destinationPath = filepath.Join(destinationRoot, relativePath)
+		}
+
+		if entry.IsDir() {
+			plan.Operations = append(plan.Operations, Operation{
+				Kind:        OperationCreateDirectory,
+				Source:      path,
+				Destination: destinationPath,
+				Mode:        entryInfo.Mode(),
+				ModTime:     entryInfo.ModTime(),
+			})
+			if filter.stopsAt(relativePath, string(filepath.Separator)) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		size := entryInfo.Size()
+		if size < 0 {
+			size = 0
+		}
+
+		plan.Operations = append(plan.Operations, Operation{
+			Kind:        OperationCopyFile,
+			Source:      path,
+			Destination: destinationPath,
+			Mode:        entryInfo.Mode(),
+			ModTime:     entryInfo.ModTime(),
+			Size:        uint64(size),
+			info:        entryInfo,
+		})
+		plan.TotalBytes += uint64(size)
+		return nil
+	})
+	if err != nil {
+		return Plan{}, fmt.Errorf("walk source directory %q: %w", sourceRoot, err)
+	}
+
+	return plan, nil
+}
+
+// skipEntry leaves entry out of a walk, along with everything inside it.
+func skipEntry(entry fs.DirEntry) error {
+	if entry.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
+
+func ensureDestinationOutsideSource(sourceDirectory string, destinationPath string) error {
+	sourceAbs, err := filepath.Abs(sourceDirectory)
+	if err != nil {
+		return fmt.Errorf("resolve source path %q: %w", sourceDirectory, err)
+	}
+
+	destinationAbs, err := filepath.Abs(destinationPath)
+	if err != nil {
+		return fmt.Errorf("resolve destination path %q: %w", destinationPath, err)
+	}
+
+	relative, err := filepath.Rel(sourceAbs, destinationAbs)
+	if err != nil {
+		return fmt.Errorf("check destination relation: %w", err)
+	}
+
+	if relative == "." || relative == "" {
+		return fmt.Errorf("cannot copy %q to itself", sourceDirectory)
+	}
+
+	parentPrefix := ".." + string(os.PathSeparator)
+	if relative == ".." || strings.HasPrefix(relative, parentPrefix) {
+		return nil
+	}
+
+	return fmt.Errorf("cannot copy directory %q into itself (%q)", sourceDirectory, destinationPath)
+}
+
+func refersToSameFile(source string, destination string) (bool, error) {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false, fmt.Errorf("stat source %q: %w", source, err)
+	}
+
+	destinationInfo, err := os.Stat(destination)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat destination %q: %w", destination, err)
+	}
+
+	return os.SameFile(sourceInfo, destinationInfo), nil
+}
+
+// execution holds the state of a single run of a plan.
+type execution struct {
+	opts     Options
+	backend  Backend
+	observer Observer
+	stats    Stats
+	// written lists destination files still to be flushed by --fsync=end,
+	// and created the directories created for them.
+	written []string
+	created []string
+
+	// ring holds the copy buffers, allocated with ringBufferSize bytes each
+	// when the first file is copied.
+	ring           *bufferRing
+	ringBufferSize int
+	// sourceRead counts source bytes read so far, of which reported have
+	// been passed on to stats and the observer.
+	sourceRead atomic.Uint64
+	reported   uint64
+	// retries counts retried transient errors, from either goroutine.
+	retries atomic.Uint64
+
+	// dedupe indexes the files written so far with Options.Dedupe. The
+	// last file transferred, and the hash of its source bytes, are added
+	// once it is known not to be copied again.
+	dedupe            *dedupeIndex
+	transferredSource [sha256.Size]byte
+	// transferred is the hash of the last file written or linked, when
+	// files are hashed, and linkedTo the file the last link points at.
+	transferred FileHash
+	linkedTo    string
+
+	// events receives an Event for every step, if the observer wants them.
+	events EventObserver
+}
+
+func newExecution(plan []Operation, opts Options, observer Observer) *execution {
+	events, _ := observer.(EventObserver)
+	return &execution{
+		opts:           opts,
+		backend:        opts.backend(),
+		observer:       observer,
+		ringBufferSize: ringBufferSize(plan, opts),
+		events:         events,
+	}
+}
+
+func executePlan(ctx context.Context, plan []Operation, opts Options, observer Observer) (Stats, error) {
+	e := newExecution(plan, opts, observer)
+	err := e.run(ctx, plan)
+	e.stats.Retries = e.retries.Load()
+	return e.stats, err
+}
+
+func (e *execution) run(ctx context.Context, plan []Operation) error {
+	directoriesToPreserve := make([]Operation, 0)
+	if e.opts.Dedupe != DedupeNone {
+		index, err := newDedupeIndex(e.opts.LinkDest)
+		if err != nil {
+			return err
+		}
+		e.dedupe = index
+	}
+
+	for _, op := range plan {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch op.Kind {
+		case OperationCreateDirectory:
+			event := e.startEvent(EventMkdir, op)
+			if err := e.mkdirAll(op.Destination, op.Mode.Perm()); err != nil {
+				err = fmt.Errorf("create directory %q: %w", op.Destination, err)
+				e.finishEvent(event, err)
+				e.observer.Error(op, err)
+				return err
+			}
+			e.finishEvent(event, nil)
+			if e.opts.Preserve {
+				directoriesToPreserve = append(directoriesToPreserve, op)
+			}
+
+		case OperationCopyFile:
+			e.observer.FileStart(op)
+			event := e.startEvent(EventCopy, op)
+			var linked bool
+			var err error
+			if op.LinkFrom != "" {
+				linked, err = true, e.linkFromSnapshot(op)
+			} else {
+				linked, err = e.dedupeFile(ctx, op)
+			}
+			if err == nil && !linked {
+				err = e.copyCheckedFile(ctx, op)
+				if err == nil && e.dedupe != nil {
+					e.addDedupe(op, e.transferredSource, e.transferred)
+				}
+			}
+			if linked {
+				event.event.Kind = EventLink
+				event.event.LinkedTo = e.linkedTo
+			}
+			e.finishEvent(event, err)
+			if err != nil {
+				e.observer.Error(op, err)
+				return err
+			}
+			e.observer.FileDone(op)
+
+		default:
+			return fmt.Errorf("unsupported copy operation: %v", op.Kind)
+		}
+	}
+
+	if e.opts.Preserve {
+		for i := len(directoriesToPreserve) - 1; i >= 0; i-- {
+			directory := directoriesToPreserve[i]
+			if err := setMetadata(e.backend, directory.Destination, directory.Mode, directory.ModTime); err != nil {
+				e.observer.Error(directory, err)
+				return err
+			}
+		}
+	}
+
+	if len(plan) > 0 {
+		return e.syncAll(plan[0].Destination)
+	}
+	return nil
+}
+
+// copyCheckedFile copies op, then copies it again for as long as its source
+// changed meanwhile and the OnChange policy says to retry.
+func (e *execution) copyCheckedFile(ctx context.Context, op Operation) error {
+	for attempt := 0; ; attempt++ {
+		read := e.stats.BytesRead
+		if err := e.copyFile(ctx, op, attempt > 0); err != nil {
+			return err
+		}
+
+		current, retry, err := e.checkSource(op, attempt, true)
+		if err != nil || !retry {
+			return err
+		}
+		if retries, ok := e.observer.(RetryObserver); ok {
+			retries.FileRetry(op, e.stats.BytesRead-read)
+		}
+		op = current
+	}
+}
+
+// copyFile copies a single file. Retries overwrite the copy made by the
+// previous attempt even without Force.
+func (e *execution) copyFile(ctx context.Context, op Operation, retry bool) error {
+	if err := e.mkdirAll(filepath.Dir(op.Destination), 0o755); err != nil {
+		return fmt.Errorf("create destination parent for %q: %w", op.Destination, err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !e.opts.Force && !retry {
+		flags |= os.O_EXCL
+	}
+
+	destinationFile, err := e.backend.OpenFile(op.Destination, flags, op.Mode.Perm())
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("destination file exists (use -f to overwrite): %q", op.Destination)
+		}
+		return fmt.Errorf("open destination file %q: %w", op.Destination, err)
+	}
+
+	// Compressed sizes are unknown up front, so only plain copies reserve
+	// their space.
+	preallocated := !e.opts.NoPreallocate && op.compress == CompressionNone && op.decompress == CompressionNone
+	if preallocated {
+		if err := preallocate(destinationFile, op.Size); err != nil {
+			destinationFile.Close()
+			return fmt.Errorf("preallocate destination file %q: %w", op.Destination, err)
+		}
+	}
+
+	destination := e.newResumingWriter(ctx, op.Destination, op.Mode.Perm(), destinationFile, e.opts.Direct)
+
+	writtenBefore := e.stats.BytesWritten
+	if err := e.transfer(ctx, op, destination); err != nil {
+		destination.Close()
+		return err
+	}
+
+	// Release the reserved space past the end if the source shrank.
+	if written := e.stats.BytesWritten - writtenBefore; preallocated && written < op.Size {
+		if truncater, ok := destination.file.(interface{ Truncate(int64) error }); ok {
+			if err := truncater.Truncate(int64(written)); err != nil {
+				destination.Close()
+				return fmt.Errorf("truncate destination file %q: %w", op.Destination, err)
+			}
+		}
+	}
+
+	if err := e.syncOpenFile(destination.file, op.Destination); err != nil {
+		destination.Close()
+		return err
+	}
+
+	if err := destination.Close(); err != nil {
+		return fmt.Errorf("close destination file %q: %w", op.Destination, err)
+	}
+
+	if e.opts.Preserve {
+		if err := setMetadata(e.backend, op.Destination, op.Mode, op.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return e.syncWrittenFile(op.Destination)
+}
+
+// transfer writes the contents of op's source to destination, compressing or
+// decompressing them as planned.
+func (e *execution) transfer(ctx context.Context, op Operation, destination io.Writer) error {
+	sourceFile, err := e.openSourceReader(ctx, op)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	var source io.Reader = &progressReader{reader: sourceFile, count: &e.sourceRead}
+	var sourceHash hash.Hash
+	if e.dedupe != nil {
+		sourceHash = sha256.New()
+		source = io.TeeReader(source, sourceHash)
+	}
+	if op.decompress != CompressionNone {
+		decompressor, err := newDecompressor(op.decompress, source)
+		if err != nil {
+			return fmt.Errorf("read source file %q: %w", op.Source, err)
+		}
+		defer decompressor.Close()
+		source = decompressor
+	}
+
+	var writtenHash hash.Hash
+	if e.opts.Hash || e.dedupe != nil {
+		writtenHash = sha256.New()
+		destination = io.MultiWriter(destination, writtenHash)
+	}
+	written := &countingWriter{writer: destination}
+	var sink io.Writer = written
+	var compressor io.WriteCloser
+	if op.compress != CompressionNone {
+		compressor, err = newCompressor(op.compress, written)
+		if err != nil {
+			return fmt.Errorf("write destination file %q: %w", op.Destination, err)
+		}
+		sink = compressor
+	}
+
+	err = e.copyStream(ctx, op, sink, source)
+	e.reportBytes()
+	if compressor != nil && err == nil {
+		if closeErr := compressor.Close(); closeErr != nil {
+			err = fmt.Errorf("write destination file %q: %w", op.Destination, closeErr)
+		}
+	}
+	e.stats.BytesWritten += written.count
+	if writtenHash != nil && err == nil {
+		fileHash := FileHash{Destination: op.Destination, Size: written.count}
+		writtenHash.Sum(fileHash.SHA256[:0])
+		if e.opts.Hash {
+			e.recordHash(fileHash)
+		}
+		e.transferred = fileHash
+		if sourceHash != nil {
+			sourceHash.Sum(e.transferredSource[:0])
+		}
+	}
+	return err
+}
+
+func openSource(op Operation) (fs.File, error) {
+	if op.stream != nil {
+		return streamFile{Reader: op.stream}, nil
+	}
+	if op.fsys == nil {
+		file, err := os.Open(op.Source)
+		if err != nil {
+			return nil, fmt.Errorf("open source file %q: %w", op.Source, err)
+		}
+		return file, nil
+	}
+
+	file, err := op.fsys.Open(op.Source)
+	if err != nil {
+		return nil, fmt.Errorf("open source file %q: %w", op.Source, err)
+	}
+	return file, nil
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  uint64
+}
+
+func (w *countingWriter) Write(buffer []byte) (int, error) {
+	n, err := w.writer.Write(buffer)
+	w.count += uint64(n)
+	return n, err
+}
+
+func setMetadata(backend Backend, path string, mode fs.FileMode, modTime time.Time) error {
+	if err := backend.Chmod(path, mode.Perm()); err != nil {
+		return fmt.Errorf("set mode on %q: %w", path, err)
+	}
+	if err := backend.Chtimes(path, modTime, modTime); err != nil {
+		return fmt.Errorf("set modification time on %q: %w", path, err)
+	}
+	return nil
+}
@@ -1,7 +1,7 @@
-package zcp
+package copier
 
 import (
-	"io"
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,7 +24,7 @@ func TestCopyPlanAndExecution(t *testing.T) {
 			t.Fatalf("write source file: %v", err)
 		}
 
-		_, _, err := buildCopyPlan([]string{sourceDir}, filepath.Join(tempDir, "dest"), false)
+		_, err := New(Options{}, nil).Plan(context.Background(), []string{sourceDir}, filepath.Join(tempDir, "dest"))
 		if err == nil {
 			t.Fatalf("expected error for missing recursive flag")
 		}
@@ -51,7 +51,7 @@ func TestCopyPlanAndExecution(t *testing.T) {
 			t.Fatalf("write destination file: %v", err)
 		}
 
-		_, _, err := buildCopyPlan([]string{first, second}, notDirectory, false)
+		_, err := New(Options{}, nil).Plan(context.Background(), []string{first, second}, notDirectory)
 		if err == nil {
 			t.Fatalf("expected error for multiple sources to non-directory destination")
 		}
@@ -79,26 +79,20 @@ func TestCopyPlanAndExecution(t *testing.T) {
 		}
 
 		destinationRoot := filepath.Join(tempDir, "destination")
-		plan, totalBytes, err := buildCopyPlan([]string{sourceRoot}, destinationRoot, true)
+		opts := Options{
+			Recursive:  true,
+			Force:      false,
+			Preserve:   true,
+			BufferSize: 8,
+		}
+		result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
 		if err != nil {
-			t.Fatalf("build copy plan: %v", err)
+			t.Fatalf("copy: %v", err)
 		}
-		if totalBytes == 0 {
+		if result.Plan.TotalBytes == 0 {
 			t.Fatalf("expected non-zero total bytes")
 		}
 
-		opts := options{
-			recursive:  true,
-			force:      false,
-			preserve:   true,
-			quiet:      true,
-			bufferSize: 8,
-		}
-		progress := newProgressBar(totalBytes, false, io.Discard)
-		if err := executePlan(plan, opts, progress); err != nil {
-			t.Fatalf("execute plan: %v", err)
-		}
-
 		destinationFile := filepath.Join(destinationRoot, "nested", "payload.txt")
 		actualBytes, err := os.ReadFile(destinationFile)
 		if err != nil {
@@ -132,24 +126,19 @@ func TestCopyPlanAndExecution(t *testing.T) {
 			t.Fatalf("write destination file: %v", err)
 		}
 
-		plan, totalBytes, err := buildCopyPlan([]string{sourceFile}, destinationFile, false)
-		if err != nil {
-			t.Fatalf("build copy plan: %v", err)
+		noForce := Options{
+			Force:      false,
+			BufferSize: 4,
 		}
-
-		noForce := options{
-			force:      false,
-			bufferSize: 4,
-		}
-		if err := executePlan(plan, noForce, newProgressBar(totalBytes, false, io.Discard)); err == nil {
+		if _, err := New(noForce, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err == nil {
 			t.Fatalf("expected overwrite error without -f")
 		}
 
-		withForce := options{
-			force:      true,
-			bufferSize: 4,
+		withForce := Options{
+			Force:      true,
+			BufferSize: 4,
 		}
-		if err := executePlan(plan, withForce, newProgressBar(totalBytes, false, io.Discard)); err != nil {
+		if _, err := New(withForce, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err != nil {
 			t.Fatalf("force overwrite failed: %v", err)
 		}
 
@@ -161,4 +150,66 @@ func TestCopyPlanAndExecution(t *testing.T) {
 			t.Fatalf("expected destination content to be overwritten, got %q", string(actual))
 		}
 	})
+
+	t.Run("keeps_relative_paths", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceDir := filepath.Join(tempDir, "listed")
+		sourceFile := filepath.Join(sourceDir, "file.txt")
+		if err := os.MkdirAll(filepath.Join(sourceDir, "unlisted"), 0o755); err != nil {
+			t.Fatalf("create source directories: %v", err)
+		}
+		if err := os.WriteFile(sourceFile, []byte("listed"), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		destinationDir := filepath.Join(tempDir, "dest")
+		dest, err := statDestination(LocalBackend{}, destinationDir)
+		if err != nil {
+			t.Fatalf("stat destination: %v", err)
+		}
+		result, err := planSources([]string{sourceDir, sourceFile}, dest, Options{Relative: true})
+		if err != nil {
+			t.Fatalf("plan sources: %v", err)
+		}
+		plan := result.Operations
+
+		if len(plan) != 2 {
+			t.Fatalf("expected the directory to be created without recursing, got %d operations", len(plan))
+		}
+		if plan[0].Kind != OperationCreateDirectory {
+			t.Fatalf("expected a directory operation first, got %v", plan[0].Kind)
+		}
+		relative, err := relativeSourcePath(sourceFile)
+		if err != nil {
+			t.Fatalf("relative source path: %v", err)
+		}
+		if want := filepath.Join(destinationDir, relative); plan[1].Destination != want {
+			t.Fatalf("expected %q, got %q", want, plan[1].Destination)
+		}
+	})
+
+	t.Run("relative_source_paths", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := []struct {
+			source string
+			want   string
+		}{
+			{source: "a/b.txt", want: filepath.Join("a", "b.txt")},
+			{source: ".", want: "."},
+			{source: string(filepath.Separator), want: "."},
+		}
+		for _, testCase := range testCases {
+			got, err := relativeSourcePath(filepath.Clean(testCase.source))
+			if err != nil || got != testCase.want {
+				t.Fatalf("relativeSourcePath(%q) = %q, %v; want %q", testCase.source, got, err, testCase.want)
+			}
+		}
+
+		if _, err := relativeSourcePath(filepath.Join("..", "outside")); err == nil {
+			t.Fatalf("expected a source outside the current directory to be rejected")
+		}
+	})
 }
@@ -0,0 +1,239 @@
+package copier
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DedupeMode selects how a file identical to one already on the destination
+// is stored instead of being written again.
+type DedupeMode string
+
+const (
+	// DedupeNone writes every file.
+	DedupeNone DedupeMode = ""
+	// DedupeHardlink hard-links identical files. Hard links share their mode
+	// and modification time, so those must match too.
+	DedupeHardlink DedupeMode = "hardlink"
+	// DedupeReflink clones identical files, sharing their data but nothing
+	// else, where the filesystem supports it (Btrfs, XFS).
+	DedupeReflink DedupeMode = "reflink"
+)
+
+// ParseDedupeMode parses a --dedupe value.
+func ParseDedupeMode(value string) (DedupeMode, error) {
+	switch value {
+	case "", "none":
+		return DedupeNone, nil
+	case string(DedupeHardlink), string(DedupeReflink):
+		return DedupeMode(value), nil
+	default:
+		return DedupeNone, fmt.Errorf("unsupported dedupe mode %q (expected hardlink or reflink)", value)
+	}
+}
+
+// dedupeKey identifies files that can share storage: the same source bytes,
+// transformed the same way, and for hard links the same metadata.
+type dedupeKey struct {
+	sum        [sha256.Size]byte
+	compress   Compression
+	decompress Compression
+	mode       fs.FileMode
+	modTime    int64
+}
+
+// dedupeIndex finds files already on the destination by content.
+type dedupeIndex struct {
+	files map[dedupeKey]FileHash
+	sizes map[uint64]bool
+	// candidates are link-dest files not hashed yet, by size. They are only
+	// hashed once a source file of the same size turns up.
+	candidates map[uint64][]string
+}
+
+// newDedupeIndex indexes the regular files below linkDest, if given, as
+// candidates.
+func newDedupeIndex(linkDest string) (*dedupeIndex, error) {
+	index := &dedupeIndex{
+		files:      make(map[dedupeKey]FileHash),
+		sizes:      make(map[uint64]bool),
+		candidates: make(map[uint64][]string),
+	}
+	if linkDest == "" {
+		return index, nil
+	}
+
+	err := filepath.WalkDir(linkDest, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size := uint64(info.Size())
+			index.candidates[size] = append(index.candidates[size], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk link-dest directory %q: %w", linkDest, err)
+	}
+	return index, nil
+}
+
+func (e *execution) dedupeKey(sum [sha256.Size]byte, compress Compression, decompress Compression, mode fs.FileMode, modTime int64) dedupeKey {
+	key := dedupeKey{sum: sum, compress: compress, decompress: decompress}
+	if e.opts.Dedupe == DedupeHardlink {
+		key.mode = mode.Perm()
+		if e.opts.Preserve {
+			key.modTime = modTime
+		}
+	}
+	return key
+}
+
+// addDedupe records a file written during the run, whose source bytes hashed to
+// sourceSum.
+func (e *execution) addDedupe(op Operation, sourceSum [sha256.Size]byte, written FileHash) {
+	key := e.dedupeKey(sourceSum, op.compress, op.decompress, op.Mode, op.ModTime.UnixNano())
+	if _, ok := e.dedupe.files[key]; !ok {
+		e.dedupe.files[key] = written
+	}
+	e.dedupe.sizes[op.Size] = true
+}
+
+// hashCandidates hashes the link-dest files of the given size into the
+// index. They hold destination contents as they are, so they only match
+// files copied without compression or decompression.
+func (e *execution) hashCandidates(size uint64) error {
+	for _, path := range e.dedupe.candidates[size] {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("stat link-dest file %q: %w", path, err)
+		}
+		sum, err := hashFile(path, NopObserver{})
+		if err != nil {
+			return err
+		}
+
+		written := FileHash{Destination: path, Size: uint64(info.Size())}
+		copy(written.SHA256[:], sum)
+		key := e.dedupeKey(written.SHA256, CompressionNone, CompressionNone, info.Mode(), info.ModTime().UnixNano())
+		if _, ok := e.dedupe.files[key]; !ok {
+			e.dedupe.files[key] = written
+		}
+		e.dedupe.sizes[size] = true
+	}
+	delete(e.dedupe.candidates, size)
+	return nil
+}
+
+// dedupeFile links op's destination to an identical file already on the
+// destination, reporting whether it did. The source is only hashed up front
+// when a file of the same size exists; otherwise it is hashed as it is
+// copied. Files the filesystem cannot link are copied instead.
+func (e *execution) dedupeFile(ctx context.Context, op Operation) (bool, error) {
+	if e.dedupe == nil || op.stream != nil {
+		return false, nil
+	}
+	if err := e.hashCandidates(op.Size); err != nil {
+		return false, err
+	}
+	if !e.dedupe.sizes[op.Size] {
+		return false, nil
+	}
+
+	sum, err := hashSource(op)
+	if err != nil {
+		return false, err
+	}
+	existing, ok := e.dedupe.files[e.dedupeKey(sum, op.compress, op.decompress, op.Mode, op.ModTime.UnixNano())]
+	if !ok || existing.Destination == op.Destination {
+		return false, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if err := e.linkFile(existing.Destination, op); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return false, fmt.Errorf("destination file exists (use -f to overwrite): %q", op.Destination)
+		}
+		return false, nil
+	}
+
+	e.stats.Deduplicated++
+	e.stats.BytesSaved += existing.Size
+	e.sourceRead.Add(op.Size)
+	e.reportBytes()
+	e.linkedTo = existing.Destination
+	e.transferred = FileHash{Destination: op.Destination, Size: existing.Size, SHA256: existing.SHA256}
+	if e.opts.Hash {
+		e.recordHash(e.transferred)
+	}
+	return true, e.syncWrittenFile(op.Destination)
+}
+
+// linkFile stores op's destination as a link to existing.
+func (e *execution) linkFile(existing string, op Operation) error {
+	if err := e.mkdirAll(filepath.Dir(op.Destination), 0o755); err != nil {
+		return fmt.Errorf("create destination parent for %q: %w", op.Destination, err)
+	}
+	if e.opts.Force {
+		if err := os.Remove(op.Destination); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if e.opts.Dedupe == DedupeHardlink {
+		return os.Link(existing, op.Destination)
+	}
+
+	source, err := os.Open(existing)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(op.Destination, os.O_CREATE|os.O_WRONLY|os.O_EXCL, op.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err := cloneFile(destination, source); err != nil {
+		destination.Close()
+		os.Remove(op.Destination)
+		return err
+	}
+	if err := destination.Close(); err != nil {
+		return err
+	}
+	if e.opts.Preserve {
+		return setMetadata(e.backend, op.Destination, op.Mode, op.ModTime)
+	}
+	return nil
+}
+
+// hashSource returns the SHA-256 digest of op's source bytes.
+func hashSource(op Operation) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	file, err := openSource(op)
+	if err != nil {
+		return sum, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return sum, fmt.Errorf("read source file %q: %w", op.Source, err)
+	}
+	hash.Sum(sum[:0])
+	return sum, nil
+}
@@ -0,0 +1,189 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupe(t *testing.T) {
+	t.Parallel()
+
+	writeFiles := func(t *testing.T, root string, files map[string]string) {
+		t.Helper()
+
+		// Hard links share their modification time, so give every file the
+		// same one.
+		modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		for name, content := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("set modtime: %v", err)
+			}
+		}
+	}
+	sameFile := func(t *testing.T, a, b string) bool {
+		t.Helper()
+
+		infoA, err := os.Stat(a)
+		if err != nil {
+			t.Fatalf("stat %q: %v", a, err)
+		}
+		infoB, err := os.Stat(b)
+		if err != nil {
+			t.Fatalf("stat %q: %v", b, err)
+		}
+		return os.SameFile(infoA, infoB)
+	}
+
+	content := strings.Repeat("duplicate ", 1000)
+	sourceFiles := map[string]string{
+		"a.txt":        content,
+		"nested/b.txt": content,
+		"c.txt":        strings.Repeat("different ", 1000),
+	}
+
+	t.Run("hard_links_files_written_in_the_run", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		writeFiles(t, sourceRoot, sourceFiles)
+
+		opts := Options{Recursive: true, Preserve: true, Hash: true, Dedupe: DedupeHardlink}
+		result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		if !sameFile(t, filepath.Join(destinationRoot, "a.txt"), filepath.Join(destinationRoot, "nested", "b.txt")) {
+			t.Fatalf("expected the identical files to be hard-linked")
+		}
+		if sameFile(t, filepath.Join(destinationRoot, "a.txt"), filepath.Join(destinationRoot, "c.txt")) {
+			t.Fatalf("expected different files not to be linked")
+		}
+		stats := result.Stats
+		if stats.Deduplicated != 1 || stats.BytesSaved != uint64(len(content)) {
+			t.Fatalf("expected one file deduplicated saving %d bytes, got %d saving %d", len(content), stats.Deduplicated, stats.BytesSaved)
+		}
+		if stats.BytesRead != result.Plan.TotalBytes || stats.BytesWritten != result.Plan.TotalBytes-uint64(len(content)) {
+			t.Fatalf("expected every byte read and the duplicate not written, got %+v", stats)
+		}
+		if len(stats.Hashes) != 3 {
+			t.Fatalf("expected linked files in the hashes too, got %d", len(stats.Hashes))
+		}
+	})
+
+	t.Run("hard_links_need_matching_metadata", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		writeFiles(t, sourceRoot, sourceFiles)
+		if err := os.Chmod(filepath.Join(sourceRoot, "a.txt"), 0o600); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+
+		opts := Options{Recursive: true, Preserve: true, Dedupe: DedupeHardlink}
+		result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if result.Stats.Deduplicated != 0 {
+			t.Fatalf("expected files with different modes not to be linked")
+		}
+	})
+
+	t.Run("links_to_files_in_link_dest", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		previous := filepath.Join(tempDir, "previous")
+		writeFiles(t, sourceRoot, sourceFiles)
+		writeFiles(t, previous, map[string]string{"renamed.txt": sourceFiles["c.txt"]})
+
+		for compress, deduplicated := range map[Compression]int{CompressionNone: 2, CompressionGzip: 1} {
+			destinationRoot := filepath.Join(tempDir, string(compress)+"dest")
+			opts := Options{Recursive: true, Dedupe: DedupeHardlink, LinkDest: previous, Compress: compress}
+			result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+
+			// Link-dest files hold uncompressed contents, so only the
+			// plain copy can link to them.
+			name := "c.txt"
+			if compress != CompressionNone {
+				name += ".gz"
+			}
+			linked := sameFile(t, filepath.Join(previous, "renamed.txt"), filepath.Join(destinationRoot, name))
+			if linked != (compress == CompressionNone) || result.Stats.Deduplicated != deduplicated {
+				t.Fatalf("%q: unexpected dedupe, linked %v, stats %+v", compress, linked, result.Stats)
+			}
+		}
+	})
+
+	t.Run("reflinks_or_copies", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		destinationRoot := filepath.Join(tempDir, "dest")
+		writeFiles(t, sourceRoot, sourceFiles)
+
+		// Filesystems without reflinks fall back to copying.
+		opts := Options{Recursive: true, Dedupe: DedupeReflink}
+		result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		for name, want := range sourceFiles {
+			got, err := os.ReadFile(filepath.Join(destinationRoot, filepath.FromSlash(name)))
+			if err != nil || !bytes.Equal(got, []byte(want)) {
+				t.Fatalf("unexpected contents of %q: %v", name, err)
+			}
+		}
+		if sameFile(t, filepath.Join(destinationRoot, "a.txt"), filepath.Join(destinationRoot, "nested", "b.txt")) {
+			t.Fatalf("expected a reflink to be a separate file")
+		}
+		if result.Stats.Deduplicated > 1 {
+			t.Fatalf("expected at most one file deduplicated, got %d", result.Stats.Deduplicated)
+		}
+	})
+
+	t.Run("rejects_unsupported_destinations", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		writeFiles(t, tempDir, map[string]string{"a.txt": "a"})
+		source := []string{filepath.Join(tempDir, "a.txt")}
+		for _, testCase := range []struct {
+			opts        Options
+			destination string
+			want        string
+		}{
+			{opts: Options{Dedupe: DedupeHardlink, ToArchive: true}, destination: filepath.Join(tempDir, "out.tar"), want: "local files"},
+			{opts: Options{Dedupe: DedupeHardlink}, destination: "-", want: "local files"},
+			{opts: Options{LinkDest: tempDir, ToArchive: true}, destination: filepath.Join(tempDir, "out.zip"), want: "local files"},
+			{opts: Options{Dedupe: "symlink"}, destination: filepath.Join(tempDir, "b.txt"), want: "unsupported dedupe mode"},
+		} {
+			_, err := New(testCase.opts, nil).Copy(context.Background(), source, testCase.destination)
+			if err == nil || !strings.Contains(err.Error(), testCase.want) {
+				t.Fatalf("expected error containing %q, got %v", testCase.want, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package copier
+
+import "io/fs"
+
+// deviceOf cannot tell filesystems apart here.
+func deviceOf(fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// inodeOf has no inode numbers to return here.
+func inodeOf(fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
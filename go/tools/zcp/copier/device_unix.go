@@ -0,0 +1,26 @@
+//go:build unix
+
+package copier
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// deviceOf returns the ID of the filesystem holding the file info describes.
+func deviceOf(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
+
+// inodeOf returns the inode number of the file info describes.
+func inodeOf(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Ino), true
+}
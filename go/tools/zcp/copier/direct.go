@@ -0,0 +1,87 @@
+package copier
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// directAlignment is the buffer address, file offset and length alignment
+// direct I/O needs. 4 KiB covers the logical block size of current disks.
+const directAlignment = 4096
+
+// alignedBuffer allocates a buffer of size bytes starting on a
+// directAlignment boundary.
+func alignedBuffer(size int) []byte {
+	buffer := make([]byte, size+directAlignment)
+	offset := 0
+	if remainder := int(uintptr(unsafe.Pointer(&buffer[0])) % directAlignment); remainder != 0 {
+		offset = directAlignment - remainder
+	}
+	return buffer[offset : offset+size : offset+size]
+}
+
+func alignBufferSize(size int) int {
+	return int(roundUpToBlock(uint64(size), directAlignment))
+}
+
+// directFile reads or writes a local file without going through the page
+// cache (--direct). It uses direct I/O while reads and writes stay aligned,
+// and otherwise falls back to dropping each chunk from the cache once it has
+// been read or written: for unaligned tails, unaligned buffers and
+// filesystems without direct I/O.
+type directFile struct {
+	*os.File
+	direct bool
+	offset int64
+}
+
+func newDirectFile(file *os.File) *directFile {
+	return &directFile{File: file, direct: setDirect(file, true) == nil}
+}
+
+func (f *directFile) Read(buffer []byte) (int, error) {
+	n, err := f.File.Read(buffer)
+	if f.direct && n == 0 && errors.Is(err, syscall.EINVAL) {
+		f.disableDirect()
+		n, err = f.File.Read(buffer)
+	}
+
+	if n > 0 {
+		if !f.direct {
+			dropCache(f.File, f.offset, int64(n), false)
+		}
+		f.offset += int64(n)
+		// A short read leaves the offset unaligned for the next one.
+		if f.direct && n%directAlignment != 0 {
+			f.disableDirect()
+		}
+	}
+	return n, err
+}
+
+func (f *directFile) Write(buffer []byte) (int, error) {
+	if f.direct && len(buffer)%directAlignment != 0 {
+		f.disableDirect()
+	}
+
+	n, err := f.File.Write(buffer)
+	if f.direct && n == 0 && errors.Is(err, syscall.EINVAL) {
+		f.disableDirect()
+		n, err = f.File.Write(buffer)
+	}
+
+	if n > 0 {
+		if !f.direct {
+			dropCache(f.File, f.offset, int64(n), true)
+		}
+		f.offset += int64(n)
+	}
+	return n, err
+}
+
+func (f *directFile) disableDirect() {
+	f.direct = false
+	_ = setDirect(f.File, false)
+}
@@ -0,0 +1,41 @@
+package copier
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDirect turns O_DIRECT on or off for an open file. Filesystems that do
+// not support it fail with EINVAL.
+func setDirect(file *os.File, enabled bool) error {
+	fd := int(file.Fd())
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		flags |= unix.O_DIRECT
+	} else {
+		flags &^= unix.O_DIRECT
+	}
+	_, err = unix.FcntlInt(uintptr(fd), unix.F_SETFL, flags)
+	return err
+}
+
+// dropCache evicts a chunk of file from the page cache. Written chunks are
+// flushed first, since dirty pages cannot be dropped. Failures are ignored:
+// this is only advice to the kernel.
+func dropCache(file *os.File, offset int64, length int64, written bool) {
+	fd := int(file.Fd())
+	if written {
+		_ = unix.SyncFileRange(
+			fd,
+			offset,
+			length,
+			unix.SYNC_FILE_RANGE_WAIT_BEFORE|unix.SYNC_FILE_RANGE_WRITE|unix.SYNC_FILE_RANGE_WAIT_AFTER,
+		)
+	}
+	_ = unix.Fadvise(fd, offset, length, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package copier
+
+import (
+	"errors"
+	"os"
+)
+
+// setDirect reports that direct I/O is unavailable outside Linux.
+func setDirect(*os.File, bool) error {
+	return errors.ErrUnsupported
+}
+
+// dropCache is a no-op where the page cache cannot be advised.
+func dropCache(*os.File, int64, int64, bool) {}
@@ -0,0 +1,94 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestDirect(t *testing.T) {
+	t.Parallel()
+
+	t.Run("copies_aligned_and_unaligned_sizes", func(t *testing.T) {
+		t.Parallel()
+
+		for _, size := range []int{0, 1, directAlignment, 3*1024*1024 + 7} {
+			tempDir := t.TempDir()
+			sourceFile := filepath.Join(tempDir, "source.bin")
+			content := bytes.Repeat([]byte{0x5a}, size)
+			if err := os.WriteFile(sourceFile, content, 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+
+			destinationFile := filepath.Join(tempDir, "dest.bin")
+			opts := Options{Direct: true, BufferSize: 1000}
+			if _, err := New(opts, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err != nil {
+				t.Fatalf("copy %d bytes: %v", size, err)
+			}
+
+			got, err := os.ReadFile(destinationFile)
+			if err != nil {
+				t.Fatalf("read destination file: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("destination content differs for %d bytes", size)
+			}
+		}
+	})
+
+	t.Run("falls_back_for_unaligned_tails", func(t *testing.T) {
+		t.Parallel()
+
+		file, err := os.Create(filepath.Join(t.TempDir(), "dest.bin"))
+		if err != nil {
+			t.Fatalf("create file: %v", err)
+		}
+		defer file.Close()
+
+		direct := newDirectFile(file)
+		if !direct.direct {
+			t.Skip("filesystem does not support direct I/O")
+		}
+
+		if _, err := direct.Write(alignedBuffer(directAlignment)); err != nil {
+			t.Fatalf("aligned write: %v", err)
+		}
+		if !direct.direct {
+			t.Fatalf("expected aligned write to keep direct I/O")
+		}
+		if _, err := direct.Write(alignedBuffer(directAlignment)[:10]); err != nil {
+			t.Fatalf("unaligned write: %v", err)
+		}
+		if direct.direct {
+			t.Fatalf("expected unaligned tail to fall back to cached I/O")
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatalf("stat file: %v", err)
+		}
+		if info.Size() != directAlignment+10 {
+			t.Fatalf("expected %d bytes written, got %d", directAlignment+10, info.Size())
+		}
+	})
+
+	t.Run("aligns_buffers", func(t *testing.T) {
+		t.Parallel()
+
+		for _, size := range []int{1, 4096, 100000} {
+			buffer := alignedBuffer(size)
+			if len(buffer) != size || cap(buffer) != size {
+				t.Fatalf("expected %d byte buffer, got len %d cap %d", size, len(buffer), cap(buffer))
+			}
+			if address := uintptr(unsafe.Pointer(&buffer[0])); address%directAlignment != 0 {
+				t.Fatalf("buffer at %#x is not aligned", address)
+			}
+		}
+		if got := alignBufferSize(1000); got != directAlignment {
+			t.Fatalf("expected buffer size rounded up to %d, got %d", directAlignment, got)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package copier
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"time"
+)
+
+// EventKind names the step of a copy an Event records.
+type EventKind string
+
+const (
+	EventMkdir EventKind = "mkdir"
+	EventCopy  EventKind = "copy"
+	// EventOverwrite is a copy that replaced an existing destination file.
+	EventOverwrite EventKind = "overwrite"
+	// EventLink is a file hard-linked or reflinked instead of copied, with
+	// Options.LinkDest or Options.Dedupe.
+	EventLink EventKind = "link"
+	// EventSkip is an entry Options.Filter left out, reported while the
+	// plan is built.
+	EventSkip  EventKind = "skip"
+	EventError EventKind = "error"
+)
+
+// Event records one finished step of a copy in more detail than Observer
+// callbacks carry.
+type Event struct {
+	Kind        EventKind
+	Source      string
+	Destination string
+	// Size is the size of the source file, and Written the number of bytes
+	// written for it, after any compression.
+	Size    uint64
+	Written uint64
+	// Duration is how long the step took.
+	Duration time.Duration
+	// SHA256 is the hex digest of the file as written, when it was hashed
+	// for Options.Hash or Options.Dedupe.
+	SHA256 string
+	// LinkedTo is the file a link event's destination now shares its data
+	// with.
+	LinkedTo string
+	// Reason names the filter that left out a skipped entry: max-depth,
+	// size, age or one-file-system.
+	Reason string
+	// Err is what failed, for error events.
+	Err error
+}
+
+// EventObserver is an Observer that is also told about every directory
+// created, file copied or linked, entry skipped and error, such as to keep
+// an audit log. Events are delivered from the goroutine calling Copy.
+type EventObserver interface {
+	Observer
+	Event(event Event)
+}
+
+// skipReporter sends the entries a filter leaves out to events as skip
+// events.
+func skipReporter(events EventObserver) func(path string, reason string, info fs.FileInfo) {
+	return func(path string, reason string, info fs.FileInfo) {
+		event := Event{Kind: EventSkip, Source: path, Reason: reason}
+		if !info.IsDir() {
+			event.Size = uint64(max(info.Size(), 0))
+		}
+		events.Event(event)
+	}
+}
+
+// pendingEvent is an Event for a step still in progress.
+type pendingEvent struct {
+	event   Event
+	start   time.Time
+	written uint64
+}
+
+// startEvent begins recording kind for op, if anything is listening. A copy
+// over an existing file is recorded as an overwrite.
+func (e *execution) startEvent(kind EventKind, op Operation) pendingEvent {
+	if e.events == nil {
+		return pendingEvent{}
+	}
+	if kind == EventCopy && e.opts.Force {
+		if _, err := e.backend.Stat(op.Destination); err == nil {
+			kind = EventOverwrite
+		}
+	}
+	return pendingEvent{
+		event:   Event{Kind: kind, Source: op.Source, Destination: op.Destination, Size: op.Size},
+		start:   time.Now(),
+		written: e.stats.BytesWritten,
+	}
+}
+
+// finishEvent delivers a pending event, as an error event if err is set.
+func (e *execution) finishEvent(pending pendingEvent, err error) {
+	if e.events == nil {
+		return
+	}
+	event := pending.event
+	event.Duration = time.Since(pending.start)
+	event.Written = e.stats.BytesWritten - pending.written
+	if err != nil {
+		event.Kind = EventError
+		event.Err = err
+	}
+	if e.transferred.Destination == event.Destination && event.Destination != "" {
+		event.SHA256 = hex.EncodeToString(e.transferred.SHA256[:])
+	}
+	e.events.Event(event)
+}
@@ -0,0 +1,25 @@
+package copier
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes for file without changing its length, so
+// the filesystem can lay it out contiguously and running out of space fails
+// before any data is written. Filesystems without fallocate are ignored.
+func preallocate(file io.Writer, size uint64) error {
+	osFile, ok := file.(*os.File)
+	if !ok || size == 0 {
+		return nil
+	}
+
+	err := unix.Fallocate(int(osFile.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, int64(size))
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package copier
+
+import "io"
+
+// preallocate is a no-op where fallocate is not available.
+func preallocate(io.Writer, uint64) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package copier
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Filter selects which entries of source directories and archives are
+// copied. Sources named directly are always copied. The zero Filter selects
+// everything.
+type Filter struct {
+	// MaxDepth is how many levels below each source directory are copied:
+	// 1 copies only the files directly inside it, creating the directories
+	// there empty. 0 means no limit (--max-depth).
+	MaxDepth int
+	// MinSize and MaxSize bound the size of copied files, inclusively. A
+	// MaxSize of 0 means no upper bound (--min-size, --max-size).
+	MinSize uint64
+	MaxSize uint64
+	// NewerThan and OlderThan bound the modification time of copied files.
+	// Zero times mean no bound (--newer-than, --older-than).
+	NewerThan time.Time
+	OlderThan time.Time
+	// OneFileSystem skips entries on a different filesystem from their
+	// source directory, such as mount points below it (-x).
+	OneFileSystem bool
+
+	// skipped, if set, is told about every entry left out and why.
+	skipped func(path string, reason string, info fs.FileInfo)
+}
+
+func (f Filter) validate() error {
+	if f.MaxDepth < 0 {
+		return fmt.Errorf("maximum depth must not be negative")
+	}
+	if f.MaxSize > 0 && f.MinSize > f.MaxSize {
+		return fmt.Errorf("minimum size %d is greater than maximum size %d", f.MinSize, f.MaxSize)
+	}
+	if !f.NewerThan.IsZero() && !f.OlderThan.IsZero() && !f.NewerThan.Before(f.OlderThan) {
+		return fmt.Errorf(
+			"no file can be newer than %s and older than %s",
+			f.NewerThan.Format(time.RFC3339),
+			f.OlderThan.Format(time.RFC3339),
+		)
+	}
+	return nil
+}
+
+// FilterCounts counts the entries a Filter left out of a plan, by reason. A
+// skipped directory counts once however much it holds, since it is never
+// read.
+type FilterCounts struct {
+	Depth      int
+	Size       int
+	Age        int
+	FileSystem int
+	// Bytes is the total size of the files skipped by size or age.
+	Bytes uint64
+}
+
+// Total returns the number of entries left out for any reason.
+func (c FilterCounts) Total() int {
+	return c.Depth + c.Size + c.Age + c.FileSystem
+}
+
+func (c *FilterCounts) merge(other FilterCounts) {
+	c.Depth += other.Depth
+	c.Size += other.Size
+	c.Age += other.Age
+	c.FileSystem += other.FileSystem
+	c.Bytes += other.Bytes
+}
+
+// excludes reports why f leaves out the entry described by info, found at
+// relativePath below its source directory, counting it, or "" if it is
+// kept. Directories are only checked against MaxDepth: the files inside them
+// are checked on their own.
+func (f Filter) excludes(relativePath string, separator string, info fs.FileInfo, counts *FilterCounts) string {
+	if f.MaxDepth > 0 && depth(relativePath, separator) > f.MaxDepth {
+		counts.Depth++
+		return "max-depth"
+	}
+	if info.IsDir() {
+		return ""
+	}
+
+	size := uint64(max(info.Size(), 0))
+	if size < f.MinSize || f.MaxSize > 0 && size > f.MaxSize {
+		counts.Size++
+		counts.Bytes += size
+		return "size"
+	}
+
+	modTime := info.ModTime()
+	if !f.NewerThan.IsZero() && !modTime.After(f.NewerThan) ||
+		!f.OlderThan.IsZero() && !modTime.Before(f.OlderThan) {
+		counts.Age++
+		counts.Bytes += size
+		return "age"
+	}
+	return ""
+}
+
+// stopsAt reports whether the directory at relativePath is at MaxDepth. It
+// is created, but not read, since it could only hold entries beyond it.
+func (f Filter) stopsAt(relativePath string, separator string) bool {
+	return f.MaxDepth > 0 && depth(relativePath, separator) == f.MaxDepth
+}
+
+// depth returns how many levels below its source directory relativePath is.
+func depth(relativePath string, separator string) int {
+	if relativePath == "." {
+		return 0
+	}
+	return strings.Count(relativePath, separator) + 1
+}
+
+// skip reports an entry left out for reason.
+func (f Filter) skip(path string, reason string, info fs.FileInfo) {
+	if f.skipped != nil {
+		f.skipped(path, reason, info)
+	}
+}
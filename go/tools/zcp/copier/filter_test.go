@@ -0,0 +1,191 @@
+package copier
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Truncate(time.Second)
+	sourceRoot := filepath.Join(t.TempDir(), "source")
+	files := []struct {
+		path string
+		size int
+		age  time.Duration
+	}{
+		{path: "small.txt", size: 10, age: time.Hour},
+		{path: "large.bin", size: 5000, age: time.Hour},
+		{path: "old.bin", size: 5000, age: 30 * 24 * time.Hour},
+		{path: "nested/deep.bin", size: 5000, age: time.Hour},
+		{path: "nested/deeper/deepest.bin", size: 5000, age: time.Hour},
+	}
+	for _, file := range files {
+		path := filepath.Join(sourceRoot, filepath.FromSlash(file.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, file.size), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+		modTime := now.Add(-file.age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("set source modtime: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		filter Filter
+		want   []string
+		counts FilterCounts
+	}{
+		{
+			name:   "no_filter",
+			filter: Filter{OneFileSystem: true},
+			want:   []string{"large.bin", "nested/deep.bin", "nested/deeper/deepest.bin", "old.bin", "small.txt"},
+		},
+		{
+			name:   "max_depth",
+			filter: Filter{MaxDepth: 2},
+			want:   []string{"large.bin", "nested/deep.bin", "old.bin", "small.txt"},
+		},
+		{
+			name:   "size_bounds",
+			filter: Filter{MinSize: 100, MaxSize: 4999},
+			counts: FilterCounts{Size: 5, Bytes: 20010},
+		},
+		{
+			name:   "min_size_and_age",
+			filter: Filter{MinSize: 100, NewerThan: now.Add(-7 * 24 * time.Hour)},
+			want:   []string{"large.bin", "nested/deep.bin", "nested/deeper/deepest.bin"},
+			counts: FilterCounts{Size: 1, Age: 1, Bytes: 5010},
+		},
+		{
+			name:   "older_than",
+			filter: Filter{OlderThan: now.Add(-24 * time.Hour), MaxDepth: 1},
+			want:   []string{"old.bin"},
+			counts: FilterCounts{Age: 2, Bytes: 5010},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			destinationRoot := filepath.Join(t.TempDir(), "dest")
+			plan, err := collectDirectoryOperations(sourceRoot, destinationRoot, testCase.filter)
+			if err != nil {
+				t.Fatalf("collect directory operations: %v", err)
+			}
+
+			var got []string
+			for _, op := range plan.Operations {
+				if op.Kind == OperationCopyFile {
+					relative, err := filepath.Rel(destinationRoot, op.Destination)
+					if err != nil {
+						t.Fatalf("relative destination: %v", err)
+					}
+					got = append(got, filepath.ToSlash(relative))
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, testCase.want) {
+				t.Fatalf("expected files %v, got %v", testCase.want, got)
+			}
+			if plan.Filtered != testCase.counts {
+				t.Fatalf("expected counts %+v, got %+v", testCase.counts, plan.Filtered)
+			}
+		})
+	}
+
+	t.Run("max_depth_creates_directories_at_the_boundary", func(t *testing.T) {
+		t.Parallel()
+
+		for maxDepth, want := range map[int][]string{
+			1: {".", "nested"},
+			2: {".", "nested", "nested/deeper"},
+		} {
+			destinationRoot := filepath.Join(t.TempDir(), "dest")
+			plan, err := collectDirectoryOperations(sourceRoot, destinationRoot, Filter{MaxDepth: maxDepth})
+			if err != nil {
+				t.Fatalf("collect directory operations: %v", err)
+			}
+
+			var got []string
+			for _, op := range plan.Operations {
+				relative, err := filepath.Rel(destinationRoot, op.Destination)
+				if err != nil {
+					t.Fatalf("relative destination: %v", err)
+				}
+				if depth(relative, string(filepath.Separator)) > maxDepth {
+					t.Fatalf("max depth %d: expected nothing below the boundary, got %q", maxDepth, relative)
+				}
+				if op.Kind == OperationCreateDirectory {
+					got = append(got, filepath.ToSlash(relative))
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("max depth %d: expected directories %v, got %v", maxDepth, want, got)
+			}
+			if plan.Filtered.Depth != 0 {
+				t.Fatalf("max depth %d: expected directories at the boundary not to be filtered, got %+v", maxDepth, plan.Filtered)
+			}
+		}
+	})
+
+	t.Run("rejects_empty_selections", func(t *testing.T) {
+		t.Parallel()
+
+		for _, filter := range []Filter{
+			{MaxDepth: -1},
+			{MinSize: 10, MaxSize: 5},
+			{NewerThan: now, OlderThan: now.Add(-time.Hour)},
+		} {
+			if err := filter.validate(); err == nil {
+				t.Fatalf("expected filter %+v to be rejected", filter)
+			}
+		}
+	})
+
+	t.Run("sources_named_directly_are_not_filtered", func(t *testing.T) {
+		t.Parallel()
+
+		source := filepath.Join(sourceRoot, "small.txt")
+		dest, err := statDestination(LocalBackend{}, filepath.Join(t.TempDir(), "small.txt"))
+		if err != nil {
+			t.Fatalf("stat destination: %v", err)
+		}
+		plan, err := planSources([]string{source}, dest, Options{Filter: Filter{MinSize: 100}})
+		if err != nil {
+			t.Fatalf("plan sources: %v", err)
+		}
+		if len(plan.Operations) != 1 || plan.Filtered.Total() != 0 {
+			t.Fatalf("expected the named file to be planned, got %+v", plan)
+		}
+	})
+
+	t.Run("counts_reach_the_plan", func(t *testing.T) {
+		t.Parallel()
+
+		opts := Options{Recursive: true, Filter: Filter{MaxSize: 100}}
+		plan, err := New(opts, nil).Plan(t.Context(), []string{sourceRoot}, filepath.Join(t.TempDir(), "dest"))
+		if err != nil {
+			t.Fatalf("plan: %v", err)
+		}
+		if plan.Files() != 1 || plan.Filtered.Size != 4 || plan.TotalBytes != 10 {
+			t.Fatalf("expected one small file planned and four filtered, got %+v", plan)
+		}
+
+		opts.Filter.MinSize = 200
+		if _, err := New(opts, nil).Plan(t.Context(), []string{sourceRoot}, t.TempDir()); err == nil ||
+			!strings.Contains(err.Error(), "greater than maximum size") {
+			t.Fatalf("expected an invalid filter error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+//go:build darwin || freebsd
+
+package copier
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// detectTargetFS reports the family of the filesystem containing name, or
+// TargetPOSIX for any other filesystem.
+func detectTargetFS(name string) (TargetFS, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(name, &stat); err != nil {
+		return TargetAuto, err
+	}
+	switch strings.TrimRight(string(stat.Fstypename[:]), "\x00") {
+	case "msdos", "msdosfs":
+		return TargetFAT, nil
+	case "exfat":
+		return TargetExFAT, nil
+	case "ntfs":
+		return TargetNTFS, nil
+	case "smbfs":
+		return TargetSMB, nil
+	default:
+		return TargetPOSIX, nil
+	}
+}
@@ -0,0 +1,31 @@
+package copier
+
+import "golang.org/x/sys/unix"
+
+// Magic numbers of the NTFS drivers, which x/sys does not define: ntfs-3g
+// shows up as FUSE and cannot be told apart.
+const (
+	ntfsMagic  = 0x5346544e
+	ntfs3Magic = 0x7366746e
+)
+
+// detectTargetFS reports the family of the filesystem containing name, or
+// TargetPOSIX for any other filesystem.
+func detectTargetFS(name string) (TargetFS, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(name, &stat); err != nil {
+		return TargetAuto, err
+	}
+	switch uint32(stat.Type) {
+	case unix.MSDOS_SUPER_MAGIC:
+		return TargetFAT, nil
+	case unix.EXFAT_SUPER_MAGIC:
+		return TargetExFAT, nil
+	case ntfsMagic, ntfs3Magic:
+		return TargetNTFS, nil
+	case unix.CIFS_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.SMB_SUPER_MAGIC:
+		return TargetSMB, nil
+	default:
+		return TargetPOSIX, nil
+	}
+}
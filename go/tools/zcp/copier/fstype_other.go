@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package copier
+
+import "errors"
+
+func detectTargetFS(string) (TargetFS, error) {
+	return TargetAuto, errors.ErrUnsupported
+}
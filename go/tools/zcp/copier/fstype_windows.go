@@ -0,0 +1,33 @@
+package copier
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// detectTargetFS reports the family of the volume containing name. Windows
+// applies the same name rules everywhere, so anything else counts as NTFS.
+func detectTargetFS(name string) (TargetFS, error) {
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return TargetAuto, err
+	}
+	volume := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(path, &volume[0], uint32(len(volume))); err != nil {
+		return TargetAuto, err
+	}
+
+	fileSystem := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumeInformation(&volume[0], nil, 0, nil, nil, nil, &fileSystem[0], uint32(len(fileSystem))); err != nil {
+		return TargetAuto, err
+	}
+	switch strings.ToUpper(windows.UTF16ToString(fileSystem)) {
+	case "FAT", "FAT32":
+		return TargetFAT, nil
+	case "EXFAT":
+		return TargetExFAT, nil
+	default:
+		return TargetNTFS, nil
+	}
+}
@@ -0,0 +1,167 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// FsyncMode controls how written data is flushed to stable storage.
+type FsyncMode string
+
+const (
+	// FsyncNone leaves flushing to the operating system.
+	FsyncNone FsyncMode = ""
+	// FsyncFile fsyncs each file, then its parent directory, as it is
+	// written.
+	FsyncFile FsyncMode = "file"
+	// FsyncEnd flushes the destination filesystem once, after everything has
+	// been written.
+	FsyncEnd FsyncMode = "end"
+)
+
+// ParseFsyncMode parses a --fsync value.
+func ParseFsyncMode(value string) (FsyncMode, error) {
+	switch value {
+	case "", "none":
+		return FsyncNone, nil
+	case string(FsyncFile), string(FsyncEnd):
+		return FsyncMode(value), nil
+	default:
+		return FsyncNone, fmt.Errorf("unsupported fsync mode %q (expected none, file or end)", value)
+	}
+}
+
+// canSync reports whether data written through backend can be flushed, so
+// --fsync is refused before anything is copied rather than after.
+func canSync(backend Backend) bool {
+	switch backend := backend.(type) {
+	case *SFTPBackend:
+		return backend.canSync()
+	case SyncBackend:
+		return true
+	}
+	return false
+}
+
+// mkdirAll creates name and any missing parents. When syncing, the entry of
+// every directory it creates is flushed into its parent too, up to the first
+// directory that already existed, so new directories survive a crash along
+// with the files written into them.
+func (e *execution) mkdirAll(name string, perm fs.FileMode) error {
+	if e.opts.Fsync == FsyncNone {
+		return e.backend.MkdirAll(name, perm)
+	}
+
+	var created []string
+	for directory := name; ; directory = filepath.Dir(directory) {
+		if _, err := e.backend.Stat(directory); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		created = append(created, directory)
+		if filepath.Dir(directory) == directory {
+			break
+		}
+	}
+	if err := e.backend.MkdirAll(name, perm); err != nil {
+		return err
+	}
+
+	if e.opts.Fsync == FsyncEnd {
+		e.created = append(e.created, created...)
+		return nil
+	}
+	backend, ok := e.backend.(SyncBackend)
+	if !ok {
+		return fmt.Errorf("sync directory %q: destination does not support fsync", name)
+	}
+	for _, directory := range created {
+		if err := backend.SyncDir(filepath.Dir(directory)); err != nil {
+			return fmt.Errorf("sync directory of %q: %w", directory, err)
+		}
+	}
+	return nil
+}
+
+// syncOpenFile flushes a destination file before it is closed, when syncing
+// every file.
+func (e *execution) syncOpenFile(file io.WriteCloser, name string) error {
+	if e.opts.Fsync != FsyncFile {
+		return nil
+	}
+
+	syncer, ok := file.(interface{ Sync() error })
+	if !ok {
+		return fmt.Errorf("sync destination file %q: destination does not support fsync", name)
+	}
+
+	e.observer.FlushStart()
+	defer e.observer.FlushDone()
+	if err := syncer.Sync(); err != nil {
+		return fmt.Errorf("sync destination file %q: %w", name, err)
+	}
+	return nil
+}
+
+// syncWrittenFile flushes the directory entry of a closed destination file, or
+// remembers the file for the final flush.
+func (e *execution) syncWrittenFile(name string) error {
+	switch e.opts.Fsync {
+	case FsyncFile:
+		backend, ok := e.backend.(SyncBackend)
+		if !ok {
+			return fmt.Errorf("sync directory of %q: destination does not support fsync", name)
+		}
+		if err := backend.SyncDir(filepath.Dir(name)); err != nil {
+			return fmt.Errorf("sync directory of %q: %w", name, err)
+		}
+	case FsyncEnd:
+		e.written = append(e.written, name)
+	}
+	return nil
+}
+
+// syncAll flushes everything written to the filesystem containing root, when
+// syncing at the end. Where a whole filesystem cannot be flushed, each written
+// file and its directory is flushed instead, along with the parent of every
+// directory created.
+func (e *execution) syncAll(root string) error {
+	if e.opts.Fsync != FsyncEnd || len(e.written) == 0 && len(e.created) == 0 {
+		return nil
+	}
+
+	backend, ok := e.backend.(SyncBackend)
+	if !ok {
+		return fmt.Errorf("sync destination %q: destination does not support fsync", root)
+	}
+
+	e.observer.FlushStart()
+	defer e.observer.FlushDone()
+
+	err := backend.SyncFS(root)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("sync destination %q: %w", root, err)
+	}
+
+	directories := make(map[string]bool)
+	for _, name := range e.written {
+		if err := backend.SyncFile(name); err != nil {
+			return fmt.Errorf("sync destination file %q: %w", name, err)
+		}
+		directories[filepath.Dir(name)] = true
+	}
+	for _, directory := range e.created {
+		directories[filepath.Dir(directory)] = true
+	}
+	for directory := range directories {
+		if err := backend.SyncDir(directory); err != nil {
+			return fmt.Errorf("sync directory %q: %w", directory, err)
+		}
+	}
+	return nil
+}
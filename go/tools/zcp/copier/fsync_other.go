@@ -0,0 +1,25 @@
+//go:build !unix
+
+package copier
+
+import "os"
+
+// syncFile needs write access, since Windows only flushes handles opened for
+// writing.
+func syncFile(name string) error {
+	file, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDirectory is a no-op where directories cannot be opened for syncing;
+// on Windows, NTFS journals directory updates itself.
+func syncDirectory(string) error {
+	return nil
+}
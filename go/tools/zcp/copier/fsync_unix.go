@@ -0,0 +1,27 @@
+//go:build unix
+
+package copier
+
+import "os"
+
+// syncFile opens name read-only, which is enough to fsync it here and works
+// for files whose mode no longer allows writing.
+func syncFile(name string) error {
+	return syncPath(name)
+}
+
+func syncDirectory(name string) error {
+	return syncPath(name)
+}
+
+func syncPath(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
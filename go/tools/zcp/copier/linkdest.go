@@ -0,0 +1,89 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// linkUnchanged marks the files in plan that are unchanged since the
+// snapshot in linkDest, which mirrors destination: a regular file at the
+// same relative path with the same size, modification time (to the second)
+// and mode. Marked files are hard-linked rather than copied, so their bytes
+// move from TotalBytes to LinkedBytes. Streams and files being compressed or
+// decompressed are always copied.
+func linkUnchanged(plan *Plan, linkDest string, destination string) error {
+	info, err := os.Stat(linkDest)
+	if err != nil {
+		return fmt.Errorf("stat link-dest directory %q: %w", linkDest, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("link-dest %q is not a directory", linkDest)
+	}
+
+	for i := range plan.Operations {
+		op := &plan.Operations[i]
+		if op.Kind != OperationCopyFile || op.stream != nil || op.compress != CompressionNone || op.decompress != CompressionNone {
+			continue
+		}
+		relative, err := filepath.Rel(destination, op.Destination)
+		if err != nil || relative == "." {
+			continue
+		}
+
+		previous := filepath.Join(linkDest, relative)
+		info, err := os.Lstat(previous)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stat link-dest file %q: %w", previous, err)
+		}
+		if !info.Mode().IsRegular() || uint64(info.Size()) != op.Size ||
+			info.ModTime().Unix() != op.ModTime.Unix() || info.Mode().Perm() != op.Mode.Perm() {
+			continue
+		}
+
+		op.LinkFrom = previous
+		plan.TotalBytes -= op.Size
+		plan.Linked++
+		plan.LinkedBytes += op.Size
+	}
+	return nil
+}
+
+// linkFromSnapshot hard-links op's destination to its unchanged file in the
+// link-dest snapshot.
+func (e *execution) linkFromSnapshot(op Operation) error {
+	if err := e.mkdirAll(filepath.Dir(op.Destination), 0o755); err != nil {
+		return fmt.Errorf("create destination parent for %q: %w", op.Destination, err)
+	}
+	if e.opts.Force {
+		if err := os.Remove(op.Destination); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove destination file %q: %w", op.Destination, err)
+		}
+	}
+
+	if err := os.Link(op.LinkFrom, op.Destination); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("destination file exists (use -f to overwrite): %q", op.Destination)
+		}
+		return fmt.Errorf("link %q to unchanged %q: %w", op.Destination, op.LinkFrom, err)
+	}
+
+	if e.opts.Hash {
+		sum, err := hashFile(op.Destination, NopObserver{})
+		if err != nil {
+			return err
+		}
+		fileHash := FileHash{Destination: op.Destination, Size: op.Size}
+		copy(fileHash.SHA256[:], sum)
+		e.recordHash(fileHash)
+		e.transferred = fileHash
+	}
+	e.linkedTo = op.LinkFrom
+	e.stats.Linked++
+	return e.syncWrittenFile(op.Destination)
+}
@@ -0,0 +1,97 @@
+package copier
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkDest(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	sourceRoot := filepath.Join(tempDir, "source")
+	files := map[string]string{
+		"same.txt":        strings.Repeat("s", 100),
+		"nested/same.txt": strings.Repeat("n", 200),
+		"touched.txt":     strings.Repeat("t", 300),
+		"mode.txt":        strings.Repeat("m", 400),
+		"new.txt":         strings.Repeat("w", 500),
+	}
+	for name, content := range files {
+		path := filepath.Join(sourceRoot, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+	}
+
+	previous := filepath.Join(tempDir, "previous")
+	opts := Options{Recursive: true, Preserve: true}
+	if _, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, previous); err != nil {
+		t.Fatalf("copy first snapshot: %v", err)
+	}
+	if err := os.Remove(filepath.Join(previous, "new.txt")); err != nil {
+		t.Fatalf("remove from first snapshot: %v", err)
+	}
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(sourceRoot, "touched.txt"), modTime, modTime); err != nil {
+		t.Fatalf("touch source file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(sourceRoot, "mode.txt"), 0o600); err != nil {
+		t.Fatalf("chmod source file: %v", err)
+	}
+
+	observer := &recordingObserver{}
+	opts.LinkDest = previous
+	opts.Hash = true
+	destinationRoot := filepath.Join(tempDir, "current")
+	result, err := New(opts, observer).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+	if err != nil {
+		t.Fatalf("copy with link-dest: %v", err)
+	}
+
+	linked := map[string]bool{}
+	for _, op := range result.Plan.Operations {
+		if op.LinkFrom != "" {
+			relative, err := filepath.Rel(previous, op.LinkFrom)
+			if err != nil {
+				t.Fatalf("relative link source: %v", err)
+			}
+			linked[filepath.ToSlash(relative)] = true
+		}
+	}
+	if len(linked) != 2 || !linked["same.txt"] || !linked["nested/same.txt"] {
+		t.Fatalf("expected only the unchanged files to be linked, got %v", linked)
+	}
+
+	// Progress only counts the bytes copied.
+	plan := result.Plan
+	if plan.Linked != 2 || plan.LinkedBytes != 300 || plan.TotalBytes != 1200 || observer.bytes != 1200 {
+		t.Fatalf("expected 300 bytes linked and 1200 copied, got plan %+v and %d bytes observed", plan, observer.bytes)
+	}
+	if result.Stats.Linked != 2 || len(result.Stats.Hashes) != len(files) {
+		t.Fatalf("expected two linked files and every file hashed, got %+v", result.Stats)
+	}
+
+	for name, content := range files {
+		current := filepath.Join(destinationRoot, filepath.FromSlash(name))
+		got, err := os.ReadFile(current)
+		if err != nil || string(got) != content {
+			t.Fatalf("unexpected contents of %q: %v", name, err)
+		}
+		currentInfo, err := os.Stat(current)
+		if err != nil {
+			t.Fatalf("stat current snapshot: %v", err)
+		}
+		previousInfo, err := os.Stat(filepath.Join(previous, filepath.FromSlash(name)))
+		if err == nil && os.SameFile(currentInfo, previousInfo) != linked[name] {
+			t.Fatalf("expected %q linked=%v", name, linked[name])
+		}
+	}
+}
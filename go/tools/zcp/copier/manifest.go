@@ -0,0 +1,262 @@
+package copier
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileHash is the SHA-256 digest of a file as it was written.
+type FileHash struct {
+	Destination string
+	Size        uint64
+	SHA256      [sha256.Size]byte
+}
+
+// recordHash adds the hash of a written file to the stats. A file copied
+// again, after its source changed, replaces the hash of the earlier attempt.
+func (e *execution) recordHash(hash FileHash) {
+	hashes := e.stats.Hashes
+	if len(hashes) > 0 && hashes[len(hashes)-1].Destination == hash.Destination {
+		hashes[len(hashes)-1] = hash
+		return
+	}
+	e.stats.Hashes = append(hashes, hash)
+}
+
+// ManifestFormat is how a checksum manifest is written.
+type ManifestFormat string
+
+const (
+	// ManifestSHA256Sum writes lines that sha256sum --check reads. It has
+	// no room for sizes.
+	ManifestSHA256Sum ManifestFormat = "sha256sum"
+	// ManifestJSON writes a JSON object listing every file with its size.
+	ManifestJSON ManifestFormat = "json"
+)
+
+// ParseManifestFormat parses a --manifest-format value.
+func ParseManifestFormat(value string) (ManifestFormat, error) {
+	switch ManifestFormat(value) {
+	case ManifestSHA256Sum, ManifestJSON:
+		return ManifestFormat(value), nil
+	default:
+		return "", fmt.Errorf("unsupported manifest format %q (expected sha256sum or json)", value)
+	}
+}
+
+// ManifestEntry is a file listed in a checksum manifest.
+type ManifestEntry struct {
+	// Path is slash-separated and relative to the manifest's directory.
+	Path string `json:"path"`
+	// Size is -1 when the manifest does not record it.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type jsonManifest struct {
+	Algorithm string          `json:"algorithm"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// NewManifest lists hashes relative to root. An empty root keeps the
+// destinations as they are, for entries named inside an archive.
+func NewManifest(hashes []FileHash, root string) ([]ManifestEntry, error) {
+	entries := make([]ManifestEntry, 0, len(hashes))
+	for _, hash := range hashes {
+		name := hash.Destination
+		if root != "" {
+			relative, err := filepath.Rel(root, hash.Destination)
+			if err != nil {
+				return nil, fmt.Errorf("name %q in manifest: %w", hash.Destination, err)
+			}
+			name = relative
+		}
+		entries = append(entries, ManifestEntry{
+			Path:   filepath.ToSlash(name),
+			Size:   int64(hash.Size),
+			SHA256: hex.EncodeToString(hash.SHA256[:]),
+		})
+	}
+	return entries, nil
+}
+
+// WriteManifest writes entries to writer in format.
+func WriteManifest(writer io.Writer, entries []ManifestEntry, format ManifestFormat) error {
+	if format == ManifestJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(jsonManifest{Algorithm: "sha256", Files: entries})
+	}
+
+	buffered := bufio.NewWriter(writer)
+	for _, entry := range entries {
+		// Like sha256sum, names with backslashes or line breaks are escaped
+		// and flagged by a leading backslash.
+		name, prefix := entry.Path, ""
+		if strings.ContainsAny(name, "\\\n\r") {
+			name = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(name)
+			prefix = `\`
+		}
+		if _, err := fmt.Fprintf(buffered, "%s%s  %s\n", prefix, entry.SHA256, name); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// ReadManifest reads a manifest written by WriteManifest, or by sha256sum,
+// telling the formats apart by content.
+func ReadManifest(reader io.Reader) ([]ManifestEntry, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		var manifest jsonManifest
+		if err := json.Unmarshal(trimmed, &manifest); err != nil {
+			return nil, fmt.Errorf("parse JSON manifest: %w", err)
+		}
+		if manifest.Algorithm != "sha256" {
+			return nil, fmt.Errorf("unsupported manifest algorithm %q (expected sha256)", manifest.Algorithm)
+		}
+		for _, entry := range manifest.Files {
+			if err := checkDigest(entry.SHA256); err != nil {
+				return nil, fmt.Errorf("manifest entry %q: %w", entry.Path, err)
+			}
+		}
+		return manifest.Files, nil
+	}
+
+	var entries []ManifestEntry
+	for number, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		escaped := strings.HasPrefix(line, `\`)
+		line = strings.TrimPrefix(line, `\`)
+		digest, name, ok := strings.Cut(line, " ")
+		// The second separator is a space for text mode or * for binary.
+		if !ok || checkDigest(digest) != nil || name == "" || name[0] != ' ' && name[0] != '*' {
+			return nil, fmt.Errorf("manifest line %d is not in sha256sum format", number+1)
+		}
+		name = name[1:]
+		if escaped {
+			name = unescapeManifestName(name)
+		}
+		entries = append(entries, ManifestEntry{Path: name, Size: -1, SHA256: strings.ToLower(digest)})
+	}
+	return entries, nil
+}
+
+func checkDigest(digest string) error {
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return errors.New("invalid SHA-256 digest")
+	}
+	return nil
+}
+
+func unescapeManifestName(name string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' || i+1 == len(name) {
+			unescaped.WriteByte(name[i])
+			continue
+		}
+		i++
+		switch name[i] {
+		case 'n':
+			unescaped.WriteByte('\n')
+		case 'r':
+			unescaped.WriteByte('\r')
+		default:
+			unescaped.WriteByte(name[i])
+		}
+	}
+	return unescaped.String()
+}
+
+// ManifestCheck is the outcome of Copier.CheckManifest.
+type ManifestCheck struct {
+	// Files is the number of files the manifest lists.
+	Files int
+	// Differences lists files that are missing, are not regular files, or
+	// whose size or contents do not match.
+	Differences []Difference
+	BytesHashed uint64
+}
+
+// CheckManifest verifies the files entries list inside directory, hashing
+// each one. The observer follows the hashing as if it were a copy of those
+// files.
+func (c *Copier) CheckManifest(ctx context.Context, entries []ManifestEntry, directory string) (ManifestCheck, error) {
+	check := ManifestCheck{Files: len(entries)}
+	var hashes Plan
+	expected := make(map[string]ManifestEntry, len(entries))
+
+	for _, entry := range entries {
+		cleaned := path.Clean(entry.Path)
+		if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return check, fmt.Errorf("manifest path %q is outside the directory being checked", entry.Path)
+		}
+		name := filepath.Join(directory, filepath.FromSlash(cleaned))
+
+		info, err := os.Stat(name)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			check.Differences = append(check.Differences, Difference{Kind: DifferenceMissing, Destination: name})
+		case err != nil:
+			return check, fmt.Errorf("stat %q: %w", name, err)
+		case !info.Mode().IsRegular():
+			check.Differences = append(check.Differences, Difference{Kind: DifferenceType, Destination: name})
+		case entry.Size >= 0 && info.Size() != entry.Size:
+			check.Differences = append(check.Differences, Difference{Kind: DifferenceSize, Destination: name})
+		default:
+			hashes.Operations = append(hashes.Operations, Operation{
+				Kind:        OperationCopyFile,
+				Source:      name,
+				Destination: name,
+				Mode:        info.Mode(),
+				ModTime:     info.ModTime(),
+				Size:        uint64(info.Size()),
+			})
+			hashes.TotalBytes += uint64(info.Size())
+			expected[name] = entry
+		}
+	}
+
+	c.observer.PlanReady(hashes)
+	for _, op := range hashes.Operations {
+		if err := ctx.Err(); err != nil {
+			return check, err
+		}
+
+		c.observer.FileStart(op)
+		sum, err := hashFile(op.Source, c.observer)
+		if err != nil {
+			c.observer.Error(op, err)
+			return check, err
+		}
+		check.BytesHashed += op.Size
+
+		if !strings.EqualFold(hex.EncodeToString(sum), expected[op.Source].SHA256) {
+			check.Differences = append(check.Differences, Difference{Kind: DifferenceContent, Destination: op.Source})
+		}
+		c.observer.FileDone(op)
+	}
+	return check, nil
+}
@@ -0,0 +1,175 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("hashes_files_while_copying", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceRoot := filepath.Join(tempDir, "source")
+		if err := os.MkdirAll(filepath.Join(sourceRoot, "nested"), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		for name, content := range map[string][]byte{
+			"a.txt":        []byte("alpha"),
+			"nested/b.bin": bytes.Repeat([]byte("b"), 100000),
+		} {
+			if err := os.WriteFile(filepath.Join(sourceRoot, filepath.FromSlash(name)), content, 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		destinationRoot := filepath.Join(tempDir, "dest")
+		for _, compress := range []Compression{CompressionNone, CompressionGzip} {
+			destination := filepath.Join(destinationRoot, string(compress)+"copy")
+			opts := Options{Recursive: true, Hash: true, BufferSize: 4096, Compress: compress}
+			result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destination)
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+
+			if len(result.Stats.Hashes) != 2 {
+				t.Fatalf("expected two hashes, got %d", len(result.Stats.Hashes))
+			}
+			// Hashes are of the files as written, compressed or not.
+			for _, hash := range result.Stats.Hashes {
+				content, err := os.ReadFile(hash.Destination)
+				if err != nil {
+					t.Fatalf("read destination file: %v", err)
+				}
+				if sha256.Sum256(content) != hash.SHA256 || uint64(len(content)) != hash.Size {
+					t.Fatalf("hash of %q does not match the written file", hash.Destination)
+				}
+			}
+
+			entries, err := NewManifest(result.Stats.Hashes, destination)
+			if err != nil {
+				t.Fatalf("new manifest: %v", err)
+			}
+			check, err := New(Options{}, nil).CheckManifest(context.Background(), entries, destination)
+			if err != nil || len(check.Differences) != 0 || check.Files != 2 {
+				t.Fatalf("expected the copy to match its manifest, got %+v, %v", check, err)
+			}
+		}
+	})
+
+	t.Run("round_trips_both_formats", func(t *testing.T) {
+		t.Parallel()
+
+		digest := hex.EncodeToString(bytes.Repeat([]byte{0xab}, sha256.Size))
+		entries := []ManifestEntry{
+			{Path: "plain/file.txt", Size: 3, SHA256: digest},
+			{Path: "odd\nname\\here", Size: 0, SHA256: digest},
+		}
+
+		for _, format := range []ManifestFormat{ManifestSHA256Sum, ManifestJSON} {
+			var buffer bytes.Buffer
+			if err := WriteManifest(&buffer, entries, format); err != nil {
+				t.Fatalf("write %s manifest: %v", format, err)
+			}
+			got, err := ReadManifest(&buffer)
+			if err != nil {
+				t.Fatalf("read %s manifest: %v", format, err)
+			}
+
+			want := slices.Clone(entries)
+			if format == ManifestSHA256Sum {
+				for i := range want {
+					want[i].Size = -1
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("%s manifest: expected %+v, got %+v", format, want, got)
+			}
+		}
+
+		var buffer bytes.Buffer
+		if err := WriteManifest(&buffer, entries[1:], ManifestSHA256Sum); err != nil {
+			t.Fatalf("write manifest: %v", err)
+		}
+		if want := `\` + digest + `  odd\nname\\here` + "\n"; buffer.String() != want {
+			t.Fatalf("expected sha256sum escaping %q, got %q", want, buffer.String())
+		}
+	})
+
+	t.Run("reads_sha256sum_output", func(t *testing.T) {
+		t.Parallel()
+
+		digest := strings.Repeat("0F", sha256.Size)
+		got, err := ReadManifest(strings.NewReader(digest + " *binary.bin\r\n\n" + digest + "  text.txt\n"))
+		if err != nil {
+			t.Fatalf("read manifest: %v", err)
+		}
+		if len(got) != 2 || got[0].Path != "binary.bin" || got[1].Path != "text.txt" || got[0].SHA256 != strings.ToLower(digest) {
+			t.Fatalf("unexpected entries %+v", got)
+		}
+
+		for _, content := range []string{"not a manifest\n", digest + "\n", `{"algorithm": "md5", "files": []}`} {
+			if _, err := ReadManifest(strings.NewReader(content)); err == nil {
+				t.Fatalf("expected %q to be rejected", content)
+			}
+		}
+	})
+
+	t.Run("check_reports_mismatches", func(t *testing.T) {
+		t.Parallel()
+
+		directory := t.TempDir()
+		for name, content := range map[string]string{"same.txt": "same", "changed.txt": "CHANGED", "resized.txt": "longer"} {
+			if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+		}
+		if err := os.Mkdir(filepath.Join(directory, "dir"), 0o755); err != nil {
+			t.Fatalf("create directory: %v", err)
+		}
+
+		digest := func(content string) string {
+			sum := sha256.Sum256([]byte(content))
+			return hex.EncodeToString(sum[:])
+		}
+		entries := []ManifestEntry{
+			{Path: "same.txt", Size: 4, SHA256: digest("same")},
+			{Path: "changed.txt", Size: -1, SHA256: digest("changed")},
+			{Path: "resized.txt", Size: 5, SHA256: digest("short")},
+			{Path: "missing.txt", Size: 1, SHA256: digest("m")},
+			{Path: "dir", Size: -1, SHA256: digest("d")},
+		}
+
+		observer := &recordingObserver{}
+		check, err := New(Options{}, observer).CheckManifest(context.Background(), entries, directory)
+		if err != nil {
+			t.Fatalf("check manifest: %v", err)
+		}
+
+		var got []string
+		for _, difference := range check.Differences {
+			got = append(got, string(difference.Kind)+" "+filepath.Base(difference.Destination))
+		}
+		want := []string{"size resized.txt", "missing missing.txt", "type dir", "content changed.txt"}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		if check.BytesHashed != 11 || observer.bytes != 11 {
+			t.Fatalf("expected 11 bytes hashed and observed, got %d and %d", check.BytesHashed, observer.bytes)
+		}
+
+		entries = []ManifestEntry{{Path: "../outside.txt", Size: -1, SHA256: digest("x")}}
+		if _, err := New(Options{}, nil).CheckManifest(context.Background(), entries, directory); err == nil {
+			t.Fatalf("expected a path outside the directory to be rejected")
+		}
+	})
+}
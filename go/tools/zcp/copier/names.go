@@ -0,0 +1,304 @@
+package copier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// TargetFS is a family of filesystems whose file name rules a copy follows.
+type TargetFS string
+
+const (
+	// TargetAuto detects the destination filesystem, on the local
+	// filesystem only.
+	TargetAuto TargetFS = ""
+	// TargetPOSIX accepts every name, skipping the checks.
+	TargetPOSIX TargetFS = "posix"
+	// TargetFAT, TargetExFAT, TargetNTFS and TargetSMB share the rules
+	// Windows applies to names: no <>:"\|?* or control characters, no
+	// trailing dots or spaces, no device names such as CON or LPT1, at most
+	// 255 UTF-16 units, and no two names differing only in case.
+	TargetFAT   TargetFS = "fat"
+	TargetExFAT TargetFS = "exfat"
+	TargetNTFS  TargetFS = "ntfs"
+	TargetSMB   TargetFS = "smb"
+)
+
+// ParseTargetFS parses a --target-fs value.
+func ParseTargetFS(value string) (TargetFS, error) {
+	switch value {
+	case "", "auto":
+		return TargetAuto, nil
+	case "vfat":
+		return TargetFAT, nil
+	case string(TargetPOSIX), string(TargetFAT), string(TargetExFAT), string(TargetNTFS), string(TargetSMB):
+		return TargetFS(value), nil
+	default:
+		return TargetAuto, fmt.Errorf("unsupported target filesystem %q (expected auto, fat, exfat, ntfs, smb or posix)", value)
+	}
+}
+
+// NameProblemKind says why a name cannot be created on a target filesystem.
+type NameProblemKind string
+
+const (
+	NameInvalidCharacter NameProblemKind = "character"
+	NameTrailingDotSpace NameProblemKind = "trailing"
+	NameReserved         NameProblemKind = "reserved"
+	NameTooLong          NameProblemKind = "length"
+	// NameCaseCollision marks a name differing only in case from another
+	// one in the copy, which the target would treat as the same file.
+	NameCaseCollision NameProblemKind = "case"
+)
+
+// NameProblem is a destination path the target filesystem cannot hold.
+type NameProblem struct {
+	Kind        NameProblemKind
+	Destination string
+	// Other is the path Destination collides with, for case collisions.
+	Other string
+}
+
+// NameError reports every name a copy would fail to create on its target
+// filesystem. It is returned before anything is written.
+type NameError struct {
+	Target   TargetFS
+	Problems []NameProblem
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("%d name(s) cannot be created on %s (use --sanitize to rename them)", len(e.Problems), e.Target)
+}
+
+// RenamedPath records a path renamed by Options.Sanitize. Both paths are
+// slash-separated and relative to the destination directory, and Original
+// keeps the sanitized names of its parent directories, so applying the
+// renames in reverse order restores the original tree.
+type RenamedPath struct {
+	Original  string `json:"original"`
+	Sanitized string `json:"sanitized"`
+}
+
+// checkTargetNames finds the names in plan that target cannot hold below root, or
+// with sanitize renames them, recording the renames in plan.Renamed.
+// Directories are checked before their contents, so a renamed directory's
+// contents move with it.
+func checkTargetNames(plan *Plan, root string, target TargetFS, sanitize bool) error {
+	// renamed maps each original relative path seen to its final one, and
+	// taken maps case-folded final paths to the original they belong to.
+	renamed := make(map[string]string)
+	taken := make(map[string]string)
+	var problems []NameProblem
+
+	for i := range plan.Operations {
+		op := &plan.Operations[i]
+		relative, err := filepath.Rel(root, op.Destination)
+		if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		parent := ""
+		original := ""
+		for _, name := range strings.Split(filepath.ToSlash(relative), "/") {
+			original = path.Join(original, name)
+			if final, ok := renamed[original]; ok {
+				parent = final
+				continue
+			}
+
+			final := path.Join(parent, name)
+			if sanitize {
+				final = path.Join(parent, sanitizeName(name))
+				for n := 2; taken[foldName(final)] != "" && taken[foldName(final)] != original; n++ {
+					final = path.Join(parent, numberedName(sanitizeName(name), n))
+				}
+				if final != path.Join(parent, name) {
+					plan.Renamed = append(plan.Renamed, RenamedPath{Original: path.Join(parent, name), Sanitized: final})
+				}
+			} else {
+				destination := filepath.Join(root, filepath.FromSlash(final))
+				if kind := nameProblem(name); kind != "" {
+					problems = append(problems, NameProblem{Kind: kind, Destination: destination})
+				}
+				if other := taken[foldName(final)]; other != "" && other != original {
+					problems = append(problems, NameProblem{
+						Kind:        NameCaseCollision,
+						Destination: destination,
+						Other:       filepath.Join(root, filepath.FromSlash(renamed[other])),
+					})
+				}
+			}
+
+			renamed[original] = final
+			if taken[foldName(final)] == "" {
+				taken[foldName(final)] = original
+			}
+			parent = final
+		}
+		op.Destination = filepath.Join(root, filepath.FromSlash(parent))
+	}
+
+	if len(problems) > 0 {
+		return &NameError{Target: target, Problems: problems}
+	}
+	return nil
+}
+
+func foldName(name string) string {
+	return strings.ToUpper(name)
+}
+
+const invalidNameCharacters = `<>:"\|?*`
+
+// reservedNames are device names Windows refuses as the stem of any name.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// maxNameLength is the longest name, in UTF-16 units, the targets allow.
+const maxNameLength = 255
+
+func nameProblem(name string) NameProblemKind {
+	switch {
+	case strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 || strings.ContainsRune(invalidNameCharacters, r) }):
+		return NameInvalidCharacter
+	case strings.HasSuffix(name, ".") || strings.HasSuffix(name, " "):
+		return NameTrailingDotSpace
+	case isReservedName(name):
+		return NameReserved
+	case nameLength(name) > maxNameLength:
+		return NameTooLong
+	default:
+		return ""
+	}
+}
+
+func isReservedName(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	return reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))]
+}
+
+func nameLength(name string) int {
+	return len(utf16.Encode([]rune(name)))
+}
+
+// sanitizeName rewrites name so every target can hold it, swapping invalid
+// characters for look-alikes: full-width forms for <>:"\|?* and for a
+// trailing dot, and control pictures for control characters and a trailing
+// space. Device names gain an underscore, and names too long are shortened
+// with a hash of the original.
+func sanitizeName(name string) string {
+	var sanitized strings.Builder
+	for _, r := range name {
+		switch {
+		case r < 0x20:
+			sanitized.WriteRune(0x2400 + r)
+		case strings.ContainsRune(invalidNameCharacters, r):
+			// The full-width forms are 0xFEE0 above their ASCII ones.
+			sanitized.WriteRune(r + 0xFEE0)
+		default:
+			sanitized.WriteRune(r)
+		}
+	}
+
+	result := sanitized.String()
+	trimmed := strings.TrimRight(result, ". ")
+	if trimmed != result {
+		tail := strings.NewReplacer(".", "．", " ", "␠").Replace(result[len(trimmed):])
+		result = trimmed + tail
+	}
+
+	if isReservedName(result) {
+		stem, extension, found := strings.Cut(result, ".")
+		result = stem + "_"
+		if found {
+			result += "." + extension
+		}
+	}
+
+	if nameLength(result) > maxNameLength {
+		sum := sha256.Sum256([]byte(name))
+		suffix := "~" + hex.EncodeToString(sum[:4])
+		extension := path.Ext(result)
+		if nameLength(extension) > 16 {
+			extension = ""
+		}
+		stem := []rune(strings.TrimSuffix(result, extension))
+		for nameLength(string(stem))+len(suffix)+nameLength(extension) > maxNameLength {
+			stem = stem[:len(stem)-1]
+		}
+		result = string(stem) + suffix + extension
+	}
+	return result
+}
+
+// numberedName tells a name colliding with another apart by adding ~n
+// before its extension.
+func numberedName(name string, n int) string {
+	extension := path.Ext(name)
+	if extension == name {
+		extension = ""
+	}
+	return fmt.Sprintf("%s~%d%s", strings.TrimSuffix(name, extension), n, extension)
+}
+
+type nameMap struct {
+	Renamed []RenamedPath `json:"renamed"`
+}
+
+// WriteNameMap writes the renames of a sanitized copy as JSON.
+func WriteNameMap(writer io.Writer, renamed []RenamedPath) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(nameMap{Renamed: renamed})
+}
+
+// ReadNameMap reads renames written by WriteNameMap.
+func ReadNameMap(reader io.Reader) ([]RenamedPath, error) {
+	var names nameMap
+	if err := json.NewDecoder(reader).Decode(&names); err != nil {
+		return nil, fmt.Errorf("parse name map: %w", err)
+	}
+	return names.Renamed, nil
+}
+
+// RestoreNames undoes the renames of a sanitized copy inside directory,
+// once it is back on a filesystem that can hold the original names. Renamed
+// paths that no longer exist are skipped and returned.
+func RestoreNames(renamed []RenamedPath, directory string) ([]string, error) {
+	var missing []string
+	for i := len(renamed) - 1; i >= 0; i-- {
+		rename := renamed[i]
+		if path.Dir(rename.Original) != path.Dir(rename.Sanitized) || !filepath.IsLocal(filepath.FromSlash(rename.Sanitized)) ||
+			!filepath.IsLocal(filepath.FromSlash(rename.Original)) {
+			return missing, fmt.Errorf("invalid name map entry %q -> %q", rename.Original, rename.Sanitized)
+		}
+
+		from := filepath.Join(directory, filepath.FromSlash(rename.Sanitized))
+		to := filepath.Join(directory, filepath.FromSlash(rename.Original))
+		if _, err := os.Lstat(from); errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, from)
+			continue
+		}
+		if _, err := os.Lstat(to); err == nil {
+			return missing, fmt.Errorf("restore %q: %q already exists", from, to)
+		}
+		if err := os.Rename(from, to); err != nil {
+			return missing, fmt.Errorf("restore %q: %w", from, err)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,180 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	t.Parallel()
+
+	sourceFiles := map[string]string{
+		"ok.txt":           "ok",
+		"time 12:00?.txt":  "colon",
+		"trailing.":        "dot",
+		"...":              "dots",
+		"..foo.":           "dotted",
+		"CON.txt":          "device",
+		"docs/Readme.md":   "upper",
+		"docs/README.md":   "lower",
+		"Photos/a.jpg":     "a",
+		"photos/b.jpg":     "b",
+		"photos/nested:/c": "c",
+	}
+	writeSource := func(t *testing.T) string {
+		t.Helper()
+
+		if runtime.GOOS == "windows" {
+			t.Skip("Windows cannot create the source names")
+		}
+		sourceRoot := filepath.Join(t.TempDir(), "source")
+		for name, content := range sourceFiles {
+			path := filepath.Join(sourceRoot, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("create source directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+		return sourceRoot
+	}
+
+	t.Run("reports_every_problem_before_copying", func(t *testing.T) {
+		t.Parallel()
+
+		sourceRoot := writeSource(t)
+		destinationRoot := filepath.Join(t.TempDir(), "dest")
+		opts := Options{Recursive: true, TargetFS: TargetExFAT}
+		_, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+
+		var nameErr *NameError
+		if !errors.As(err, &nameErr) {
+			t.Fatalf("expected a NameError, got %v", err)
+		}
+		var got []string
+		for _, problem := range nameErr.Problems {
+			relative, err := filepath.Rel(destinationRoot, problem.Destination)
+			if err != nil {
+				t.Fatalf("relative destination: %v", err)
+			}
+			got = append(got, string(problem.Kind)+" "+filepath.ToSlash(relative))
+		}
+		slices.Sort(got)
+		want := []string{
+			"case docs/Readme.md",
+			"case photos",
+			"character photos/nested:",
+			"character time 12:00?.txt",
+			"reserved CON.txt",
+			"trailing ...",
+			"trailing ..foo.",
+			"trailing trailing.",
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("expected problems %v, got %v", want, got)
+		}
+		if _, err := os.Stat(destinationRoot); !os.IsNotExist(err) {
+			t.Fatalf("expected nothing to be written, got %v", err)
+		}
+	})
+
+	t.Run("sanitizes_and_restores_names", func(t *testing.T) {
+		t.Parallel()
+
+		sourceRoot := writeSource(t)
+		destinationRoot := filepath.Join(t.TempDir(), "dest")
+		opts := Options{Recursive: true, TargetFS: TargetSMB, Sanitize: true}
+		result, err := New(opts, nil).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		for name, content := range map[string]string{
+			"time 12：00？.txt":    "colon",
+			"trailing．":          "dot",
+			"．．．":                "dots",
+			"..foo．":             "dotted",
+			"CON_.txt":           "device",
+			"docs/Readme~2.md":   "upper",
+			"photos~2/b.jpg":     "b",
+			"photos~2/nested：/c": "c",
+		} {
+			got, err := os.ReadFile(filepath.Join(destinationRoot, filepath.FromSlash(name)))
+			if err != nil || string(got) != content {
+				t.Fatalf("expected %q to hold %q, got %q, %v", name, content, got, err)
+			}
+		}
+
+		var buffer bytes.Buffer
+		if err := WriteNameMap(&buffer, result.Plan.Renamed); err != nil {
+			t.Fatalf("write name map: %v", err)
+		}
+		renamed, err := ReadNameMap(&buffer)
+		if err != nil || !slices.Equal(renamed, result.Plan.Renamed) {
+			t.Fatalf("expected the name map to round-trip, got %v, %v", renamed, err)
+		}
+
+		missing, err := RestoreNames(renamed, destinationRoot)
+		if err != nil || len(missing) != 0 {
+			t.Fatalf("restore names: %v, missing %v", err, missing)
+		}
+		comparison, err := New(Options{Checksum: true}, nil).Compare(context.Background(), []string{sourceRoot}, destinationRoot)
+		if err != nil {
+			t.Fatalf("compare: %v", err)
+		}
+		for _, difference := range comparison.Differences {
+			if difference.Kind != DifferenceModTime {
+				t.Fatalf("expected the restored tree to match its source, got %+v", comparison.Differences)
+			}
+		}
+	})
+
+	t.Run("sanitize_name", func(t *testing.T) {
+		t.Parallel()
+
+		for name, want := range map[string]string{
+			"plain.txt":     "plain.txt",
+			`a<b>c"d\e|f*g`: "a＜b＞c＂d＼e｜f＊g",
+			"tab\there":     "tab␉here",
+			"dots.. ":       "dots．．␠",
+			"...":           "．．．",
+			"..foo.":        "..foo．",
+			"lpt1":          "lpt1_",
+			"Aux.tar.gz":    "Aux_.tar.gz",
+			"console.log":   "console.log",
+		} {
+			if got := sanitizeName(name); got != want {
+				t.Fatalf("sanitizeName(%q) = %q, want %q", name, got, want)
+			}
+			if problem := nameProblem(sanitizeName(name)); problem != "" {
+				t.Fatalf("sanitized %q still has a %s problem", name, problem)
+			}
+		}
+
+		long := sanitizeName(strings.Repeat("é", 300) + ".txt")
+		if nameLength(long) > maxNameLength || !strings.HasSuffix(long, ".txt") || nameProblem(long) != "" {
+			t.Fatalf("expected a long name shortened to %d units, got %d: %q", maxNameLength, nameLength(long), long)
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		t.Parallel()
+
+		for value, want := range map[string]TargetFS{"": TargetAuto, "auto": TargetAuto, "vfat": TargetFAT, "exfat": TargetExFAT, "posix": TargetPOSIX} {
+			if got, err := ParseTargetFS(value); err != nil || got != want {
+				t.Fatalf("ParseTargetFS(%q) = %q, %v; want %q", value, got, err, want)
+			}
+		}
+		if _, err := ParseTargetFS("hfs"); err == nil {
+			t.Fatalf("expected an unknown filesystem to be rejected")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package copier
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Order selects the order files are copied in, which matters on spinning
+// disks where reading in directory order seeks all over the platter.
+type Order string
+
+const (
+	// OrderWalk copies files in the lexical order directories are walked.
+	OrderWalk Order = ""
+	// OrderInode copies files by inode number, which on most filesystems
+	// follows where their metadata, and often their data, was allocated.
+	OrderInode Order = "inode"
+	// OrderPhysical copies files by the disk offset of their first extent,
+	// found with FIEMAP on Linux, falling back to OrderInode elsewhere.
+	OrderPhysical Order = "physical"
+	// OrderSizeAsc and OrderSizeDesc copy the smallest or largest files
+	// first.
+	OrderSizeAsc  Order = "size-asc"
+	OrderSizeDesc Order = "size-desc"
+)
+
+// ParseOrder parses an --order value. The empty string is OrderWalk.
+func ParseOrder(value string) (Order, error) {
+	switch value {
+	case "", "walk":
+		return OrderWalk, nil
+	case string(OrderInode), string(OrderPhysical), string(OrderSizeAsc), string(OrderSizeDesc):
+		return Order(value), nil
+	default:
+		return "", fmt.Errorf("unsupported order %q (expected walk, inode, physical, size-asc or size-desc)", value)
+	}
+}
+
+// orderKey locates a file on disk. Files on different devices sort apart, so
+// each device is read front to back.
+type orderKey struct {
+	device   uint64
+	position uint64
+}
+
+// orderPlan reorders the files in plan. Directories move ahead of every file
+// in their walk order, so each exists before anything is copied into it and
+// parents are still created before their children. Files that cannot be
+// located, such as standard input, sort first.
+func orderPlan(plan []Operation, order Order) {
+	if order == OrderWalk {
+		return
+	}
+
+	positions := make([]orderKey, len(plan))
+	if order == OrderPhysical && !locatePhysical(plan, positions) {
+		order = OrderInode
+	}
+	if order == OrderInode {
+		for i, op := range plan {
+			if op.info == nil {
+				continue
+			}
+			device, _ := deviceOf(op.info)
+			inode, _ := inodeOf(op.info)
+			positions[i] = orderKey{device: device, position: inode}
+		}
+	}
+
+	indices := make([]int, len(plan))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	slices.SortStableFunc(indices, func(a, b int) int {
+		opA, opB := plan[a], plan[b]
+		if opA.Kind != opB.Kind {
+			return cmp.Compare(kindRank(opA.Kind), kindRank(opB.Kind))
+		}
+		if opA.Kind == OperationCreateDirectory {
+			return 0
+		}
+
+		switch order {
+		case OrderSizeAsc:
+			return cmp.Compare(opA.Size, opB.Size)
+		case OrderSizeDesc:
+			return cmp.Compare(opB.Size, opA.Size)
+		default:
+			if c := cmp.Compare(positions[a].device, positions[b].device); c != 0 {
+				return c
+			}
+			return cmp.Compare(positions[a].position, positions[b].position)
+		}
+	})
+
+	ordered := make([]Operation, len(plan))
+	for i, index := range indices {
+		ordered[i] = plan[index]
+	}
+	copy(plan, ordered)
+}
+
+func kindRank(kind OperationKind) int {
+	if kind == OperationCreateDirectory {
+		return 0
+	}
+	return 1
+}
+
+// locatePhysical records the physical offset of every local file in plan
+// into positions. It returns false if the filesystem cannot report offsets.
+func locatePhysical(plan []Operation, positions []orderKey) bool {
+	for i, op := range plan {
+		if op.Kind != OperationCopyFile || op.info == nil {
+			continue
+		}
+
+		offset, err := physicalOffset(op.Source)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return false
+		}
+		// Files that cannot be opened fail with a clearer error when they
+		// are copied.
+		if err != nil {
+			continue
+		}
+		device, _ := deviceOf(op.info)
+		positions[i] = orderKey{device: device, position: offset}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestOrder(t *testing.T) {
+	t.Parallel()
+
+	sourceRoot := filepath.Join(t.TempDir(), "source")
+	// Creating the files in reverse makes walk order differ from creation
+	// order, which is what inodes and extents usually follow.
+	files := []struct {
+		path string
+		size int
+	}{
+		{path: "four.bin", size: 400},
+		{path: "b/three.bin", size: 200},
+		{path: "a/two.bin", size: 100},
+		{path: "a/one.bin", size: 300},
+	}
+	for _, file := range files {
+		path := filepath.Join(sourceRoot, filepath.FromSlash(file.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create source directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, file.size), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		order Order
+		key   func(t *testing.T, op Operation) uint64
+	}{
+		{order: OrderSizeAsc, key: func(_ *testing.T, op Operation) uint64 { return op.Size }},
+		{order: OrderSizeDesc, key: func(_ *testing.T, op Operation) uint64 { return ^op.Size }},
+		{order: OrderInode, key: inodeKey},
+		{order: OrderPhysical, key: func(t *testing.T, op Operation) uint64 {
+			offset, err := physicalOffset(op.Source)
+			if errors.Is(err, errors.ErrUnsupported) {
+				return inodeKey(t, op)
+			}
+			if err != nil {
+				t.Fatalf("physical offset of %q: %v", op.Source, err)
+			}
+			return offset
+		}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.order), func(t *testing.T) {
+			t.Parallel()
+
+			opts := Options{Recursive: true, Order: testCase.order}
+			plan, err := New(opts, nil).Plan(context.Background(), []string{sourceRoot}, filepath.Join(t.TempDir(), "dest"))
+			if err != nil {
+				t.Fatalf("plan: %v", err)
+			}
+
+			var directories []string
+			var keys []uint64
+			for i, op := range plan.Operations {
+				if op.Kind == OperationCreateDirectory {
+					if i != len(directories) {
+						t.Fatalf("expected directories before files, got %q at %d", op.Source, i)
+					}
+					directories = append(directories, filepath.Base(op.Source))
+					continue
+				}
+				keys = append(keys, testCase.key(t, op))
+			}
+			if want := []string{"source", "a", "b"}; !slices.Equal(directories, want) {
+				t.Fatalf("expected directories in walk order %v, got %v", want, directories)
+			}
+			if len(keys) != 4 || !slices.IsSorted(keys) {
+				t.Fatalf("expected four files in %s order, got keys %v", testCase.order, keys)
+			}
+		})
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		t.Parallel()
+
+		for value, want := range map[string]Order{"": OrderWalk, "walk": OrderWalk, "physical": OrderPhysical} {
+			if got, err := ParseOrder(value); err != nil || got != want {
+				t.Fatalf("ParseOrder(%q) = %q, %v; want %q", value, got, err, want)
+			}
+		}
+		if _, err := ParseOrder("random"); err == nil {
+			t.Fatalf("expected an unknown order to be rejected")
+		}
+	})
+}
+
+func inodeKey(t *testing.T, op Operation) uint64 {
+	t.Helper()
+
+	inode, ok := inodeOf(op.info)
+	if !ok {
+		t.Skip("inode numbers are not available on this platform")
+	}
+	return inode
+}
+
+// BenchmarkOrder copies a tree of files created in a different order from
+// their names with each --order, dropping the sources from the page cache
+// before every copy so reads come from the disk. Point ZCP_BENCH_DIR at a
+// directory on a spinning disk to see the effect of seeking:
+//
+//	ZCP_BENCH_DIR=/mnt/hdd/tmp go test -run '^$' -bench Order ./copier
+func BenchmarkOrder(b *testing.B) {
+	parent := os.Getenv("ZCP_BENCH_DIR")
+	if parent == "" {
+		parent = b.TempDir()
+	}
+	sourceDir, err := os.MkdirTemp(parent, "zcp-order-")
+	if err != nil {
+		b.Fatalf("create source directory: %v", err)
+	}
+	b.Cleanup(func() { os.RemoveAll(sourceDir) })
+
+	// 2048 files of 16 KiB to 512 KiB across 32 directories, written in a
+	// random order so each directory's files are scattered on disk.
+	random := rand.New(rand.NewPCG(1, 2))
+	var paths []string
+	var total int64
+	for i := range 2048 {
+		paths = append(paths, filepath.Join(sourceDir, fmt.Sprintf("dir-%02d", i%32), fmt.Sprintf("file-%04d.bin", i)))
+	}
+	random.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+	for i, path := range paths {
+		size := 16 * 1024 << random.IntN(6)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			b.Fatalf("create source directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte{byte(i)}, size), 0o644); err != nil {
+			b.Fatalf("write source file: %v", err)
+		}
+		total += int64(size)
+	}
+
+	for _, order := range []Order{OrderWalk, OrderInode, OrderPhysical, OrderSizeAsc, OrderSizeDesc} {
+		name := string(order)
+		if order == OrderWalk {
+			name = "walk"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := Options{Recursive: true, SkipSpaceCheck: true, Order: order}
+			destinationRoot := b.TempDir()
+			b.SetBytes(total)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dropTreeCache(b, sourceDir)
+				destinationDir := filepath.Join(destinationRoot, fmt.Sprint(i))
+				b.StartTimer()
+
+				if _, err := New(opts, nil).Copy(context.Background(), []string{sourceDir}, destinationDir); err != nil {
+					b.Fatalf("copy: %v", err)
+				}
+
+				b.StopTimer()
+				if err := os.RemoveAll(destinationDir); err != nil {
+					b.Fatalf("remove destination: %v", err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// dropTreeCache evicts every file below directory from the page cache, where
+// the platform allows it.
+func dropTreeCache(b *testing.B, directory string) {
+	b.Helper()
+
+	err := filepath.WalkDir(directory, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		dropCache(file, 0, 0, false)
+		return file.Close()
+	})
+	if err != nil {
+		b.Fatalf("drop source cache: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package copier
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsIocFiemap is FS_IOC_FIEMAP, _IOWR('f', 11, struct fiemap).
+const fsIocFiemap = 0xC020660B
+
+// fiemap is struct fiemap from linux/fiemap.h with room for one extent.
+type fiemap struct {
+	start         uint64
+	length        uint64
+	flags         uint32
+	mappedExtents uint32
+	extentCount   uint32
+	reserved      uint32
+	extent        fiemapExtent
+}
+
+type fiemapExtent struct {
+	logical    uint64
+	physical   uint64
+	length     uint64
+	reserved64 [2]uint64
+	flags      uint32
+	reserved   [3]uint32
+}
+
+// physicalOffset returns where on its device the first extent of the file at
+// path starts. Files without extents, such as empty ones, are at 0.
+func physicalOffset(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	request := fiemap{length: ^uint64(0), extentCount: 1}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&request)))
+	if errno == unix.EOPNOTSUPP || errno == unix.ENOTTY {
+		return 0, errors.ErrUnsupported
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+
+	if request.mappedExtents == 0 {
+		return 0, nil
+	}
+	return request.extent.physical, nil
+}
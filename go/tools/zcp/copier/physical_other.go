@@ -0,0 +1,10 @@
+//go:build !linux
+
+package copier
+
+import "errors"
+
+// physicalOffset reports that file extents cannot be located outside Linux.
+func physicalOffset(string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,258 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// BufferSizeAuto sizes each file's copy buffer from the file's size
+// (--buffer-size=auto).
+const BufferSizeAuto = -1
+
+const (
+	// ringBuffers is the number of buffers cycled between the reader and the
+	// writer, so a few reads can run ahead of a slow write.
+	ringBuffers = 4
+
+	minAutoBufferSize = 64 * 1024
+	maxAutoBufferSize = 1024 * 1024
+)
+
+// autoBufferSize picks a buffer for a file of size bytes: the smallest power
+// of two holding the whole file, between 64 KiB and 1 MiB. Files up to 1 MiB
+// are then copied in a single read and write, and larger ones are pipelined
+// in 1 MiB chunks, which BenchmarkCopy shows is where bigger buffers stop
+// paying off.
+func autoBufferSize(size uint64) int {
+	buffer := minAutoBufferSize
+	for buffer < maxAutoBufferSize && uint64(buffer) <= size {
+		buffer *= 2
+	}
+	return buffer
+}
+
+// autoBufferSizeFor sizes op's buffer from its size, using the largest buffer
+// for streams of unknown length.
+func autoBufferSizeFor(op Operation) int {
+	if op.stream != nil && op.Size == 0 {
+		return maxAutoBufferSize
+	}
+	return autoBufferSize(op.Size)
+}
+
+// bufferSizeFor returns the buffer size used to copy op. Direct I/O needs
+// whole blocks.
+func (e *execution) bufferSizeFor(op Operation) int {
+	size := e.opts.BufferSize
+	if size == BufferSizeAuto {
+		size = autoBufferSizeFor(op)
+	}
+	if e.opts.Direct {
+		size = alignBufferSize(size)
+	}
+	return size
+}
+
+// ringBufferSize returns the size of the buffers in the ring, which must fit
+// the largest file's buffer.
+func ringBufferSize(plan []Operation, opts Options) int {
+	size := opts.BufferSize
+	if size == BufferSizeAuto {
+		size = minAutoBufferSize
+		for _, op := range plan {
+			if op.Kind == OperationCopyFile {
+				size = max(size, autoBufferSizeFor(op))
+			}
+		}
+	}
+	if opts.Direct {
+		size = alignBufferSize(size)
+	}
+	return size
+}
+
+// bufferRing is a fixed set of buffers reused for every file in a run. Free
+// buffers wait in the channel; the reader takes one, fills it and hands it to
+// the writer, which returns it once written.
+type bufferRing struct {
+	free chan []byte
+}
+
+func newBufferRing(count int, size int, aligned bool) *bufferRing {
+	ring := &bufferRing{free: make(chan []byte, count)}
+	for range count {
+		if aligned {
+			ring.free <- alignedBuffer(size)
+		} else {
+			ring.free <- make([]byte, size)
+		}
+	}
+	return ring
+}
+
+// buffers returns the run's ring, allocating it on first use.
+func (e *execution) buffers() *bufferRing {
+	if e.ring == nil {
+		e.ring = newBufferRing(ringBuffers, e.ringBufferSize, e.opts.Direct)
+	}
+	return e.ring
+}
+
+// copyStream copies source to destination for op, stopping early if ctx is
+// cancelled. Files known to fit in one buffer are copied directly; larger
+// ones and streams are read ahead on a separate goroutine while earlier
+// buffers are written.
+func (e *execution) copyStream(ctx context.Context, op Operation, destination io.Writer, source io.Reader) error {
+	bufferSize := e.bufferSizeFor(op)
+	if op.Size < uint64(bufferSize) && op.decompress == CompressionNone && op.stream == nil {
+		return e.copySerial(ctx, op, destination, source, bufferSize)
+	}
+	return e.copyPipelined(ctx, op, destination, source, bufferSize)
+}
+
+func (e *execution) copySerial(
+	ctx context.Context,
+	op Operation,
+	destination io.Writer,
+	source io.Reader,
+	bufferSize int,
+) error {
+	ring := e.buffers()
+	buffer := <-ring.free
+	defer func() { ring.free <- buffer }()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		readBytes, readErr := source.Read(buffer[:bufferSize])
+		if readBytes > 0 {
+			if err := writeChunk(op, destination, buffer[:readBytes]); err != nil {
+				return err
+			}
+			e.reportBytes()
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("read source file %q: %w", op.Source, readErr)
+		}
+	}
+}
+
+func (e *execution) copyPipelined(
+	ctx context.Context,
+	op Operation,
+	destination io.Writer,
+	source io.Reader,
+	bufferSize int,
+) error {
+	ring := e.buffers()
+	filled := make(chan []byte, cap(ring.free))
+	stop := make(chan struct{})
+	var readErr error
+
+	go func() {
+		defer close(filled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			var buffer []byte
+			select {
+			case buffer = <-ring.free:
+			case <-stop:
+				return
+			}
+
+			readBytes, err := source.Read(buffer[:bufferSize])
+			if readBytes > 0 {
+				filled <- buffer[:readBytes]
+			} else {
+				ring.free <- buffer
+			}
+
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	// Keep draining after a failed write so every buffer is back in the ring
+	// and the reader has exited before returning.
+	var writeErr error
+	for buffer := range filled {
+		if writeErr == nil {
+			writeErr = ctx.Err()
+			if writeErr == nil {
+				writeErr = writeChunk(op, destination, buffer)
+			}
+			if writeErr != nil {
+				close(stop)
+			}
+			e.reportBytes()
+		}
+		ring.free <- buffer[:cap(buffer)]
+	}
+
+	if writeErr != nil {
+		return writeErr
+	}
+	if readErr != nil {
+		return fmt.Errorf("read source file %q: %w", op.Source, readErr)
+	}
+	return nil
+}
+
+func writeChunk(op Operation, destination io.Writer, chunk []byte) error {
+	writtenBytes, err := destination.Write(chunk)
+	if err != nil {
+		return fmt.Errorf("write destination file %q: %w", op.Destination, err)
+	}
+	if writtenBytes != len(chunk) {
+		return fmt.Errorf("write destination file %q: short write", op.Destination)
+	}
+	return nil
+}
+
+// reportBytes passes source bytes read since the last call on to the stats and
+// the observer. It runs on the goroutine executing the plan, so observers are
+// never called from the reader goroutine.
+func (e *execution) reportBytes() {
+	read := e.sourceRead.Load()
+	if read == e.reported {
+		return
+	}
+	delta := read - e.reported
+	e.reported = read
+	e.stats.BytesRead += delta
+	e.observer.Bytes(delta)
+}
+
+// progressReader counts bytes read from a source file. It may be read from
+// the reader goroutine, so it only updates an atomic counter.
+type progressReader struct {
+	reader io.Reader
+	count  *atomic.Uint64
+}
+
+func (r *progressReader) Read(buffer []byte) (int, error) {
+	n, err := r.reader.Read(buffer)
+	if n > 0 {
+		r.count.Add(uint64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,211 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingWriter accepts limit bytes, then fails every write.
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (w *failingWriter) Write(buffer []byte) (int, error) {
+	if w.written+len(buffer) > w.limit {
+		return 0, errors.New("disk on fire")
+	}
+	w.written += len(buffer)
+	return len(buffer), nil
+}
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+
+	t.Run("copies_files_larger_than_the_ring", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := filepath.Join(tempDir, "source.bin")
+		content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+		if err := os.WriteFile(sourceFile, content, 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		observer := &recordingObserver{}
+		destinationFile := filepath.Join(tempDir, "dest.bin")
+		_, err := New(Options{BufferSize: 1000}, observer).Copy(context.Background(), []string{sourceFile}, destinationFile)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		got, err := os.ReadFile(destinationFile)
+		if err != nil {
+			t.Fatalf("read destination file: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("destination content differs from source")
+		}
+		if observer.bytes != uint64(len(content)) {
+			t.Fatalf("expected %d bytes observed, got %d", len(content), observer.bytes)
+		}
+	})
+
+	t.Run("write_errors_stop_the_reader_and_return_buffers", func(t *testing.T) {
+		t.Parallel()
+
+		op := Operation{Kind: OperationCopyFile, Source: "source", Destination: "dest", Size: 1 << 20}
+		plan := []Operation{op}
+		e := newExecution(plan, Options{BufferSize: 4096}, NopObserver{})
+
+		err := e.copyStream(context.Background(), op, &failingWriter{limit: 10000}, bytes.NewReader(make([]byte, 1<<20)))
+		if err == nil || !strings.Contains(err.Error(), "disk on fire") {
+			t.Fatalf("expected write error, got %v", err)
+		}
+		if got := len(e.ring.free); got != ringBuffers {
+			t.Fatalf("expected all %d buffers back in the ring, got %d", ringBuffers, got)
+		}
+	})
+
+	t.Run("read_errors_name_the_source", func(t *testing.T) {
+		t.Parallel()
+
+		op := Operation{Kind: OperationCopyFile, Source: "source", Destination: "dest", Size: 1 << 20}
+		e := newExecution([]Operation{op}, Options{BufferSize: 4096}, NopObserver{})
+
+		source := io.MultiReader(bytes.NewReader(make([]byte, 10000)), iotestErrReader{})
+		err := e.copyStream(context.Background(), op, io.Discard, source)
+		if err == nil || !strings.Contains(err.Error(), `read source file "source"`) {
+			t.Fatalf("expected read error, got %v", err)
+		}
+	})
+
+	t.Run("auto_buffer_size", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := []struct {
+			size uint64
+			want int
+		}{
+			{size: 0, want: minAutoBufferSize},
+			{size: 4096, want: minAutoBufferSize},
+			{size: 100 * 1024, want: 128 * 1024},
+			{size: 1 << 20, want: maxAutoBufferSize},
+			{size: 1 << 40, want: maxAutoBufferSize},
+		}
+		for _, testCase := range testCases {
+			if got := autoBufferSize(testCase.size); got != testCase.want {
+				t.Fatalf("autoBufferSize(%d) = %d, want %d", testCase.size, got, testCase.want)
+			}
+		}
+
+		plan := []Operation{
+			{Kind: OperationCopyFile, Size: 4096},
+			{Kind: OperationCopyFile, Size: 16 << 20},
+		}
+		if got := ringBufferSize(plan, Options{BufferSize: BufferSizeAuto}); got != maxAutoBufferSize {
+			t.Fatalf("expected ring sized for the largest file, got %d", got)
+		}
+	})
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) {
+	return 0, errors.New("bad sector")
+}
+
+// BenchmarkCopy copies trees of different file-size distributions with
+// different buffer sizes. Run with:
+//
+//	go test -run '^$' -bench Copy ./copier
+func BenchmarkCopy(b *testing.B) {
+	distributions := []struct {
+		name  string
+		sizes []int
+	}{
+		{name: "small_4KiB_x512", sizes: repeatSize(4*1024, 512)},
+		{name: "mixed_1KiB_to_4MiB", sizes: mixedSizes()},
+		{name: "large_64MiB_x2", sizes: repeatSize(64*1024*1024, 2)},
+	}
+	bufferSizes := []struct {
+		name string
+		size int
+	}{
+		{name: "64KiB", size: 64 * 1024},
+		{name: "256KiB", size: 256 * 1024},
+		{name: "1MiB", size: 1024 * 1024},
+		{name: "4MiB", size: 4 * 1024 * 1024},
+		{name: "auto", size: BufferSizeAuto},
+	}
+
+	for _, distribution := range distributions {
+		sourceDir := filepath.Join(b.TempDir(), "source")
+		total := writeBenchmarkTree(b, sourceDir, distribution.sizes)
+
+		for _, bufferSize := range bufferSizes {
+			b.Run(distribution.name+"/"+bufferSize.name, func(b *testing.B) {
+				opts := Options{Recursive: true, BufferSize: bufferSize.size, SkipSpaceCheck: true}
+				destinationRoot := b.TempDir()
+				b.SetBytes(total)
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					destinationDir := filepath.Join(destinationRoot, fmt.Sprint(i))
+					if _, err := New(opts, nil).Copy(context.Background(), []string{sourceDir}, destinationDir); err != nil {
+						b.Fatalf("copy: %v", err)
+					}
+
+					b.StopTimer()
+					if err := os.RemoveAll(destinationDir); err != nil {
+						b.Fatalf("remove destination: %v", err)
+					}
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+func repeatSize(size int, count int) []int {
+	sizes := make([]int, count)
+	for i := range sizes {
+		sizes[i] = size
+	}
+	return sizes
+}
+
+// mixedSizes spreads 64 files from 1 KiB to 4 MiB, most of them small, like a
+// source tree with a few assets.
+func mixedSizes() []int {
+	sizes := make([]int, 0, 64)
+	for i := range 64 {
+		sizes = append(sizes, 1024<<(i%13))
+	}
+	return sizes
+}
+
+func writeBenchmarkTree(b *testing.B, directory string, sizes []int) int64 {
+	b.Helper()
+
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		b.Fatalf("mkdir source: %v", err)
+	}
+
+	var total int64
+	for i, size := range sizes {
+		content := bytes.Repeat([]byte{byte(i)}, size)
+		if err := os.WriteFile(filepath.Join(directory, fmt.Sprintf("file-%04d.bin", i)), content, 0o644); err != nil {
+			b.Fatalf("write source file: %v", err)
+		}
+		total += int64(size)
+	}
+	return total
+}
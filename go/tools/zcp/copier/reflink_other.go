@@ -0,0 +1,13 @@
+//go:build !linux
+
+package copier
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile reports that reflinks are unavailable outside Linux.
+func cloneFile(*os.File, *os.File) error {
+	return errors.ErrUnsupported
+}
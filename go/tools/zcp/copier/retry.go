@@ -0,0 +1,236 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+)
+
+// maxRetryDelay caps the exponential backoff between retries.
+const maxRetryDelay = time.Minute
+
+// isTransient reports whether err is an I/O error that network filesystems
+// and flaky USB bridges often recover from, so it is worth retrying.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// retryDelay returns the wait before retry attempt (counting from 0): the
+// RetryDelay, doubled for every earlier attempt.
+func (e *execution) retryDelay(attempt int) time.Duration {
+	delay := e.opts.RetryDelay
+	for range attempt {
+		if delay >= maxRetryDelay/2 {
+			return maxRetryDelay
+		}
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// waitToRetry decides whether err should be retried after the attempts
+// already spent on a file, and if so waits for the backoff and counts the
+// retry. It may be called from the reader goroutine.
+func (e *execution) waitToRetry(ctx context.Context, attempts *int, err error) bool {
+	if *attempts >= e.opts.Retries || !isTransient(err) {
+		return false
+	}
+
+	timer := time.NewTimer(e.retryDelay(*attempts))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+
+	*attempts++
+	e.retries.Add(1)
+	return true
+}
+
+// resumingReader reads a source file, reopening it at the last good offset
+// after transient errors. Sources inside archives and standard input are read
+// from a single stream and are never retried.
+type resumingReader struct {
+	ctx       context.Context
+	execution *execution
+	op        Operation
+	file      fs.File
+	reader    io.Reader
+	offset    int64
+	// attempts counts the retries spent reading the file so far.
+	attempts int
+}
+
+func (e *execution) openSourceReader(ctx context.Context, op Operation) (*resumingReader, error) {
+	r := &resumingReader{ctx: ctx, execution: e, op: op}
+	for {
+		err := r.open()
+		if err == nil {
+			return r, nil
+		}
+		if !r.canRetry() || !e.waitToRetry(ctx, &r.attempts, err) {
+			return nil, err
+		}
+	}
+}
+
+func (r *resumingReader) canRetry() bool {
+	return r.op.fsys == nil && r.op.stream == nil
+}
+
+// open opens the source and seeks to the current offset.
+func (r *resumingReader) open() error {
+	file, err := openSource(r.op)
+	if err != nil {
+		return err
+	}
+
+	if r.offset > 0 {
+		seeker, ok := file.(io.Seeker)
+		if !ok {
+			file.Close()
+			return fmt.Errorf("resume source file %q: source is not seekable", r.op.Source)
+		}
+		if _, err := seeker.Seek(r.offset, io.SeekStart); err != nil {
+			file.Close()
+			return fmt.Errorf("resume source file %q: %w", r.op.Source, err)
+		}
+	}
+
+	r.file = file
+	r.reader = file
+	if osFile, ok := file.(*os.File); ok && r.execution.opts.Direct {
+		direct := newDirectFile(osFile)
+		direct.offset = r.offset
+		r.reader = direct
+	}
+	return nil
+}
+
+func (r *resumingReader) Read(buffer []byte) (int, error) {
+	for {
+		n, err := r.reader.Read(buffer)
+		r.offset += int64(n)
+		if err == nil || errors.Is(err, io.EOF) || !r.canRetry() {
+			return n, err
+		}
+		// Hand over what was read; the error will come back on the next
+		// read if it persists.
+		if n > 0 && isTransient(err) && r.execution.opts.Retries > 0 {
+			return n, nil
+		}
+		if !r.execution.waitToRetry(r.ctx, &r.attempts, err) {
+			return n, err
+		}
+
+		r.file.Close()
+		for err := r.open(); err != nil; err = r.open() {
+			if !r.execution.waitToRetry(r.ctx, &r.attempts, err) {
+				r.file = nil
+				return 0, err
+			}
+		}
+	}
+}
+
+func (r *resumingReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// resumingWriter writes a destination file, reopening it at the last good
+// offset after transient errors, so a write is never retried on a descriptor
+// or session that has already failed.
+type resumingWriter struct {
+	ctx       context.Context
+	execution *execution
+	name      string
+	perm      fs.FileMode
+	direct    bool
+	file      io.WriteCloser
+	writer    io.Writer
+	offset    int64
+	// attempts counts the retries spent writing the file so far.
+	attempts int
+}
+
+// newResumingWriter writes to file, opened from the backend as name. With
+// direct, local files bypass the page cache.
+func (e *execution) newResumingWriter(ctx context.Context, name string, perm fs.FileMode, file io.WriteCloser, direct bool) *resumingWriter {
+	w := &resumingWriter{ctx: ctx, execution: e, name: name, perm: perm, direct: direct}
+	w.setFile(file)
+	return w
+}
+
+func (w *resumingWriter) setFile(file io.WriteCloser) {
+	w.file = file
+	w.writer = file
+	if osFile, ok := file.(*os.File); ok && w.direct {
+		direct := newDirectFile(osFile)
+		direct.offset = w.offset
+		w.writer = direct
+	}
+}
+
+// reopen closes the destination and opens it again at the current offset.
+func (w *resumingWriter) reopen() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+
+	file, err := w.execution.backend.OpenFile(w.name, os.O_WRONLY, w.perm)
+	if err != nil {
+		return fmt.Errorf("reopen destination file %q: %w", w.name, err)
+	}
+	seeker, ok := file.(io.Seeker)
+	if !ok {
+		file.Close()
+		return fmt.Errorf("resume destination file %q: destination is not seekable", w.name)
+	}
+	if _, err := seeker.Seek(w.offset, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("resume destination file %q: %w", w.name, err)
+	}
+
+	w.setFile(file)
+	return nil
+}
+
+func (w *resumingWriter) Write(buffer []byte) (int, error) {
+	written := 0
+	for {
+		n, err := w.writer.Write(buffer[written:])
+		written += n
+		w.offset += int64(n)
+		if err == nil {
+			return written, nil
+		}
+		if !w.execution.waitToRetry(w.ctx, &w.attempts, err) {
+			return written, err
+		}
+
+		for err := w.reopen(); err != nil; err = w.reopen() {
+			if !w.execution.waitToRetry(w.ctx, &w.attempts, err) {
+				return written, err
+			}
+		}
+	}
+}
+
+// Close closes the destination file, unless reopening it failed.
+func (w *resumingWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,201 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyBackend is the local filesystem, where the writes numbered in failAt,
+// counted across every file opened, fail with err after writing half of the
+// buffer.
+type flakyBackend struct {
+	LocalBackend
+	err    error
+	failAt []int
+	writes int
+	opens  int
+}
+
+func (b *flakyBackend) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	b.opens++
+	return &flakyFile{File: file, backend: b}, nil
+}
+
+type flakyFile struct {
+	*os.File
+	backend *flakyBackend
+}
+
+func (f *flakyFile) Write(buffer []byte) (int, error) {
+	write := f.backend.writes
+	f.backend.writes++
+	if slices.Contains(f.backend.failAt, write) {
+		n, _ := f.File.Write(buffer[:len(buffer)/2])
+		return n, f.backend.err
+	}
+	return f.File.Write(buffer)
+}
+
+// flakyReader fails with EIO once it has read limit bytes.
+type flakyReader struct {
+	reader io.Reader
+	limit  int
+}
+
+func (r *flakyReader) Read(buffer []byte) (int, error) {
+	if r.limit <= 0 {
+		return 0, syscall.EIO
+	}
+	n, err := r.reader.Read(buffer[:min(len(buffer), r.limit)])
+	r.limit -= n
+	return n, err
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	newTestExecution := func(retries int) *execution {
+		return newExecution(nil, Options{BufferSize: 4, Retries: retries}, NopObserver{})
+	}
+
+	// writeFlaky writes chunks through a resumingWriter to a new file on
+	// backend, returning what the file holds afterwards.
+	writeFlaky := func(t *testing.T, retries int, backend *flakyBackend, chunks ...string) (string, *execution, error) {
+		t.Helper()
+
+		name := filepath.Join(t.TempDir(), "destination.txt")
+		e := newExecution(nil, Options{Retries: retries, Backend: backend}, NopObserver{})
+		file, err := backend.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+		if err != nil {
+			t.Fatalf("open destination: %v", err)
+		}
+		writer := e.newResumingWriter(context.Background(), name, 0o644, file, false)
+		defer writer.Close()
+
+		for _, chunk := range chunks {
+			n, err := writer.Write([]byte(chunk))
+			if err != nil {
+				return "", e, err
+			}
+			if n != len(chunk) {
+				t.Fatalf("expected %d bytes written, got %d", len(chunk), n)
+			}
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read destination: %v", err)
+		}
+		return string(content), e, nil
+	}
+
+	t.Run("writes_resume_after_transient_errors", func(t *testing.T) {
+		t.Parallel()
+
+		backend := &flakyBackend{err: syscall.EIO, failAt: []int{0, 1}}
+		content, e, err := writeFlaky(t, 2, backend, "abcdefgh")
+		if err != nil {
+			t.Fatalf("expected write to succeed after retries, got %v", err)
+		}
+		if content != "abcdefgh" {
+			t.Fatalf("expected each byte written once, got %q", content)
+		}
+		if got := e.retries.Load(); got != 2 {
+			t.Fatalf("expected 2 retries, got %d", got)
+		}
+		// Each retry writes through a newly opened file.
+		if backend.opens != 3 {
+			t.Fatalf("expected the destination reopened twice, got %d opens", backend.opens)
+		}
+	})
+
+	t.Run("gives_up_after_the_retry_limit", func(t *testing.T) {
+		t.Parallel()
+
+		backend := &flakyBackend{err: syscall.ETIMEDOUT, failAt: []int{0, 1}}
+		if _, _, err := writeFlaky(t, 1, backend, "abcdefgh"); !errors.Is(err, syscall.ETIMEDOUT) {
+			t.Fatalf("expected ETIMEDOUT after the retry limit, got %v", err)
+		}
+	})
+
+	t.Run("counts_retries_per_file", func(t *testing.T) {
+		t.Parallel()
+
+		// The second chunk's failure comes after the first chunk has spent
+		// the file's only retry.
+		backend := &flakyBackend{err: syscall.EIO, failAt: []int{0, 2}}
+		if _, _, err := writeFlaky(t, 1, backend, "abcd", "efgh"); !errors.Is(err, syscall.EIO) {
+			t.Fatalf("expected EIO once the file's retries are spent, got %v", err)
+		}
+	})
+
+	t.Run("does_not_retry_permanent_errors", func(t *testing.T) {
+		t.Parallel()
+
+		backend := &flakyBackend{err: syscall.ENOSPC, failAt: []int{0}}
+		_, e, err := writeFlaky(t, 3, backend, "abcdefgh")
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Fatalf("expected ENOSPC to fail immediately, got %v", err)
+		}
+		if got := e.retries.Load(); got != 0 {
+			t.Fatalf("expected no retries, got %d", got)
+		}
+	})
+
+	t.Run("reads_resume_at_the_last_good_offset", func(t *testing.T) {
+		t.Parallel()
+
+		sourceFile := filepath.Join(t.TempDir(), "source.txt")
+		content := []byte("0123456789abcdefghij")
+		if err := os.WriteFile(sourceFile, content, 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+
+		e := newTestExecution(1)
+		op := Operation{Kind: OperationCopyFile, Source: sourceFile}
+		reader, err := e.openSourceReader(context.Background(), op)
+		if err != nil {
+			t.Fatalf("open source: %v", err)
+		}
+		defer reader.Close()
+		reader.reader = &flakyReader{reader: reader.file, limit: 7}
+
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("read source: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("expected source read once without gaps, got %q", got)
+		}
+		if got := e.retries.Load(); got != 1 {
+			t.Fatalf("expected 1 retry, got %d", got)
+		}
+	})
+
+	t.Run("backs_off_exponentially", func(t *testing.T) {
+		t.Parallel()
+
+		e := newExecution(nil, Options{RetryDelay: 100 * time.Millisecond}, NopObserver{})
+		want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+		for attempt, delay := range want {
+			if got := e.retryDelay(attempt); got != delay {
+				t.Fatalf("retryDelay(%d) = %v, want %v", attempt, got, delay)
+			}
+		}
+		if got := e.retryDelay(40); got != maxRetryDelay {
+			t.Fatalf("expected backoff capped at %v, got %v", maxRetryDelay, got)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTPBackend writes to a remote host over SFTP.
+type SFTPBackend struct {
+	client *sftp.Client
+}
+
+// NewSFTPBackend returns a Backend that writes through client. Plan paths are
+// converted to slash-separated remote paths.
+func NewSFTPBackend(client *sftp.Client) *SFTPBackend {
+	return &SFTPBackend{client: client}
+}
+
+func (b *SFTPBackend) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	remote := filepath.ToSlash(name)
+
+	// SFTP cannot set the mode when opening, so only chmod files this call
+	// creates, matching os.OpenFile. The server handles O_EXCL itself, so an
+	// exclusive open that succeeds always created the file.
+	created := flag&os.O_EXCL != 0
+	if !created && flag&os.O_CREATE != 0 {
+		_, statErr := b.client.Stat(remote)
+		created = errors.Is(statErr, fs.ErrNotExist)
+	}
+
+	file, err := b.client.OpenFile(remote, flag)
+	if err != nil {
+		// SFTP version 3 has no "already exists" status, so report it the way
+		// the local filesystem would.
+		if flag&os.O_EXCL != 0 {
+			if _, statErr := b.client.Stat(remote); statErr == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+			}
+		}
+		return nil, err
+	}
+
+	if created && flag&os.O_CREATE != 0 {
+		if err := b.client.Chmod(remote, perm.Perm()); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func (b *SFTPBackend) MkdirAll(name string, perm fs.FileMode) error {
+	remote := filepath.ToSlash(name)
+	if _, err := b.client.Stat(remote); err == nil {
+		return b.client.MkdirAll(remote)
+	}
+
+	if err := b.client.MkdirAll(remote); err != nil {
+		return err
+	}
+	return b.client.Chmod(remote, perm.Perm())
+}
+
+func (b *SFTPBackend) Chmod(name string, mode fs.FileMode) error {
+	return b.client.Chmod(filepath.ToSlash(name), mode)
+}
+
+func (b *SFTPBackend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return b.client.Chtimes(filepath.ToSlash(name), atime, mtime)
+}
+
+func (b *SFTPBackend) Stat(name string) (fs.FileInfo, error) {
+	return b.client.Stat(filepath.ToSlash(name))
+}
+
+// FreeSpace uses the statvfs@openssh.com extension when the server has it.
+func (b *SFTPBackend) FreeSpace(name string) (FreeSpace, error) {
+	if _, ok := b.client.HasExtension("statvfs@openssh.com"); !ok {
+		return FreeSpace{}, errors.ErrUnsupported
+	}
+
+	stat, err := b.client.StatVFS(filepath.ToSlash(name))
+	if err != nil {
+		return FreeSpace{}, err
+	}
+	return FreeSpace{Available: stat.Bavail * stat.Frsize, BlockSize: stat.Frsize}, nil
+}
+
+// canSync reports whether the server has the fsync@openssh.com extension,
+// which SyncFile and SyncDir need.
+func (b *SFTPBackend) canSync() bool {
+	data, ok := b.client.HasExtension("fsync@openssh.com")
+	return ok && data == "1"
+}
+
+// SyncFile uses the fsync@openssh.com extension.
+func (b *SFTPBackend) SyncFile(name string) error {
+	return b.syncPath(name)
+}
+
+// SyncDir uses the fsync@openssh.com extension on the directory, which
+// OpenSSH opens like a file.
+func (b *SFTPBackend) SyncDir(name string) error {
+	return b.syncPath(name)
+}
+
+// SyncFS is not possible over SFTP, so written files are flushed one by one.
+func (b *SFTPBackend) SyncFS(string) error {
+	return errors.ErrUnsupported
+}
+
+func (b *SFTPBackend) syncPath(name string) error {
+	file, err := b.client.Open(filepath.ToSlash(name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func (b *SFTPBackend) Rename(oldName string, newName string) error {
+	oldRemote, newRemote := filepath.ToSlash(oldName), filepath.ToSlash(newName)
+	if err := b.client.PosixRename(oldRemote, newRemote); err != nil {
+		if rnErr := b.client.Rename(oldRemote, newRemote); rnErr != nil {
+			return fmt.Errorf("rename %q to %q: %w", oldName, newName, errors.Join(err, rnErr))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// FreeSpace describes the space left on a filesystem.
+type FreeSpace struct {
+	// Available is the number of bytes unprivileged users can still write.
+	Available uint64
+	// BlockSize is the allocation unit files are rounded up to, or 0 if
+	// unknown.
+	BlockSize uint64
+}
+
+// SpaceBackend is implemented by backends that can report free space, so a
+// copy that cannot fit fails before anything is written.
+type SpaceBackend interface {
+	Backend
+	// FreeSpace reports the space left on the filesystem containing name. It
+	// returns errors.ErrUnsupported where that cannot be determined.
+	FreeSpace(name string) (FreeSpace, error)
+}
+
+func (LocalBackend) FreeSpace(name string) (FreeSpace, error) {
+	return freeSpace(name)
+}
+
+// InsufficientSpaceError reports that a copy does not fit on its destination.
+type InsufficientSpaceError struct {
+	Path      string
+	Needed    uint64
+	Available uint64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf(
+		"not enough free space on %q: need %d bytes, %d available (use --no-space-check to copy anyway)",
+		e.Path,
+		e.Needed,
+		e.Available,
+	)
+}
+
+// checkFreeSpace fails if the files in plan cannot fit on the destination.
+// Files that will be overwritten give their current space back, and every file
+// is rounded up to whole blocks. Backends that cannot report free space are
+// not checked.
+func checkFreeSpace(backend Backend, plan Plan, destination string, opts Options) error {
+	spaceBackend, ok := backend.(SpaceBackend)
+	if !ok {
+		return nil
+	}
+
+	existing, err := nearestExistingPath(backend, destination)
+	if err != nil {
+		return err
+	}
+
+	space, err := spaceBackend.FreeSpace(existing)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check free space on %q: %w", existing, err)
+	}
+
+	var needed, reclaimed uint64
+	if opts.ToArchive {
+		needed = roundUpToBlock(plan.TotalBytes, space.BlockSize)
+		reclaimed = existingFileSize(backend, destination, opts, space.BlockSize)
+	} else {
+		for _, op := range plan.Operations {
+			if op.Kind != OperationCopyFile || op.LinkFrom != "" {
+				continue
+			}
+			needed += roundUpToBlock(op.Size, space.BlockSize)
+			reclaimed += existingFileSize(backend, op.Destination, opts, space.BlockSize)
+		}
+	}
+
+	if reclaimed >= needed {
+		return nil
+	}
+	if needed-reclaimed > space.Available {
+		return &InsufficientSpaceError{Path: existing, Needed: needed - reclaimed, Available: space.Available}
+	}
+	return nil
+}
+
+// existingFileSize returns the space an existing destination file frees when
+// it is overwritten, which only happens with Force.
+func existingFileSize(backend Backend, name string, opts Options, blockSize uint64) uint64 {
+	if !opts.Force {
+		return 0
+	}
+	info, err := backend.Stat(name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() <= 0 {
+		return 0
+	}
+	return roundUpToBlock(uint64(info.Size()), blockSize)
+}
+
+func nearestExistingPath(backend Backend, name string) (string, error) {
+	current := name
+	for {
+		_, err := backend.Stat(current)
+		if err == nil {
+			return current, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("stat destination %q: %w", current, err)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return current, nil
+		}
+		current = parent
+	}
+}
+
+func roundUpToBlock(size uint64, blockSize uint64) uint64 {
+	if blockSize == 0 || size%blockSize == 0 {
+		return size
+	}
+	return size + blockSize - size%blockSize
+}
@@ -0,0 +1,128 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// limitedSpaceBackend is the local filesystem with a fixed amount of free
+// space.
+type limitedSpaceBackend struct {
+	LocalBackend
+	available uint64
+}
+
+func (b limitedSpaceBackend) FreeSpace(string) (FreeSpace, error) {
+	return FreeSpace{Available: b.available, BlockSize: 512}, nil
+}
+
+func TestFreeSpaceCheck(t *testing.T) {
+	t.Parallel()
+
+	writeSource := func(t *testing.T, directory string, size int) string {
+		t.Helper()
+
+		path := filepath.Join(directory, "source.bin")
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatalf("write source file: %v", err)
+		}
+		return path
+	}
+
+	t.Run("fails_before_writing_when_space_is_short", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := writeSource(t, tempDir, 4096)
+		destinationFile := filepath.Join(tempDir, "nested", "dest.bin")
+
+		observer := &recordingObserver{}
+		opts := Options{Backend: limitedSpaceBackend{available: 1024}}
+		_, err := New(opts, observer).Copy(context.Background(), []string{sourceFile}, destinationFile)
+
+		var spaceErr *InsufficientSpaceError
+		if !errors.As(err, &spaceErr) {
+			t.Fatalf("expected InsufficientSpaceError, got %v", err)
+		}
+		if spaceErr.Needed != 4096 || spaceErr.Available != 1024 {
+			t.Fatalf("unexpected space error: %+v", spaceErr)
+		}
+		if len(observer.events) != 0 {
+			t.Fatalf("expected no observer events before failing, got %v", observer.events)
+		}
+		if _, err := os.Stat(filepath.Dir(destinationFile)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected nothing to be written, stat returned %v", err)
+		}
+	})
+
+	t.Run("counts_space_freed_by_overwritten_files", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := writeSource(t, tempDir, 4096)
+		destinationFile := filepath.Join(tempDir, "dest.bin")
+		if err := os.WriteFile(destinationFile, make([]byte, 4000), 0o644); err != nil {
+			t.Fatalf("write destination file: %v", err)
+		}
+
+		opts := Options{Force: true, Backend: limitedSpaceBackend{available: 512}}
+		if _, err := New(opts, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err != nil {
+			t.Fatalf("expected overwrite to fit, got %v", err)
+		}
+	})
+
+	t.Run("can_be_skipped", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := writeSource(t, tempDir, 4096)
+
+		opts := Options{SkipSpaceCheck: true, Backend: limitedSpaceBackend{available: 0}}
+		_, err := New(opts, nil).Copy(context.Background(), []string{sourceFile}, filepath.Join(tempDir, "dest.bin"))
+		if err != nil {
+			t.Fatalf("expected copy without space check to succeed, got %v", err)
+		}
+	})
+
+	t.Run("round_up_to_block", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := []struct {
+			size, blockSize, want uint64
+		}{
+			{size: 0, blockSize: 4096, want: 0},
+			{size: 1, blockSize: 4096, want: 4096},
+			{size: 4096, blockSize: 4096, want: 4096},
+			{size: 4097, blockSize: 4096, want: 8192},
+			{size: 10, blockSize: 0, want: 10},
+		}
+		for _, testCase := range testCases {
+			if got := roundUpToBlock(testCase.size, testCase.blockSize); got != testCase.want {
+				t.Fatalf("roundUpToBlock(%d, %d) = %d, want %d", testCase.size, testCase.blockSize, got, testCase.want)
+			}
+		}
+	})
+
+	t.Run("preallocated_files_keep_their_size", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sourceFile := writeSource(t, tempDir, 3*1024*1024+7)
+		destinationFile := filepath.Join(tempDir, "dest.bin")
+
+		if _, err := New(Options{}, nil).Copy(context.Background(), []string{sourceFile}, destinationFile); err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		info, err := os.Stat(destinationFile)
+		if err != nil {
+			t.Fatalf("stat destination: %v", err)
+		}
+		if info.Size() != 3*1024*1024+7 {
+			t.Fatalf("expected preallocated file to keep source size, got %d", info.Size())
+		}
+	})
+}
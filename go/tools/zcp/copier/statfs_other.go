@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package copier
+
+import "errors"
+
+func freeSpace(string) (FreeSpace, error) {
+	return FreeSpace{}, errors.ErrUnsupported
+}
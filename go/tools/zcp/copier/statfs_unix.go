@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package copier
+
+import "golang.org/x/sys/unix"
+
+func freeSpace(name string) (FreeSpace, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(name, &stat); err != nil {
+		return FreeSpace{}, err
+	}
+	return FreeSpace{
+		Available: uint64(stat.Bavail) * uint64(stat.Bsize),
+		BlockSize: uint64(stat.Bsize),
+	}, nil
+}
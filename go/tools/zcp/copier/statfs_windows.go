@@ -0,0 +1,16 @@
+package copier
+
+import "golang.org/x/sys/windows"
+
+func freeSpace(name string) (FreeSpace, error) {
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return FreeSpace{}, err
+	}
+
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, &total, &free); err != nil {
+		return FreeSpace{}, err
+	}
+	return FreeSpace{Available: available}, nil
+}
@@ -0,0 +1,104 @@
+package copier
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// streamPath names standard input as a source and standard output as a
+// destination.
+const streamPath = "-"
+
+func (o Options) stdin() io.Reader {
+	if o.Stdin == nil {
+		return os.Stdin
+	}
+	return o.Stdin
+}
+
+func (o Options) stdout() io.Writer {
+	if o.Stdout == nil {
+		return os.Stdout
+	}
+	return o.Stdout
+}
+
+// streamOperation plans copying standard input to target. Its size is only
+// known from Options.StreamSize.
+func streamOperation(target string, opts Options) Operation {
+	return Operation{
+		Kind:        OperationCopyFile,
+		Source:      streamPath,
+		Destination: target,
+		Mode:        0o644,
+		ModTime:     time.Now(),
+		Size:        opts.StreamSize,
+		stream:      opts.stdin(),
+	}
+}
+
+// sizeUnknown reports whether plan reads a stream of unknown length, so its
+// TotalBytes is only a lower bound.
+func sizeUnknown(plan []Operation) bool {
+	for _, op := range plan {
+		if op.stream != nil && op.Size == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// streamFile is standard input opened as a source file.
+type streamFile struct {
+	io.Reader
+}
+
+func (streamFile) Stat() (fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: streamPath, Err: errors.ErrUnsupported}
+}
+
+// Close leaves standard input open: it belongs to the caller.
+func (streamFile) Close() error {
+	return nil
+}
+
+// streamBackend writes every destination file, in plan order, to a single
+// stream, for a "-" destination. Metadata has nowhere to go and is dropped.
+type streamBackend struct {
+	writer io.Writer
+}
+
+func (b streamBackend) OpenFile(string, int, fs.FileMode) (io.WriteCloser, error) {
+	return nopWriteCloser{b.writer}, nil
+}
+
+func (streamBackend) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+func (streamBackend) Chmod(string, fs.FileMode) error {
+	return nil
+}
+
+func (streamBackend) Chtimes(string, time.Time, time.Time) error {
+	return nil
+}
+
+func (streamBackend) Stat(name string) (fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (streamBackend) Rename(string, string) error {
+	return errors.ErrUnsupported
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
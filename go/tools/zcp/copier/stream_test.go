@@ -0,0 +1,115 @@
+package copier
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreams(t *testing.T) {
+	t.Parallel()
+
+	t.Run("copies_stdin_to_a_file", func(t *testing.T) {
+		t.Parallel()
+
+		destinationFile := filepath.Join(t.TempDir(), "dest.bin")
+		content := bytes.Repeat([]byte("piped "), 100000)
+		opts := Options{Stdin: bytes.NewReader(content), BufferSize: 4096}
+
+		observer := &recordingObserver{}
+		result, err := New(opts, observer).Copy(context.Background(), []string{"-"}, destinationFile)
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+
+		got, err := os.ReadFile(destinationFile)
+		if err != nil {
+			t.Fatalf("read destination file: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("destination content differs from stdin")
+		}
+		if !result.Plan.SizeUnknown || observer.bytes != uint64(len(content)) {
+			t.Fatalf("expected unknown size and %d bytes observed, got %v and %d", len(content), result.Plan.SizeUnknown, observer.bytes)
+		}
+	})
+
+	t.Run("size_hint_sets_the_total", func(t *testing.T) {
+		t.Parallel()
+
+		opts := Options{Stdin: strings.NewReader("hinted"), StreamSize: 6}
+		result, err := New(opts, nil).Copy(context.Background(), []string{"-"}, filepath.Join(t.TempDir(), "dest.txt"))
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if result.Plan.SizeUnknown || result.Plan.TotalBytes != 6 {
+			t.Fatalf("expected a known total of 6 bytes, got %+v", result.Plan)
+		}
+	})
+
+	t.Run("writes_files_to_stdout_in_order", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		sources := []string{filepath.Join(tempDir, "a.txt"), filepath.Join(tempDir, "b.txt")}
+		for i, source := range sources {
+			if err := os.WriteFile(source, []byte(strings.Repeat(string(rune('a'+i)), 3)), 0o644); err != nil {
+				t.Fatalf("write source file: %v", err)
+			}
+		}
+
+		var stdout bytes.Buffer
+		opts := Options{Stdout: &stdout, Preserve: true}
+		if _, err := New(opts, nil).Copy(context.Background(), sources, "-"); err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if got := stdout.String(); got != "aaabbb" {
+			t.Fatalf("expected concatenated files on stdout, got %q", got)
+		}
+	})
+
+	t.Run("rejects_unsupported_streams", func(t *testing.T) {
+		t.Parallel()
+
+		tempDir := t.TempDir()
+		testCases := []struct {
+			name        string
+			opts        Options
+			sources     []string
+			destination string
+			want        string
+		}{
+			{
+				name:        "directory_to_stdout",
+				opts:        Options{Recursive: true, Stdout: &bytes.Buffer{}},
+				sources:     []string{tempDir},
+				destination: "-",
+				want:        "to standard output",
+			},
+			{
+				name:        "stdin_into_directory",
+				opts:        Options{Stdin: strings.NewReader("x")},
+				sources:     []string{"-"},
+				destination: tempDir,
+				want:        "name the destination file",
+			},
+			{
+				name:        "stdin_twice",
+				opts:        Options{Stdin: strings.NewReader("x"), Stdout: &bytes.Buffer{}},
+				sources:     []string{"-", "-"},
+				destination: "-",
+				want:        "only be copied once",
+			},
+		}
+
+		for _, testCase := range testCases {
+			_, err := New(testCase.opts, nil).Copy(context.Background(), testCase.sources, testCase.destination)
+			if err == nil || !strings.Contains(err.Error(), testCase.want) {
+				t.Fatalf("%s: expected error containing %q, got %v", testCase.name, testCase.want, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package copier
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func syncFilesystem(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return unix.Syncfs(int(file.Fd()))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package copier
+
+import "errors"
+
+// syncFilesystem is only available on Linux; elsewhere --fsync=end falls back
+// to syncing every written file and directory.
+func syncFilesystem(string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,279 @@
+package copier
+
+import (
+	"archive/tar"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tarFS exposes a tar archive, optionally compressed, as an fs.FS. Tar streams
+// cannot seek, so entries are indexed up front and file contents are read by
+// scanning forward; opening an entry that comes before the current position
+// reopens the archive. Only the most recently opened file can be read.
+type tarFS struct {
+	path     string
+	format   archiveFormat
+	entries  map[string]*tarEntry
+	children map[string][]string
+	stream   *tarStream
+}
+
+type tarEntry struct {
+	// index is the position of the entry's header in the stream, or -1 for
+	// directories only implied by the paths of other entries.
+	index int
+	info  fs.FileInfo
+}
+
+type tarStream struct {
+	closers []io.Closer
+	reader  *tar.Reader
+	// next is the index of the header the next call to reader.Next returns.
+	next int
+}
+
+func newTarFS(archivePath string, format archiveFormat, modTime time.Time) (*tarFS, error) {
+	fsys := &tarFS{
+		path:     archivePath,
+		format:   format,
+		entries:  map[string]*tarEntry{},
+		children: map[string][]string{},
+	}
+	fsys.entries["."] = &tarEntry{index: -1, info: impliedDirectoryInfo{name: ".", modTime: modTime}}
+
+	stream, err := openTarStream(archivePath, format)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = stream.close() }()
+
+	for {
+		header, err := stream.reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive %q: %w", archivePath, err)
+		}
+		index := stream.next
+		stream.next++
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			return nil, fmt.Errorf("unsupported entry %q in archive %q", header.Name, archivePath)
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("unsafe path %q in archive %q", header.Name, archivePath)
+		}
+
+		fsys.addEntry(name, &tarEntry{index: index, info: header.FileInfo()}, modTime)
+	}
+
+	for _, names := range fsys.children {
+		slices.Sort(names)
+	}
+	return fsys, nil
+}
+
+func (t *tarFS) addEntry(name string, entry *tarEntry, modTime time.Time) {
+	if existing, ok := t.entries[name]; ok {
+		// Later headers replace earlier ones, as when extracting with tar.
+		existing.index = entry.index
+		existing.info = entry.info
+		return
+	}
+	t.entries[name] = entry
+
+	parent := path.Dir(name)
+	t.children[parent] = append(t.children[parent], name)
+	if _, ok := t.entries[parent]; !ok {
+		t.addEntry(parent, &tarEntry{
+			index: -1,
+			info:  impliedDirectoryInfo{name: path.Base(parent), modTime: modTime},
+		}, modTime)
+	}
+}
+
+// orderEntries sorts the files in plan, planned from t, into the order they
+// are stored in, so they are read in one pass over the stream rather than
+// reopening it for every file stored before the last one read. Directories
+// keep their walk order ahead of the files, so each is created before
+// anything is extracted into it.
+func (t *tarFS) orderEntries(plan []Operation) {
+	slices.SortStableFunc(plan, func(a, b Operation) int {
+		if a.Kind != b.Kind {
+			return cmp.Compare(kindRank(a.Kind), kindRank(b.Kind))
+		}
+		if a.Kind == OperationCreateDirectory {
+			return 0
+		}
+		return cmp.Compare(t.entries[a.Source].index, t.entries[b.Source].index)
+	})
+}
+
+func (t *tarFS) lookup(op string, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	entry, err := t.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.info.IsDir() {
+		return &tarDirectory{fsys: t, name: name, info: entry.info}, nil
+	}
+
+	if t.stream == nil || t.stream.next > entry.index {
+		if t.stream != nil {
+			_ = t.stream.close()
+			t.stream = nil
+		}
+		stream, err := openTarStream(t.path, t.format)
+		if err != nil {
+			return nil, err
+		}
+		t.stream = stream
+	}
+
+	for t.stream.next <= entry.index {
+		if _, err := t.stream.reader.Next(); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		t.stream.next++
+	}
+
+	return &tarFile{fsys: t, stream: t.stream, index: entry.index, info: entry.info}, nil
+}
+
+func (t *tarFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := t.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.info, nil
+}
+
+func (t *tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := t.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	children := t.children[name]
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(t.entries[child].info))
+	}
+	return entries, nil
+}
+
+func (t *tarFS) Close() error {
+	if t.stream == nil {
+		return nil
+	}
+	err := t.stream.close()
+	t.stream = nil
+	return err
+}
+
+func openTarStream(archivePath string, format archiveFormat) (*tarStream, error) {
+	reader, closers, err := openArchiveStream(archivePath, format)
+	if err != nil {
+		return nil, err
+	}
+	return &tarStream{closers: closers, reader: tar.NewReader(reader)}, nil
+}
+
+func (s *tarStream) close() error {
+	var errs []error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		errs = append(errs, s.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+type tarFile struct {
+	fsys   *tarFS
+	stream *tarStream
+	index  int
+	info   fs.FileInfo
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *tarFile) Read(buffer []byte) (int, error) {
+	if f.fsys.stream != f.stream || f.stream.next != f.index+1 {
+		return 0, fmt.Errorf("archive entry %q is no longer readable", f.info.Name())
+	}
+	return f.stream.reader.Read(buffer)
+}
+
+func (f *tarFile) Close() error { return nil }
+
+type tarDirectory struct {
+	fsys   *tarFS
+	name   string
+	info   fs.FileInfo
+	offset int
+}
+
+func (d *tarDirectory) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *tarDirectory) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *tarDirectory) Close() error { return nil }
+
+func (d *tarDirectory) ReadDir(count int) ([]fs.DirEntry, error) {
+	entries, err := d.fsys.ReadDir(d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := entries[d.offset:]
+	if count > 0 {
+		if len(remaining) == 0 {
+			return nil, io.EOF
+		}
+		remaining = remaining[:min(count, len(remaining))]
+	}
+	d.offset += len(remaining)
+	return remaining, nil
+}
+
+type impliedDirectoryInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (i impliedDirectoryInfo) Name() string       { return i.name }
+func (i impliedDirectoryInfo) Size() int64        { return 0 }
+func (i impliedDirectoryInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o755 }
+func (i impliedDirectoryInfo) ModTime() time.Time { return i.modTime }
+func (i impliedDirectoryInfo) IsDir() bool        { return true }
+func (i impliedDirectoryInfo) Sys() any           { return nil }
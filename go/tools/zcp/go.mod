@@ -1,3 +1,12 @@
 module github.com/BoscoDomingo/utils/go/tools/zcp
 
-go 1.26
+go 1.26.0
+
+require (
+	github.com/klauspost/compress v1.20.1
+	github.com/pkg/sftp v1.13.11
+	golang.org/x/crypto v0.57.0
+	golang.org/x/sys v0.48.0
+)
+
+require github.com/kr/fs v0.1.0 // indirect
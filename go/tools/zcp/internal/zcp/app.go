@@ -1,24 +1,70 @@
 package zcp
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-)
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-const defaultBufferSize = 1024 * 1024
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
 
 type options struct {
-	recursive  bool
-	force      bool
-	preserve   bool
-	quiet      bool
-	verbose    bool
-	bufferSize int
+	copier.Options
+	quiet     bool
+	verbose   bool
+	ssh       sshOptions
+	filesFrom string
+	null      bool
+	dryRun    bool
+	compare   bool
+
+	manifest       string
+	manifestFormat string
+	checkManifest  string
+
+	sanitizeMap  string
+	restoreNames string
+
+	logFile   string
+	logFormat string
+
+	// settings and configPath are set for --print-config.
+	printConfig bool
+	settings    []effectiveSetting
+	configPath  string
+
+	man bool
+
+	// termProgress shows progress in the terminal's tab and title too.
+	termProgress bool
+
+	// queueArgs are the args to submit the copy to the queue daemon with,
+	// for --queue.
+	queue     bool
+	queueArgs []string
 }
 
-func Run(args []string, stdout io.Writer, stderr io.Writer) error {
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 2 && args[0] == "completion" {
+		if err := checkSubcommand(args[0]); err != nil {
+			return err
+		}
+		return writeCompletion(stdout, args[1])
+	}
+	if command, ok := parseQueueCommand(args); ok {
+		if err := checkSubcommand(args[0]); err != nil {
+			return err
+		}
+		return runQueueCommand(command, stdout)
+	}
+
 	opts, sources, destination, err := parseArgs(args, stderr)
 	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
@@ -26,86 +72,610 @@ func Run(args []string, stdout io.Writer, stderr io.Writer) error {
 		}
 		return err
 	}
+	if opts.printConfig {
+		return printConfig(stdout, opts.configPath, opts.settings)
+	}
+	if opts.man {
+		return writeManPage(stdout)
+	}
+	if opts.queue {
+		return runQueued(opts, sources, destination, stdout)
+	}
 
-	plan, totalBytes, err := buildCopyPlan(sources, destination, opts.recursive)
-	if err != nil {
-		return err
+	if opts.filesFrom != "" {
+		listed, err := readFileList(opts.filesFrom, opts.null, stdin)
+		if err != nil {
+			return err
+		}
+		sources = append(listed, sources...)
+		if len(sources) == 0 {
+			return fmt.Errorf("no sources listed in %q", opts.filesFrom)
+		}
+		opts.Relative = true
 	}
 
-	progress := newProgressBar(totalBytes, !opts.quiet, stdout)
-	progress.start()
-	defer progress.stop()
+	if target, ok := parseRemoteTarget(destination); ok {
+		backend, connection, err := dialSFTP(target, opts.ssh)
+		if err != nil {
+			return err
+		}
+		defer connection.Close()
+		opts.Backend = backend
+		destination = target.path
+	}
 
-	if err := executePlan(plan, opts, progress); err != nil {
-		return err
+	if opts.manifest != "" && destination == "-" {
+		return fmt.Errorf("--manifest cannot be used when copying to standard output")
+	}
+
+	// Keep standard output clean when it carries the copied data.
+	opts.Stdin = stdin
+	opts.Stdout = stdout
+	if destination == "-" {
+		stdout = stderr
+	}
+
+	if opts.checkManifest != "" {
+		return runCheckManifest(opts, destination, stdout)
+	}
+	if opts.restoreNames != "" {
+		return runRestoreNames(opts.restoreNames, destination, stdout)
+	}
+	if opts.compare {
+		return runCompare(opts, sources, destination, stdout)
+	}
+	if opts.dryRun {
+		plan, err := copier.New(opts.Options, nil).Plan(context.Background(), sources, destination)
+		if err != nil {
+			printPlanProblems(stdout, err)
+			return err
+		}
+		printPlan(stdout, plan)
+		return nil
+	}
+
+	ctx, stop := interruptible(opts.termProgress)
+	defer stop()
+	progress := newProgressObserver(!opts.quiet, stdout, opts.termProgress)
+	return runCopy(ctx, opts, args, sources, destination, progress, stdout)
+}
+
+// copyProgress follows a copy, and is stopped once it ends, before the
+// summary is printed.
+type copyProgress interface {
+	copier.Observer
+	stop()
+}
+
+// runCopy copies sources to destination and prints a summary of the copy to
+// stdout, for zcp itself and for jobs run by the queue daemon.
+func runCopy(ctx context.Context, opts options, args []string, sources []string, destination string, progress copyProgress, stdout io.Writer) error {
+	var observer copier.Observer = progress
+	var log *operationLog
+	if opts.logFile != "" {
+		var err error
+		log, err = openOperationLog(opts.logFile, opts.logFormat)
+		if err != nil {
+			return err
+		}
+		defer log.Close()
+		log.started(args, sources, destination)
+		observer = log.observer(progress)
 	}
+
+	result, err := copier.New(opts.Options, observer).Copy(ctx, sources, destination)
 	progress.stop()
+	if log != nil {
+		log.finished(result, err)
+	}
+	if err != nil {
+		printPlanProblems(stdout, err)
+		// Files copied before the error may already have sanitized names,
+		// so keep the map to restore them.
+		if opts.Sanitize && len(result.Plan.Renamed) > 0 {
+			if mapErr := writeNameMap(opts.sanitizeMap, result.Plan.Renamed); mapErr != nil {
+				return errors.Join(err, mapErr)
+			}
+			fmt.Fprintf(stdout, "Names renamed for the destination filesystem are listed in %s.\n", opts.sanitizeMap)
+		}
+		return err
+	}
 
 	if opts.verbose {
-		for _, op := range plan {
-			if op.kind == operationCopyFile {
-				fmt.Fprintf(stdout, "created: %s\n", op.destination)
+		for _, op := range result.Plan.Operations {
+			if op.Kind == copier.OperationCopyFile {
+				fmt.Fprintf(stdout, "created: %s\n", op.Destination)
 			}
 		}
 	}
 
-	fmt.Fprintf(stdout, "Copied %d file(s), %s total.\n", countFiles(plan), humanizeBytes(totalBytes))
+	// Streams are only as long as what was actually read.
+	total := result.Plan.TotalBytes
+	if result.Plan.SizeUnknown || opts.StreamSize > 0 {
+		total = result.Stats.BytesRead
+	}
+	fmt.Fprintf(
+		stdout,
+		"Copied %d file(s), %s total.\n",
+		result.Plan.Files()-result.Plan.Linked,
+		humanizeBytes(total),
+	)
+	if result.Plan.Linked > 0 {
+		fmt.Fprintf(
+			stdout,
+			"Linked %d unchanged file(s), %s, from %s.\n",
+			result.Plan.Linked,
+			humanizeBytes(result.Plan.LinkedBytes),
+			opts.LinkDest,
+		)
+	}
+	if filtered := formatFiltered(result.Plan.Filtered); filtered != "" {
+		fmt.Fprintln(stdout, filtered)
+	}
+	if opts.Compress != copier.CompressionNone || opts.Decompress {
+		fmt.Fprintf(
+			stdout,
+			"Wrote %s from %s read (compression ratio %.2f:1).\n",
+			humanizeBytes(result.Stats.BytesWritten),
+			humanizeBytes(result.Stats.BytesRead),
+			result.Stats.CompressionRatio(),
+		)
+	}
+	if opts.Sanitize {
+		if err := writeNameMap(opts.sanitizeMap, result.Plan.Renamed); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Renamed %d name(s) for the destination filesystem; the originals are in %s.\n", len(result.Plan.Renamed), opts.sanitizeMap)
+	}
+	if result.Stats.Deduplicated > 0 {
+		method := "hard link"
+		if opts.Dedupe == copier.DedupeReflink {
+			method = "reflink"
+		}
+		fmt.Fprintf(
+			stdout,
+			"Deduplicated %d file(s) by %s, saving %s.\n",
+			result.Stats.Deduplicated,
+			method,
+			humanizeBytes(result.Stats.BytesSaved),
+		)
+	}
+	if result.Stats.Retries > 0 {
+		fmt.Fprintf(stdout, "Recovered from %d transient I/O error(s) by retrying.\n", result.Stats.Retries)
+	}
+	if opts.manifest != "" {
+		if err := writeManifest(opts, result, destination); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Wrote manifest of %d file(s) to %s.\n", len(result.Stats.Hashes), opts.manifest)
+	}
+	if len(result.Stats.Changed) > 0 {
+		outcome := "and may be inconsistent"
+		if opts.OnChange == copier.ChangeRetry {
+			outcome = "and were copied again"
+		}
+		fmt.Fprintf(stdout, "%d file(s) changed while being copied %s:\n", len(result.Stats.Changed), outcome)
+		for _, source := range result.Stats.Changed {
+			fmt.Fprintf(stdout, "  %s\n", source)
+		}
+	}
 	return nil
 }
 
-func parseArgs(args []string, stderr io.Writer) (options, []string, string, error) {
-	opts := options{
-		bufferSize: defaultBufferSize,
+// printPlan lists what a copy would do, for --dry-run.
+func printPlan(writer io.Writer, plan copier.Plan) {
+	for _, op := range plan.Operations {
+		if op.Kind == copier.OperationCreateDirectory {
+			fmt.Fprintf(writer, "mkdir %s\n", op.Destination)
+			continue
+		}
+		if op.LinkFrom != "" {
+			fmt.Fprintf(writer, "link  %s -> %s (unchanged, %s)\n", op.LinkFrom, op.Destination, humanizeBytes(op.Size))
+			continue
+		}
+		fmt.Fprintf(writer, "copy  %s -> %s (%s)\n", op.Source, op.Destination, humanizeBytes(op.Size))
+	}
+
+	total := humanizeBytes(plan.TotalBytes)
+	if plan.SizeUnknown {
+		total = "at least " + total
+	}
+	fmt.Fprintf(writer, "Would copy %d file(s), %s total.\n", plan.Files()-plan.Linked, total)
+	if len(plan.Renamed) > 0 {
+		fmt.Fprintf(writer, "Would rename %d name(s) for the destination filesystem.\n", len(plan.Renamed))
+	}
+	if plan.Linked > 0 {
+		fmt.Fprintf(writer, "Would link %d unchanged file(s), %s.\n", plan.Linked, humanizeBytes(plan.LinkedBytes))
 	}
+	if filtered := formatFiltered(plan.Filtered); filtered != "" {
+		fmt.Fprintln(writer, filtered)
+	}
+}
+
+// printPlanProblems lists the names or conflicting paths that kept a plan
+// from being built, if err reports them.
+func printPlanProblems(writer io.Writer, err error) {
+	var nameErr *copier.NameError
+	if errors.As(err, &nameErr) {
+		for _, problem := range nameErr.Problems {
+			if problem.Kind == copier.NameCaseCollision {
+				fmt.Fprintf(writer, "%-9s %s (same as %s)\n", problem.Kind, problem.Destination, problem.Other)
+				continue
+			}
+			fmt.Fprintf(writer, "%-9s %s\n", problem.Kind, problem.Destination)
+		}
+	}
+
+	var conflictErr *copier.ConflictError
+	if errors.As(err, &conflictErr) {
+		for _, conflict := range conflictErr.Conflicts {
+			fmt.Fprintf(writer, "%-9s %s (from %s)\n", conflict.Kind, conflict.Destination, strings.Join(conflict.Sources, ", "))
+		}
+	}
+}
+
+// subcommandNote explains how the queue and completion subcommands are told
+// apart from copies, for the usage message and the man page.
+const subcommandNote = "Write a SOURCE named queue or completion as ./queue or ./completion;\n" +
+	"while such a file is in the current directory, the subcommands refuse to run."
 
+// checkSubcommand refuses to run the subcommand name when the command line
+// could equally be a copy of the file name.
+func checkSubcommand(name string) error {
+	if _, err := os.Lstat(name); err == nil {
+		return fmt.Errorf("%q is both a subcommand and a file here; write ./%s to copy the file", name, name)
+	}
+	return nil
+}
+
+// synopses are the ways zcp is run, for the usage message and the man page.
+var synopses = []struct {
+	command string
+	note    string
+}{
+	{command: "zcp [options] SOURCE... DEST"},
+	{command: "zcp [options] SOURCE... [user@]host:DEST"},
+	{command: "zcp [options] --files-from FILE [SOURCE...] DEST"},
+	{command: "zcp --compare [--checksum] [options] SOURCE... DEST"},
+	{command: "zcp --check-manifest FILE [options] DIR"},
+	{command: "zcp --restore-names FILE DIR"},
+	{command: "zcp --print-config [--profile NAME] [options]"},
+	{command: "zcp --queue [options] SOURCE... DEST"},
+	{command: "zcp queue ls|daemon"},
+	{command: "zcp queue pause|resume|cancel|attach ID"},
+	{command: "zcp completion bash|zsh|fish"},
+	{command: "zcp --man"},
+	{command: "zcp [options] - DEST", note: "copy standard input"},
+	{command: "zcp [options] SOURCE... -", note: "copy to standard output"},
+}
+
+// flagValues holds the options parseArgs checks and converts after parsing.
+type flagValues struct {
+	bufferSize   *bufferSizeFlag
+	streamSize   *sizeFlag
+	minSize      *sizeFlag
+	maxSize      *sizeFlag
+	compress     *string
+	targetFS     *string
+	newerThan    *string
+	olderThan    *string
+	dedupe       *string
+	order        *string
+	onChange     *string
+	fsync        *string
+	profile      *string
+	termProgress *string
+}
+
+// newFlagSet defines every option, setting opts or the returned values when
+// parsed. Completion scripts and the man page are generated from it too.
+func newFlagSet(opts *options, stderr io.Writer) (*flag.FlagSet, *flagValues) {
+	values := &flagValues{}
 	fs := flag.NewFlagSet("zcp", flag.ContinueOnError)
 	fs.SetOutput(stderr)
 
-	fs.BoolVar(&opts.recursive, "r", false, "copy directories recursively")
-	fs.BoolVar(&opts.recursive, "recursive", false, "copy directories recursively")
-	fs.BoolVar(&opts.force, "f", false, "overwrite destination files if they already exist")
-	fs.BoolVar(&opts.force, "force", false, "overwrite destination files if they already exist")
-	fs.BoolVar(&opts.preserve, "p", false, "preserve file mode and modification time")
-	fs.BoolVar(&opts.preserve, "preserve", false, "preserve file mode and modification time")
+	fs.BoolVar(&opts.Recursive, "r", false, "copy directories recursively")
+	fs.BoolVar(&opts.Recursive, "recursive", false, "copy directories recursively")
+	fs.BoolVar(&opts.Force, "f", false, "overwrite destination files if they already exist")
+	fs.BoolVar(&opts.Force, "force", false, "overwrite destination files if they already exist")
+	fs.BoolVar(&opts.Preserve, "p", false, "preserve file mode and modification time")
+	fs.BoolVar(&opts.Preserve, "preserve", false, "preserve file mode and modification time")
 	fs.BoolVar(&opts.quiet, "q", false, "disable progress output")
 	fs.BoolVar(&opts.quiet, "quiet", false, "disable progress output")
 	fs.BoolVar(&opts.verbose, "v", false, "print created file names")
 	fs.BoolVar(&opts.verbose, "verbose", false, "print created file names")
-	fs.IntVar(&opts.bufferSize, "buffer-size", defaultBufferSize, "copy buffer size in bytes")
+	values.bufferSize = &bufferSizeFlag{size: copier.DefaultBufferSize}
+	fs.Var(values.bufferSize, "buffer-size", "copy buffer size in `bytes`, or auto to size it per file")
+	fs.BoolVar(&opts.FromArchive, "from-archive", false, "treat each SOURCE as a .tar, .tar.gz, .tar.zst or .zip archive")
+	fs.BoolVar(&opts.ToArchive, "to-archive", false, "write DEST as a .tar, .tar.gz, .tar.zst or .zip archive")
+	values.compress = fs.String("compress", "", "compress each destination file with `gzip|zstd`, adding its suffix")
+	fs.BoolVar(&opts.Decompress, "decompress", false, "decompress .gz and .zst source files, removing the suffix")
+	fs.BoolVar(&opts.SkipSpaceCheck, "no-space-check", false, "do not check that DEST has enough free space first")
+	fs.BoolVar(&opts.NoPreallocate, "no-preallocate", false, "do not reserve space for each file before writing it")
+	fs.BoolVar(&opts.Direct, "direct", false, "bypass the page cache when reading and writing local files")
+	values.streamSize = &sizeFlag{}
+	fs.Var(values.streamSize, "size", "expected `SIZE` of a - (standard input) source, for a progress bar with an ETA")
+	fs.BoolVar(&opts.compare, "compare", false, "compare DEST with SOURCE instead of copying, failing if they differ")
+	fs.BoolVar(&opts.Checksum, "checksum", false, "with --compare, also compare the contents of files of equal size")
+	fs.StringVar(&opts.manifest, "manifest", "", "write the SHA-256 hash of every copied file to `FILE`, computed while copying")
+	fs.StringVar(&opts.manifestFormat, "manifest-format", "", "manifest format: `sha256sum|json` (default json for .json files)")
+	fs.StringVar(&opts.checkManifest, "check-manifest", "", "check the files listed in manifest `FILE` inside DIR instead of copying")
+	values.targetFS = fs.String("target-fs", "auto", "check destination names against `auto|fat|exfat|ntfs|smb|posix` rules before copying")
+	fs.StringVar(&opts.sanitizeMap, "sanitize", "", "rename names the target filesystem cannot hold, writing the original names to `FILE`")
+	fs.StringVar(&opts.restoreNames, "restore-names", "", "undo the renames listed in --sanitize `FILE` inside DIR instead of copying")
+	fs.StringVar(&opts.logFile, "log-file", "", "append a record of every directory, file and error of the copy to `PATH`")
+	fs.StringVar(&opts.logFormat, "log-format", "text", "log file format: `text|json`")
+	fs.BoolVar(&opts.dryRun, "n", false, "print what would be copied without copying anything")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print what would be copied without copying anything")
+	fs.IntVar(&opts.Filter.MaxDepth, "max-depth", 0, "copy at most `N` levels below each source directory (0 for no limit)")
+	values.minSize = &sizeFlag{}
+	fs.Var(values.minSize, "min-size", "skip files in source directories smaller than `SIZE`")
+	values.maxSize = &sizeFlag{}
+	fs.Var(values.maxSize, "max-size", "skip files in source directories larger than `SIZE`")
+	values.newerThan = fs.String("newer-than", "", "only copy files modified after `WHEN` (a duration such as 7d, or a date)")
+	values.olderThan = fs.String("older-than", "", "only copy files modified before `WHEN` (a duration such as 7d, or a date)")
+	values.dedupe = fs.String("dedupe", "", "store files identical to one already copied as a `hardlink|reflink` to it")
+	fs.StringVar(&opts.LinkDest, "link-dest", "", "hard-link files unchanged since the snapshot in `DIR` instead of copying them (implies -p)")
+	values.order = fs.String("order", "walk", "copy files in `walk|inode|physical|size-asc|size-desc` order")
+	fs.BoolVar(&opts.Filter.OneFileSystem, "x", false, "do not cross into other filesystems below source directories")
+	fs.BoolVar(&opts.Filter.OneFileSystem, "one-file-system", false, "do not cross into other filesystems below source directories")
+	fs.StringVar(&opts.filesFrom, "files-from", "", "read sources from `FILE` (- for stdin), keeping their relative paths under DEST")
+	fs.BoolVar(&opts.null, "0", false, "file list entries are separated by NUL bytes")
+	fs.BoolVar(&opts.null, "null", false, "file list entries are separated by NUL bytes")
+	fs.IntVar(&opts.Retries, "retries", 0, "retry reads and writes failing with EIO, EAGAIN or ETIMEDOUT up to `N` times per file")
+	fs.DurationVar(&opts.RetryDelay, "retry-delay", time.Second, "wait before the first retry, doubling for each further one")
+	values.onChange = fs.String("on-change", "warn", "when a source changes while it is copied: `warn|retry|fail`")
+	values.fsync = fs.String("fsync", "none", "flush written data: `none|file|end` (each file and its directory, or once at the end)")
+	fs.IntVar(&opts.ssh.port, "ssh-port", 22, "SSH port for [user@]host:path destinations")
+	fs.StringVar(&opts.ssh.key, "ssh-key", "", "private key `FILE` for [user@]host:path destinations")
+	fs.StringVar(&opts.ssh.knownHosts, "ssh-known-hosts", "", "known_hosts `FILE` (default ~/.ssh/known_hosts)")
+	values.profile = fs.String("profile", "", "apply the options of `NAME` in the config file's [profile.NAME] table")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "print the effective settings and where each came from, and exit")
+	values.termProgress = fs.String("term-progress", "auto", "also show progress in the terminal's tab or taskbar and title: `auto|on|off`")
+	fs.BoolVar(&opts.man, "man", false, "print the zcp(1) man page in roff, and exit")
+	fs.BoolVar(&opts.queue, "queue", false, "run the copy in the queue daemon, after earlier copies to the same device")
 
 	fs.Usage = func() {
 		fmt.Fprintln(stderr, "zcp: copy files and directories with a progress bar")
 		fmt.Fprintln(stderr)
 		fmt.Fprintln(stderr, "Usage:")
-		fmt.Fprintln(stderr, "  zcp [options] SOURCE... DEST")
+		for _, synopsis := range synopses {
+			if synopsis.note == "" {
+				fmt.Fprintf(stderr, "  %s\n", synopsis.command)
+				continue
+			}
+			fmt.Fprintf(stderr, "  %-30s(%s)\n", synopsis.command, synopsis.note)
+		}
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, subcommandNote)
 		fmt.Fprintln(stderr)
 		fmt.Fprintln(stderr, "Options:")
 		fs.PrintDefaults()
 	}
+	return fs, values
+}
+
+func parseArgs(args []string, stderr io.Writer) (options, []string, string, error) {
+	return parseOptions(args, true, stderr)
+}
+
+// parseOptions parses args, and applies ZCP_OPTIONS and the config file when
+// defaults is set. Jobs run by the queue daemon already had them applied
+// when they were submitted.
+func parseOptions(args []string, defaults bool, stderr io.Writer) (options, []string, string, error) {
+	opts := options{}
+	fs, values := newFlagSet(&opts, stderr)
 
 	if err := fs.Parse(args); err != nil {
 		return options{}, nil, "", err
 	}
+	if defaults {
+		origins, err := applyDefaults(fs, args, values, &opts)
+		if err != nil {
+			return options{}, nil, "", err
+		}
+		if opts.printConfig {
+			opts.settings = effectiveSettings(fs, origins)
+		}
+	}
+
+	opts.StreamSize = values.streamSize.size
 
-	if opts.bufferSize <= 0 {
+	if values.bufferSize.auto {
+		opts.BufferSize = copier.BufferSizeAuto
+	} else if values.bufferSize.size <= 0 {
 		return options{}, nil, "", fmt.Errorf("buffer-size must be greater than 0")
+	} else {
+		opts.BufferSize = values.bufferSize.size
+	}
+
+	compression, err := copier.ParseCompression(*values.compress)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+	opts.Compress = compression
+	if opts.Compress != copier.CompressionNone && opts.Decompress {
+		return options{}, nil, "", fmt.Errorf("--compress and --decompress cannot be used together")
+	}
+	if opts.ToArchive && (opts.Compress != copier.CompressionNone || opts.Decompress) {
+		return options{}, nil, "", fmt.Errorf("--compress and --decompress cannot be used with --to-archive; name the archive .tar.gz or .tar.zst to compress it")
+	}
+
+	opts.Fsync, err = copier.ParseFsyncMode(*values.fsync)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+
+	if opts.Retries < 0 {
+		return options{}, nil, "", fmt.Errorf("retries must not be negative")
+	}
+	if opts.RetryDelay < 0 {
+		return options{}, nil, "", fmt.Errorf("retry-delay must not be negative")
+	}
+
+	opts.OnChange, err = copier.ParseChangePolicy(*values.onChange)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+
+	opts.Order, err = copier.ParseOrder(*values.order)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+
+	opts.Dedupe, err = copier.ParseDedupeMode(*values.dedupe)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+	// Unchanged files are recognized by their modification time and mode,
+	// so snapshots must keep them.
+	if opts.LinkDest != "" {
+		opts.Preserve = true
+	}
+
+	if opts.Filter.MaxDepth < 0 {
+		return options{}, nil, "", fmt.Errorf("max-depth must not be negative")
+	}
+	opts.Filter.MinSize = values.minSize.size
+	opts.Filter.MaxSize = values.maxSize.size
+	now := time.Now()
+	if *values.newerThan != "" {
+		if opts.Filter.NewerThan, err = parseTimeBound(*values.newerThan, now); err != nil {
+			return options{}, nil, "", err
+		}
+	}
+	if *values.olderThan != "" {
+		if opts.Filter.OlderThan, err = parseTimeBound(*values.olderThan, now); err != nil {
+			return options{}, nil, "", err
+		}
+	}
+
+	opts.Hash = opts.manifest != ""
+	if opts.manifestFormat != "" {
+		if _, err := copier.ParseManifestFormat(opts.manifestFormat); err != nil {
+			return options{}, nil, "", err
+		}
+	}
+
+	opts.TargetFS, err = copier.ParseTargetFS(*values.targetFS)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+	opts.Sanitize = opts.sanitizeMap != ""
+
+	opts.termProgress, err = parseTermProgress(*values.termProgress)
+	if err != nil {
+		return options{}, nil, "", err
+	}
+
+	if opts.logFormat != "text" && opts.logFormat != "json" {
+		return options{}, nil, "", fmt.Errorf("unsupported log format %q (expected text or json)", opts.logFormat)
 	}
 
 	remaining := fs.Args()
-	if len(remaining) < 2 {
+	if opts.printConfig || opts.man {
+		if len(remaining) != 0 {
+			fs.Usage()
+			return options{}, nil, "", fmt.Errorf("--print-config and --man take no SOURCE or DEST")
+		}
+		return opts, nil, "", nil
+	}
+	if opts.checkManifest != "" || opts.restoreNames != "" {
+		if len(remaining) != 1 {
+			fs.Usage()
+			return options{}, nil, "", fmt.Errorf("expected DIR")
+		}
+		return opts, nil, remaining[0], nil
+	}
+	if opts.queue {
+		if opts.compare || opts.dryRun || opts.checkManifest != "" || opts.restoreNames != "" {
+			return options{}, nil, "", fmt.Errorf("--queue only queues copies")
+		}
+		if opts.filesFrom != "" {
+			return options{}, nil, "", fmt.Errorf("--queue cannot be used with --files-from")
+		}
+	}
+	if opts.filesFrom != "" && len(remaining) < 1 {
+		fs.Usage()
+		return options{}, nil, "", fmt.Errorf("expected DEST")
+	}
+	if opts.filesFrom == "" && len(remaining) < 2 {
 		fs.Usage()
 		return options{}, nil, "", fmt.Errorf("expected at least one SOURCE and one DEST")
 	}
+	if opts.filesFrom == "-" && slices.Contains(remaining[:len(remaining)-1], "-") {
+		return options{}, nil, "", fmt.Errorf("--files-from - and a - SOURCE cannot both read standard input")
+	}
 
+	if opts.queue {
+		if opts.queueArgs, err = queueArgs(fs, remaining, now); err != nil {
+			return options{}, nil, "", err
+		}
+	}
 	return opts, remaining[:len(remaining)-1], remaining[len(remaining)-1], nil
 }
 
-func countFiles(plan []copyOperation) int {
-	count := 0
-	for _, op := range plan {
-		if op.kind == operationCopyFile {
-			count++
-		}
+// applyDefaults applies ZCP_OPTIONS and the config file to fs, which has
+// parsed args, returning where each option set came from.
+//
+// Options come from the command line, then ZCP_OPTIONS, then the chosen
+// profile, then the config file's defaults, each only setting what the ones
+// before left alone. The command line is parsed again after ZCP_OPTIONS so
+// its values win.
+func applyDefaults(fs *flag.FlagSet, args []string, values *flagValues, opts *options) (map[string]string, error) {
+	origins := map[string]string{}
+	setOrigins(fs, originCommandLine, origins)
+
+	envArgs, err := splitOptions(os.Getenv("ZCP_OPTIONS"))
+	if err != nil {
+		return nil, fmt.Errorf("ZCP_OPTIONS: %w", err)
+	}
+	if err := fs.Parse(envArgs); err != nil {
+		return nil, fmt.Errorf("ZCP_OPTIONS: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("ZCP_OPTIONS must only hold options, not %q", fs.Arg(0))
+	}
+	setOrigins(fs, originEnvironment, origins)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	opts.configPath = configPath()
+	configFile, err := readConfig(opts.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyConfig(fs, configFile, *values.profile, origins); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+// bufferSizeFlag is the --buffer-size value: a byte count or "auto".
+type bufferSizeFlag struct {
+	size int
+	auto bool
+}
+
+func (f *bufferSizeFlag) String() string {
+	if f.auto {
+		return "auto"
 	}
-	return count
+	return strconv.Itoa(f.size)
+}
+
+func (f *bufferSizeFlag) Set(value string) error {
+	if value == "auto" {
+		f.auto = true
+		return nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expected a number of bytes or auto")
+	}
+	f.size = size
+	f.auto = false
+	return nil
 }
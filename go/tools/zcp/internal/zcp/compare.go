@@ -0,0 +1,40 @@
+package zcp
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// runCompare checks an existing copy for --compare, listing every difference
+// and failing if there are any.
+func runCompare(opts options, sources []string, destination string, stdout io.Writer) error {
+	ctx, stop := interruptible(opts.termProgress)
+	defer stop()
+	observer := newProgressObserver(!opts.quiet, stdout, opts.termProgress)
+	comparison, err := copier.New(opts.Options, observer).Compare(ctx, sources, destination)
+	observer.stop()
+	if err != nil {
+		return err
+	}
+
+	printDifferences(stdout, comparison.Differences)
+
+	compared := fmt.Sprintf("Compared %d file(s)", comparison.Plan.Files())
+	if opts.Checksum {
+		compared += fmt.Sprintf(", hashing %s", humanizeBytes(comparison.BytesHashed))
+	}
+	if len(comparison.Differences) == 0 {
+		fmt.Fprintf(stdout, "%s: no differences.\n", compared)
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s: %d difference(s).\n", compared, len(comparison.Differences))
+	return fmt.Errorf("%s differs from its source", destination)
+}
+
+func printDifferences(writer io.Writer, differences []copier.Difference) {
+	for _, difference := range differences {
+		fmt.Fprintf(writer, "%-8s %s\n", difference.Kind, difference.Destination)
+	}
+}
@@ -0,0 +1,213 @@
+package zcp
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// optionDoc describes an option for completion scripts and the man page.
+type optionDoc struct {
+	// short is the option's one-letter alias, if it has one.
+	short string
+	long  string
+	// value names the option's argument, and is empty for booleans.
+	value        string
+	choices      []string
+	usage        string
+	defaultValue string
+}
+
+// completes says what a shell should offer for an option's argument.
+func (o optionDoc) completes() string {
+	switch {
+	case len(o.choices) > 0:
+		return "choices"
+	case o.value == "FILE" || o.value == "PATH":
+		return "file"
+	case o.value == "DIR":
+		return "directory"
+	}
+	return ""
+}
+
+// optionDocs lists the options defined on flags in name order, folding each
+// one-letter alias into its long option.
+func optionDocs(flags *flag.FlagSet) []optionDoc {
+	aliases := map[string]string{}
+	for short, long := range shortOptions {
+		aliases[long] = short
+	}
+
+	var docs []optionDoc
+	flags.VisitAll(func(f *flag.Flag) {
+		if shortOptions[f.Name] != "" {
+			return
+		}
+		value, usage := flag.UnquoteUsage(f)
+		doc := optionDoc{short: aliases[f.Name], long: f.Name, value: value, usage: usage}
+		if strings.Contains(value, "|") {
+			doc.choices = strings.Split(value, "|")
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			doc.defaultValue = f.DefValue
+		}
+		docs = append(docs, doc)
+	})
+	return docs
+}
+
+// queueCommands are the zcp queue subcommands.
+const queueCommands = "ls pause resume cancel attach daemon"
+
+// writeCompletion writes the completion script for shell.
+func writeCompletion(writer io.Writer, shell string) error {
+	flags, _ := newFlagSet(&options{}, io.Discard)
+	docs := optionDocs(flags)
+	switch shell {
+	case "bash":
+		return writeBashCompletion(writer, docs)
+	case "zsh":
+		return writeZshCompletion(writer, docs)
+	case "fish":
+		return writeFishCompletion(writer, docs)
+	default:
+		return fmt.Errorf("unsupported shell %q (expected bash, zsh or fish)", shell)
+	}
+}
+
+func writeBashCompletion(writer io.Writer, docs []optionDoc) error {
+	var script strings.Builder
+	var words []string
+	// Options whose arguments complete alike share a case, in the order of
+	// the first of them.
+	var actions []string
+	cases := map[string][]string{}
+	for _, doc := range docs {
+		if doc.short != "" {
+			words = append(words, "-"+doc.short)
+		}
+		words = append(words, "--"+doc.long)
+		if doc.value == "" {
+			continue
+		}
+		action := "return"
+		switch doc.completes() {
+		case "choices":
+			action = fmt.Sprintf(`mapfile -t COMPREPLY < <(compgen -W %q -- "$cur"); return`, strings.Join(doc.choices, " "))
+		case "file":
+			action = `mapfile -t COMPREPLY < <(compgen -f -- "$cur"); return`
+		case "directory":
+			action = `mapfile -t COMPREPLY < <(compgen -d -- "$cur"); return`
+		}
+		if cases[action] == nil {
+			actions = append(actions, action)
+		}
+		cases[action] = append(cases[action], "--"+doc.long)
+	}
+
+	script.WriteString("# bash completion for zcp, generated by zcp completion bash.\n\n")
+	script.WriteString("_zcp() {\n")
+	script.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	script.WriteString("\t# Bash splits --option=value at the =.\n")
+	script.WriteString("\tif [[ $cur == = ]]; then\n\t\tcur=\n")
+	script.WriteString("\telif [[ $prev == = ]]; then\n\t\tprev=${COMP_WORDS[COMP_CWORD-2]}\n\tfi\n\n")
+	script.WriteString("\tif [[ $COMP_CWORD -eq 2 && ${COMP_WORDS[1]} == completion ]]; then\n")
+	script.WriteString("\t\tmapfile -t COMPREPLY < <(compgen -W \"bash zsh fish\" -- \"$cur\")\n\t\treturn\n\tfi\n")
+	script.WriteString("\tif [[ $COMP_CWORD -eq 2 && ${COMP_WORDS[1]} == queue ]]; then\n")
+	fmt.Fprintf(&script, "\t\tmapfile -t COMPREPLY < <(compgen -W %q -- \"$cur\")\n\t\treturn\n\tfi\n\n", queueCommands)
+	script.WriteString("\tcase $prev in\n")
+	for _, action := range actions {
+		fmt.Fprintf(&script, "\t%s)\n\t\t%s\n\t\t;;\n", strings.Join(cases[action], "|"), action)
+	}
+	script.WriteString("\tesac\n\n")
+	script.WriteString("\tif [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(&script, "\t\tmapfile -t COMPREPLY < <(compgen -W %q -- \"$cur\")\n\t\treturn\n\tfi\n", strings.Join(words, " "))
+	script.WriteString("\t# SOURCE and DEST are paths.\n")
+	script.WriteString("\tmapfile -t COMPREPLY < <(compgen -f -- \"$cur\")\n")
+	script.WriteString("}\n\n")
+	script.WriteString("complete -o filenames -F _zcp zcp\n")
+
+	_, err := io.WriteString(writer, script.String())
+	return err
+}
+
+func writeZshCompletion(writer io.Writer, docs []optionDoc) error {
+	var script strings.Builder
+	script.WriteString("#compdef zcp\n")
+	script.WriteString("# zsh completion for zcp, generated by zcp completion zsh.\n\n")
+	script.WriteString("_zcp() {\n")
+	script.WriteString("\tif (( CURRENT == 3 )) && [[ $words[2] == completion ]]; then\n")
+	script.WriteString("\t\t_values shell bash zsh fish\n\t\treturn\n\tfi\n")
+	script.WriteString("\tif (( CURRENT == 3 )) && [[ $words[2] == queue ]]; then\n")
+	fmt.Fprintf(&script, "\t\t_values command %s\n\t\treturn\n\tfi\n\n", queueCommands)
+	script.WriteString("\t_arguments -S \\\n")
+
+	description := strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `'`, `'\''`)
+	for _, doc := range docs {
+		spec := "'--" + doc.long
+		if doc.short != "" {
+			spec = fmt.Sprintf("'(-%s --%s)'{-%s,--%s}'", doc.short, doc.long, doc.short, doc.long)
+		}
+		if doc.value != "" {
+			spec += "="
+		}
+		spec += "[" + description.Replace(doc.usage) + "]"
+		if doc.value != "" {
+			action := " "
+			switch doc.completes() {
+			case "choices":
+				action = "(" + strings.Join(doc.choices, " ") + ")"
+			case "file":
+				action = "_files"
+			case "directory":
+				action = "_files -/"
+			}
+			spec += ":" + strings.ReplaceAll(doc.value, ":", `\:`) + ":" + action
+		}
+		fmt.Fprintf(&script, "\t\t%s' \\\n", spec)
+	}
+	script.WriteString("\t\t'*:file:_files'\n")
+	script.WriteString("}\n\n")
+	// Loaded from $fpath, the file is the function body; sourced, it
+	// registers the function.
+	script.WriteString("if [[ $zsh_eval_context[-1] == loadautofunc ]]; then\n")
+	script.WriteString("\t_zcp \"$@\"\nelse\n\tcompdef _zcp zcp\nfi\n")
+
+	_, err := io.WriteString(writer, script.String())
+	return err
+}
+
+func writeFishCompletion(writer io.Writer, docs []optionDoc) error {
+	quote := func(text string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(text) + "'"
+	}
+
+	var script strings.Builder
+	script.WriteString("# fish completion for zcp, generated by zcp completion fish.\n\n")
+	script.WriteString("complete -c zcp -n '__fish_seen_subcommand_from completion; and test (count (commandline -opc)) -eq 2' -x -a 'bash zsh fish'\n")
+	fmt.Fprintf(&script, "complete -c zcp -n '__fish_seen_subcommand_from queue; and test (count (commandline -opc)) -eq 2' -x -a '%s'\n", queueCommands)
+	for _, doc := range docs {
+		line := "complete -c zcp"
+		if doc.short != "" {
+			line += " -s " + doc.short
+		}
+		line += " -l " + doc.long
+		switch {
+		case doc.value == "":
+		case doc.completes() == "choices":
+			line += " -x -a " + quote(strings.Join(doc.choices, " "))
+		case doc.completes() == "file":
+			line += " -r -F"
+		case doc.completes() == "directory":
+			line += " -x -a '(__fish_complete_directories)'"
+		default:
+			line += " -x"
+		}
+		fmt.Fprintf(&script, "%s -d %s\n", line, quote(doc.usage))
+	}
+
+	_, err := io.WriteString(writer, script.String())
+	return err
+}
@@ -0,0 +1,108 @@
+package zcp
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompletionAndManPage(t *testing.T) {
+	t.Parallel()
+
+	scripts := map[string]string{}
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var script bytes.Buffer
+		if err := writeCompletion(&script, shell); err != nil {
+			t.Fatalf("write %s completion: %v", shell, err)
+		}
+		scripts[shell] = script.String()
+	}
+	var page bytes.Buffer
+	if err := writeManPage(&page); err != nil {
+		t.Fatalf("write man page: %v", err)
+	}
+
+	// Bash offers options from a list of words.
+	bashWords := map[string]bool{}
+	for _, word := range strings.Fields(scripts["bash"]) {
+		bashWords[strings.Trim(word, `"`)] = true
+	}
+
+	flags, _ := newFlagSet(&options{}, io.Discard)
+	flags.VisitAll(func(f *flag.Flag) {
+		dashes := "--"
+		fishOption := "-l " + f.Name
+		if len(f.Name) == 1 {
+			dashes = "-"
+			fishOption = "-s " + f.Name
+		}
+		if !bashWords[dashes+f.Name] {
+			t.Errorf("expected option %s among the bash completion's words", f.Name)
+		}
+		for shell, want := range map[string]string{
+			"zsh":  dashes + f.Name,
+			"fish": fishOption + " ",
+			"man":  `\fB` + roffEscape(dashes+f.Name) + `\fR`,
+		} {
+			content := scripts[shell]
+			if shell == "man" {
+				content = page.String()
+			}
+			if !strings.Contains(content, want) {
+				t.Errorf("expected option %s in the %s output as %q", f.Name, shell, want)
+			}
+		}
+	})
+
+	// Arguments complete by their kind.
+	for shell, want := range map[string]string{
+		"bash": "--compress)\n\t\tmapfile -t COMPREPLY < <(compgen -W \"gzip zstd\"",
+		"zsh":  `'--link-dest=[`,
+		"fish": "-l manifest -r -F",
+	} {
+		if !strings.Contains(scripts[shell], want) {
+			t.Errorf("expected %q in the %s completion", want, shell)
+		}
+	}
+	if !strings.Contains(scripts["zsh"], `:DIR:_files -/'`) || !strings.Contains(scripts["zsh"], `'*:file:_files'`) {
+		t.Errorf("expected directory and path completion in the zsh completion")
+	}
+
+	for shell, args := range map[string][]string{"bash": {"-n"}, "zsh": {"-n"}, "fish": {"--no-execute"}} {
+		path, err := exec.LookPath(shell)
+		if err != nil {
+			continue
+		}
+		file := filepath.Join(t.TempDir(), "zcp."+shell)
+		if err := os.WriteFile(file, []byte(scripts[shell]), 0o644); err != nil {
+			t.Fatalf("write %s completion: %v", shell, err)
+		}
+		if output, err := exec.Command(path, append(args, file)...).CombinedOutput(); err != nil {
+			t.Errorf("%s rejects its completion: %v\n%s", shell, err, output)
+		}
+	}
+
+	if err := writeCompletion(io.Discard, "powershell"); err == nil {
+		t.Fatalf("expected an unsupported shell to be rejected")
+	}
+}
+
+func TestRoffEscape(t *testing.T) {
+	t.Parallel()
+
+	for text, want := range map[string]string{
+		"--max-depth":       `\-\-max\-depth`,
+		`C:\zcp`:            `C:\ezcp`,
+		".gz files":         `\&.gz files`,
+		"'quoted' at start": `\&'quoted' at start`,
+	} {
+		if got := roffEscape(text); got != want {
+			t.Fatalf("roffEscape(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package zcp
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// Where a setting came from, from lowest to highest precedence.
+const (
+	originDefault     = "default"
+	originConfig      = "config"
+	originEnvironment = "ZCP_OPTIONS"
+	originCommandLine = "command line"
+)
+
+// shortOptions maps each one-letter option to the long option it stands for.
+var shortOptions = map[string]string{
+	"r": "recursive",
+	"f": "force",
+	"p": "preserve",
+	"q": "quiet",
+	"v": "verbose",
+	"n": "dry-run",
+	"x": "one-file-system",
+	"0": "null",
+}
+
+// longName returns the long name of option.
+func longName(option string) string {
+	if long, ok := shortOptions[option]; ok {
+		return long
+	}
+	return option
+}
+
+// config is the contents of a config file: default options, and named
+// profiles of options that take precedence over them.
+type config struct {
+	path     string
+	defaults []configSetting
+	profiles map[string][]configSetting
+}
+
+// configSetting is one key = value line of a config file.
+type configSetting struct {
+	name  string
+	value string
+	line  int
+}
+
+// configPath returns where the config file is looked for:
+// $XDG_CONFIG_HOME/zcp/config.toml, falling back to the platform's user
+// config directory.
+func configPath() string {
+	directory := os.Getenv("XDG_CONFIG_HOME")
+	if directory == "" {
+		var err error
+		if directory, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(directory, "zcp", "config.toml")
+}
+
+// readConfig reads the config file at path. A missing file is an empty
+// config.
+func readConfig(path string) (config, error) {
+	if path == "" {
+		return config{}, nil
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config{path: path}, nil
+	}
+	if err != nil {
+		return config{}, fmt.Errorf("open config file: %w", err)
+	}
+	defer file.Close()
+
+	parsed, err := parseConfig(file)
+	if err != nil {
+		return config{}, fmt.Errorf("config file %s: %w", path, err)
+	}
+	parsed.path = path
+	return parsed, nil
+}
+
+// parseConfig parses the subset of TOML config files use: key = value lines
+// with string, integer and boolean values, and [profile.NAME] tables.
+func parseConfig(reader io.Reader) (config, error) {
+	parsed := config{profiles: map[string][]configSetting{}}
+	// profile is the table being read, empty for the defaults before any.
+	profile := ""
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			header, _, _ := strings.Cut(line, "#")
+			header = strings.TrimSpace(header)
+			name, ok := strings.CutPrefix(header, "[profile.")
+			if !ok || !strings.HasSuffix(name, "]") || !isBareKey(name[:len(name)-1]) {
+				return config{}, fmt.Errorf("line %d: expected a [profile.NAME] table", number)
+			}
+			name = name[:len(name)-1]
+			if _, ok := parsed.profiles[name]; ok {
+				return config{}, fmt.Errorf("line %d: profile %q is defined twice", number, name)
+			}
+			parsed.profiles[name] = nil
+			profile = name
+			seen = map[string]bool{}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !isBareKey(key) {
+			return config{}, fmt.Errorf("line %d: expected key = value", number)
+		}
+		if seen[key] {
+			return config{}, fmt.Errorf("line %d: %s is set twice", number, key)
+		}
+		seen[key] = true
+
+		value, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return config{}, fmt.Errorf("line %d: %s: %w", number, key, err)
+		}
+		setting := configSetting{name: key, value: value, line: number}
+		if profile == "" {
+			parsed.defaults = append(parsed.defaults, setting)
+		} else {
+			parsed.profiles[profile] = append(parsed.profiles[profile], setting)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return config{}, err
+	}
+	return parsed, nil
+}
+
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseConfigValue parses a TOML string, integer or boolean, followed by an
+// optional comment, into the text an option is set with.
+func parseConfigValue(text string) (string, error) {
+	var value string
+	var rest string
+	switch {
+	case strings.HasPrefix(text, `'`):
+		end := strings.IndexByte(text[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		value, rest = text[1:end+1], text[end+2:]
+	case strings.HasPrefix(text, `"`):
+		var err error
+		if value, rest, err = parseBasicString(text[1:]); err != nil {
+			return "", err
+		}
+	default:
+		value, rest, _ = strings.Cut(text, "#")
+		value = strings.TrimSpace(value)
+		if value != "true" && value != "false" {
+			digits := strings.ReplaceAll(value, "_", "")
+			if _, err := strconv.ParseInt(digits, 10, 64); err != nil {
+				return "", fmt.Errorf("unsupported value %q (expected a string, integer or boolean)", value)
+			}
+			value = strings.TrimPrefix(digits, "+")
+		}
+		rest = ""
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return value, nil
+}
+
+// parseBasicString parses a double-quoted TOML string after its opening
+// quote, returning it and the text after its closing quote.
+func parseBasicString(text string) (string, string, error) {
+	var value strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"':
+			return value.String(), text[i+1:], nil
+		case '\\':
+			if i+1 == len(text) {
+				return "", "", errors.New("unterminated string")
+			}
+			i++
+			switch text[i] {
+			case 'b':
+				value.WriteByte('\b')
+			case 't':
+				value.WriteByte('\t')
+			case 'n':
+				value.WriteByte('\n')
+			case 'f':
+				value.WriteByte('\f')
+			case 'r':
+				value.WriteByte('\r')
+			case '"', '\\':
+				value.WriteByte(text[i])
+			case 'u', 'U':
+				length := 4
+				if text[i] == 'U' {
+					length = 8
+				}
+				if i+length >= len(text) {
+					return "", "", errors.New("unterminated string")
+				}
+				code, err := strconv.ParseUint(text[i+1:i+1+length], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", "", fmt.Errorf("invalid escape \\%c in string", text[i])
+				}
+				value.WriteRune(rune(code))
+				i += length
+			default:
+				return "", "", fmt.Errorf("invalid escape \\%c in string", text[i])
+			}
+		default:
+			value.WriteByte(text[i])
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// splitOptions splits ZCP_OPTIONS into arguments at whitespace, like a
+// shell: single quotes keep everything up to the next one, and within double
+// quotes or outside quotes a backslash keeps the next character.
+func splitOptions(value string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArgument := false
+	var quote rune
+	escaped := false
+
+	for _, r := range value {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArgument = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArgument = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArgument {
+				args = append(args, current.String())
+				current.Reset()
+				inArgument = false
+			}
+		default:
+			current.WriteRune(r)
+			inArgument = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or trailing backslash")
+	}
+	if inArgument {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// applyConfig sets the options of the chosen profile, then the config file's
+// defaults, skipping those already set. origins maps the long name of every
+// option set so far to where it came from, and gains the ones set here.
+func applyConfig(flags *flag.FlagSet, parsed config, profile string, origins map[string]string) error {
+	type layer struct {
+		settings []configSetting
+		origin   string
+	}
+	var layers []layer
+	if profile != "" {
+		settings, ok := parsed.profiles[profile]
+		if !ok {
+			if parsed.path == "" {
+				return fmt.Errorf("unknown profile %q: no config file found", profile)
+			}
+			return fmt.Errorf("unknown profile %q in %s", profile, parsed.path)
+		}
+		layers = append(layers, layer{settings: settings, origin: "profile " + profile})
+	}
+	layers = append(layers, layer{settings: parsed.defaults, origin: originConfig})
+
+	for _, layer := range layers {
+		for _, setting := range layer.settings {
+			if err := checkConfigOption(flags, setting.name); err != nil {
+				return fmt.Errorf("config file %s: line %d: %w", parsed.path, setting.line, err)
+			}
+			if _, ok := origins[setting.name]; ok {
+				continue
+			}
+			if err := flags.Set(setting.name, setting.value); err != nil {
+				return fmt.Errorf("config file %s: line %d: %s: %w", parsed.path, setting.line, setting.name, err)
+			}
+			origins[setting.name] = layer.origin
+		}
+	}
+	return nil
+}
+
+func checkConfigOption(flags *flag.FlagSet, name string) error {
+	switch {
+	case shortOptions[name] != "":
+		return fmt.Errorf("use the long option name %s instead of %s", shortOptions[name], name)
+	case name == "profile" || name == "print-config" || name == "man":
+		return fmt.Errorf("%s cannot be set in the config file", name)
+	case flags.Lookup(name) == nil:
+		return fmt.Errorf("unknown option %q", name)
+	}
+	return nil
+}
+
+// setOrigins records origin for every option set on flags that has none yet.
+func setOrigins(flags *flag.FlagSet, origin string, origins map[string]string) {
+	flags.Visit(func(f *flag.Flag) {
+		if name := longName(f.Name); origins[name] == "" {
+			origins[name] = origin
+		}
+	})
+}
+
+// effectiveSetting is an option's value after every source of settings was
+// applied, for --print-config.
+type effectiveSetting struct {
+	name   string
+	value  string
+	origin string
+}
+
+// effectiveSettings lists the long options on flags with their values, in
+// the TOML syntax of a config file.
+func effectiveSettings(flags *flag.FlagSet, origins map[string]string) []effectiveSetting {
+	var settings []effectiveSetting
+	flags.VisitAll(func(f *flag.Flag) {
+		if shortOptions[f.Name] != "" || checkConfigOption(flags, f.Name) != nil {
+			return
+		}
+		value := f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch getter.Get().(type) {
+			case bool, int:
+			default:
+				value = strconv.Quote(value)
+			}
+		} else {
+			value = strconv.Quote(value)
+		}
+		origin := origins[f.Name]
+		if origin == "" {
+			origin = originDefault
+		}
+		settings = append(settings, effectiveSetting{name: f.Name, value: value, origin: origin})
+	})
+	return settings
+}
+
+// printConfig writes settings as a config file, noting where each value
+// came from.
+func printConfig(writer io.Writer, path string, settings []effectiveSetting) error {
+	if path == "" {
+		path = "(none)"
+	} else if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		path += " (not found)"
+	}
+	fmt.Fprintf(writer, "# Config file: %s\n", path)
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	for _, setting := range settings {
+		fmt.Fprintf(table, "%s = %s\t# %s\n", setting.name, setting.value, setting.origin)
+	}
+	return table.Flush()
+}
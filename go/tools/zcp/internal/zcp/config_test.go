@@ -0,0 +1,158 @@
+package zcp
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+
+	parsed, err := parseConfig(strings.NewReader(`
+# Defaults for every copy.
+buffer-size = "auto"
+preserve = true   # keep modification times
+retries = 1_0
+
+[profile.backup]
+fsync = 'file'
+log-file = "C:\\logs\\zcp \"nightly\".log\u00e9"
+
+[profile.empty] # nothing here
+`))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	want := []configSetting{
+		{name: "buffer-size", value: "auto", line: 3},
+		{name: "preserve", value: "true", line: 4},
+		{name: "retries", value: "10", line: 5},
+	}
+	if !slices.Equal(parsed.defaults, want) {
+		t.Fatalf("expected defaults %+v, got %+v", want, parsed.defaults)
+	}
+	want = []configSetting{
+		{name: "fsync", value: "file", line: 8},
+		{name: "log-file", value: `C:\logs\zcp "nightly".logé`, line: 9},
+	}
+	if !slices.Equal(parsed.profiles["backup"], want) {
+		t.Fatalf("expected backup profile %+v, got %+v", want, parsed.profiles["backup"])
+	}
+	if settings, ok := parsed.profiles["empty"]; !ok || len(settings) != 0 {
+		t.Fatalf("expected an empty profile, got %+v", parsed.profiles)
+	}
+
+	for _, content := range []string{
+		"retries",
+		"retries = 1.5",
+		"exclude = [\"*.tmp\"]",
+		"log-file = \"unterminated",
+		"log-file = \"bad \\q escape\"",
+		"order = 'walk' extra",
+		"retries = 1\nretries = 2",
+		"[profile.a]\n[profile.a]",
+		"[backup]",
+	} {
+		if _, err := parseConfig(strings.NewReader(content)); err == nil {
+			t.Fatalf("expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestSplitOptions(t *testing.T) {
+	t.Parallel()
+
+	got, err := splitOptions(` -p  --log-file 'my log.txt' --ssh-key "a \"b\"" c\ d` + "\t")
+	if err != nil {
+		t.Fatalf("split options: %v", err)
+	}
+	if want := []string{"-p", "--log-file", "my log.txt", "--ssh-key", `a "b"`, "c d"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got, err := splitOptions(`'' x`); err != nil || !slices.Equal(got, []string{"", "x"}) {
+		t.Fatalf("expected an empty argument to be kept, got %q, %v", got, err)
+	}
+
+	for _, value := range []string{`'open`, `"open`, `trailing\`} {
+		if _, err := splitOptions(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	t.Parallel()
+
+	newFlags := func() (*flag.FlagSet, *bool, *int, *string) {
+		flags := flag.NewFlagSet("zcp", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		preserve := flags.Bool("preserve", false, "")
+		flags.BoolVar(preserve, "p", false, "")
+		retries := flags.Int("retries", 0, "")
+		order := flags.String("order", "walk", "")
+		flags.String("profile", "", "")
+		return flags, preserve, retries, order
+	}
+	parsed := config{
+		path: "config.toml",
+		defaults: []configSetting{
+			{name: "preserve", value: "true", line: 1},
+			{name: "retries", value: "1", line: 2},
+			{name: "order", value: "inode", line: 3},
+		},
+		profiles: map[string][]configSetting{
+			"backup": {{name: "retries", value: "5", line: 5}},
+		},
+	}
+
+	flags, preserve, retries, order := newFlags()
+	if err := flags.Parse([]string{"-p=false", "--order", "size-asc"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	origins := map[string]string{}
+	setOrigins(flags, originCommandLine, origins)
+	if err := applyConfig(flags, parsed, "backup", origins); err != nil {
+		t.Fatalf("apply config: %v", err)
+	}
+	if *preserve || *retries != 5 || *order != "size-asc" {
+		t.Fatalf("expected the command line, then the profile, to win; got preserve=%v retries=%d order=%q", *preserve, *retries, *order)
+	}
+	wantOrigins := map[string]string{"preserve": originCommandLine, "order": originCommandLine, "retries": "profile backup"}
+	for name, want := range wantOrigins {
+		if origins[name] != want {
+			t.Fatalf("expected %s from %q, got %q", name, want, origins[name])
+		}
+	}
+
+	settings := effectiveSettings(flags, origins)
+	var lines []string
+	for _, setting := range settings {
+		lines = append(lines, setting.name+" = "+setting.value+" # "+setting.origin)
+	}
+	want := []string{`order = "size-asc" # command line`, "preserve = false # command line", "retries = 5 # profile backup"}
+	if !slices.Equal(lines, want) {
+		t.Fatalf("expected effective settings %q, got %q", want, lines)
+	}
+
+	for _, testCase := range []struct {
+		settings []configSetting
+		profile  string
+		want     string
+	}{
+		{profile: "nightly", want: `unknown profile "nightly"`},
+		{settings: []configSetting{{name: "p", value: "true", line: 7}}, want: "line 7: use the long option name preserve"},
+		{settings: []configSetting{{name: "profile", value: "backup", line: 7}}, want: "cannot be set in the config file"},
+		{settings: []configSetting{{name: "exclude", value: "*.tmp", line: 7}}, want: `unknown option "exclude"`},
+		{settings: []configSetting{{name: "retries", value: "many", line: 7}}, want: "line 7: retries:"},
+	} {
+		flags, _, _, _ := newFlags()
+		err := applyConfig(flags, config{path: "config.toml", defaults: testCase.settings}, testCase.profile, map[string]string{})
+		if err == nil || !strings.Contains(err.Error(), testCase.want) {
+			t.Fatalf("expected error containing %q, got %v", testCase.want, err)
+		}
+	}
+}
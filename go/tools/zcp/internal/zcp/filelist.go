@@ -0,0 +1,57 @@
+package zcp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readFileList reads the sources listed in path for --files-from, or in stdin
+// when path is "-". Entries are separated by newlines, or by NUL bytes with
+// null; empty entries are skipped.
+func readFileList(path string, null bool, stdin io.Reader) ([]string, error) {
+	reader := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open file list: %w", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	separator := byte('\n')
+	if null {
+		separator = 0
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, separator); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	var sources []string
+	for scanner.Scan() {
+		entry := scanner.Text()
+		if !null {
+			entry = strings.TrimSuffix(entry, "\r")
+		}
+		if entry != "" {
+			sources = append(sources, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read file list: %w", err)
+	}
+	return sources, nil
+}
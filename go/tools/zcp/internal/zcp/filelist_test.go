@@ -0,0 +1,69 @@
+package zcp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadFileList(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		input string
+		null  bool
+		want  []string
+	}{
+		{
+			name:  "newline_separated",
+			input: "a.txt\ndir/b.txt\n\nc d.txt",
+			want:  []string{"a.txt", "dir/b.txt", "c d.txt"},
+		},
+		{
+			name:  "crlf_line_endings",
+			input: "a.txt\r\nb.txt\r\n",
+			want:  []string{"a.txt", "b.txt"},
+		},
+		{
+			name:  "nul_separated",
+			input: "./a\nwith newline\x00./b.txt\x00",
+			null:  true,
+			want:  []string{"./a\nwith newline", "./b.txt"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := readFileList("-", testCase.null, strings.NewReader(testCase.input))
+			if err != nil {
+				t.Fatalf("read file list: %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Fatalf("got %q, want %q", got, testCase.want)
+			}
+		})
+	}
+
+	t.Run("reads_from_a_file", func(t *testing.T) {
+		t.Parallel()
+
+		listFile := filepath.Join(t.TempDir(), "list.txt")
+		if err := os.WriteFile(listFile, []byte("one\ntwo\n"), 0o644); err != nil {
+			t.Fatalf("write list: %v", err)
+		}
+
+		got, err := readFileList(listFile, false, nil)
+		if err != nil {
+			t.Fatalf("read file list: %v", err)
+		}
+		if !reflect.DeepEqual(got, []string{"one", "two"}) {
+			t.Fatalf("unexpected entries: %q", got)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package zcp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// dateLayouts are the absolute times parseTimeBound accepts, in local time
+// unless they carry a zone.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTimeBound parses a --newer-than or --older-than value: a duration
+// before now such as 36h, 7d or 2w, or a date such as 2024-05-01.
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if bound, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return bound, nil
+		}
+	}
+
+	age, err := parseAge(value)
+	if err != nil || age < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q (expected a duration such as 36h or 7d, or a date such as 2024-05-01)", value)
+	}
+	return now.Add(-age), nil
+}
+
+// parseAge extends time.ParseDuration with d (days) and w (weeks) suffixes.
+func parseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// formatFiltered describes what filters left out of a plan, or returns "" if
+// nothing was.
+func formatFiltered(counts copier.FilterCounts) string {
+	if counts.Total() == 0 {
+		return ""
+	}
+
+	var reasons []string
+	for _, reason := range []struct {
+		count int
+		label string
+	}{
+		{count: counts.Depth, label: "beyond --max-depth"},
+		{count: counts.Size, label: "by size"},
+		{count: counts.Age, label: "by age"},
+		{count: counts.FileSystem, label: "on other filesystems"},
+	} {
+		if reason.count > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d %s", reason.count, reason.label))
+		}
+	}
+
+	line := fmt.Sprintf("Filtered out %d entry(ies): %s", counts.Total(), strings.Join(reasons, ", "))
+	if counts.Bytes > 0 {
+		line += fmt.Sprintf(" (%s of files)", humanizeBytes(counts.Bytes))
+	}
+	return line + "."
+}
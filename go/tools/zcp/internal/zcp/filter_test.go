@@ -0,0 +1,52 @@
+package zcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	testCases := []struct {
+		value string
+		want  time.Time
+	}{
+		{value: "36h", want: now.Add(-36 * time.Hour)},
+		{value: "7d", want: now.Add(-7 * 24 * time.Hour)},
+		{value: "1.5d", want: now.Add(-36 * time.Hour)},
+		{value: "2w", want: now.Add(-14 * 24 * time.Hour)},
+		{value: "2024-05-01", want: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{value: "2024-05-01 08:30", want: time.Date(2024, 5, 1, 8, 30, 0, 0, time.Local)},
+		{value: "2024-05-01T08:30:00Z", want: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)},
+	}
+	for _, testCase := range testCases {
+		got, err := parseTimeBound(testCase.value, now)
+		if err != nil || !got.Equal(testCase.want) {
+			t.Fatalf("parseTimeBound(%q) = %v, %v; want %v", testCase.value, got, err, testCase.want)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "-1h", "7x", "2024-13-01"} {
+		if _, err := parseTimeBound(value, now); err == nil {
+			t.Fatalf("expected parseTimeBound(%q) to fail", value)
+		}
+	}
+}
+
+func TestFormatFiltered(t *testing.T) {
+	t.Parallel()
+
+	if got := formatFiltered(copier.FilterCounts{}); got != "" {
+		t.Fatalf("expected nothing for an unfiltered plan, got %q", got)
+	}
+
+	got := formatFiltered(copier.FilterCounts{Depth: 1, Age: 2, Bytes: 2048})
+	want := "Filtered out 3 entry(ies): 1 beyond --max-depth, 2 by age (2.0 KiB of files)."
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,114 @@
+package zcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// operationLog appends a record of every step of a copy to --log-file, apart
+// from the progress display and -v output.
+type operationLog struct {
+	logger *slog.Logger
+	file   io.Closer
+	start  time.Time
+}
+
+func openOperationLog(path string, format string) (*operationLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log file: %w", err)
+	}
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(file, nil)
+	} else {
+		handler = slog.NewTextHandler(file, nil)
+	}
+	return &operationLog{logger: slog.New(handler), file: file}, nil
+}
+
+func (l *operationLog) Close() error {
+	return l.file.Close()
+}
+
+// started records the copy about to run.
+func (l *operationLog) started(args []string, sources []string, destination string) {
+	l.start = time.Now()
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "start",
+		slog.Any("args", args),
+		slog.Any("sources", sources),
+		slog.String("destination", destination),
+	)
+}
+
+// finished records the outcome of the copy.
+func (l *operationLog) finished(result copier.Result, err error) {
+	attrs := []slog.Attr{
+		slog.Int("files", result.Plan.Files()),
+		slog.Uint64("bytes_read", result.Stats.BytesRead),
+		slog.Uint64("bytes_written", result.Stats.BytesWritten),
+		slog.Duration("duration", time.Since(l.start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		l.logger.LogAttrs(context.Background(), slog.LevelError, "finish", attrs...)
+		return
+	}
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "finish", attrs...)
+}
+
+// observer wraps next so every event of the copy is logged as well.
+func (l *operationLog) observer(next copier.Observer) copier.Observer {
+	return logObserver{Observer: next, logger: l.logger}
+}
+
+type logObserver struct {
+	copier.Observer
+	logger *slog.Logger
+}
+
+// FileRetry passes a retry on to the wrapped observer, which may count
+// progress.
+func (o logObserver) FileRetry(op copier.Operation, n uint64) {
+	if retries, ok := o.Observer.(copier.RetryObserver); ok {
+		retries.FileRetry(op, n)
+	}
+}
+
+func (o logObserver) Event(event copier.Event) {
+	attrs := []slog.Attr{slog.String("source", event.Source)}
+	if event.Destination != "" {
+		attrs = append(attrs, slog.String("destination", event.Destination))
+	}
+
+	level := slog.LevelInfo
+	switch event.Kind {
+	case copier.EventMkdir:
+		attrs = append(attrs, slog.Duration("duration", event.Duration))
+	case copier.EventSkip:
+		attrs = append(attrs, slog.Uint64("size", event.Size), slog.String("reason", event.Reason))
+	case copier.EventError:
+		level = slog.LevelError
+		attrs = append(attrs, slog.Duration("duration", event.Duration), slog.String("error", event.Err.Error()))
+	default:
+		attrs = append(attrs,
+			slog.Uint64("size", event.Size),
+			slog.Uint64("written", event.Written),
+			slog.Duration("duration", event.Duration),
+		)
+		if event.LinkedTo != "" {
+			attrs = append(attrs, slog.String("linked_to", event.LinkedTo))
+		}
+		if event.SHA256 != "" {
+			attrs = append(attrs, slog.String("sha256", event.SHA256))
+		}
+	}
+	o.logger.LogAttrs(context.Background(), level, string(event.Kind), attrs...)
+}
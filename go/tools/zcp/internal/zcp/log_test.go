@@ -0,0 +1,94 @@
+package zcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+func TestOperationLog(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	sourceRoot := filepath.Join(tempDir, "source")
+	destinationRoot := filepath.Join(tempDir, "dest")
+	for path, content := range map[string]string{
+		filepath.Join(sourceRoot, "nested", "small.txt"): "small",
+		filepath.Join(sourceRoot, "big.txt"):             "much too big",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	// Both copies go to dest/source, so the second overwrites the first.
+	if err := os.Mkdir(destinationRoot, 0o755); err != nil {
+		t.Fatalf("create destination: %v", err)
+	}
+	logPath := filepath.Join(tempDir, "zcp.log")
+	opts := copier.Options{Recursive: true, Force: true, Hash: true, Filter: copier.Filter{MaxSize: 8}}
+	for range 2 {
+		log, err := openOperationLog(logPath, "json")
+		if err != nil {
+			t.Fatalf("open log: %v", err)
+		}
+		log.started([]string{"-r", "source", "dest"}, []string{sourceRoot}, destinationRoot)
+		result, err := copier.New(opts, log.observer(copier.NopObserver{})).Copy(context.Background(), []string{sourceRoot}, destinationRoot)
+		log.finished(result, err)
+		if err := log.Close(); err != nil {
+			t.Fatalf("close log: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+	}
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	defer file.Close()
+
+	var messages []string
+	records := map[string]map[string]any{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("parse log line %q: %v", scanner.Text(), err)
+		}
+		if _, ok := record["time"]; !ok {
+			t.Fatalf("expected a timestamp in %v", record)
+		}
+		message := record["msg"].(string)
+		messages = append(messages, message)
+		records[message] = record
+	}
+
+	// The log is appended to.
+	want := []string{"start", "skip", "mkdir", "mkdir", "copy", "finish"}
+	want = append(want, "start", "skip", "mkdir", "mkdir", "overwrite", "finish")
+	if !slices.Equal(messages, want) {
+		t.Fatalf("expected records %v, got %v", want, messages)
+	}
+
+	overwrite := records["overwrite"]
+	if overwrite["size"] != 5.0 || overwrite["written"] != 5.0 || len(overwrite["sha256"].(string)) != 64 {
+		t.Fatalf("expected size, bytes written and hash in %v", overwrite)
+	}
+	if skip := records["skip"]; skip["reason"] != "size" || skip["size"] != 12.0 {
+		t.Fatalf("expected the skip reason and size in %v", skip)
+	}
+	if finish := records["finish"]; finish["files"] != 1.0 || finish["level"] != "INFO" {
+		t.Fatalf("expected a successful finish in %v", finish)
+	}
+}
@@ -0,0 +1,86 @@
+package zcp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeManPage writes the zcp(1) man page in roff.
+func writeManPage(writer io.Writer) error {
+	flags, _ := newFlagSet(&options{}, io.Discard)
+
+	var page strings.Builder
+	page.WriteString(".TH ZCP 1 \"\" \"zcp\" \"User Commands\"\n")
+	page.WriteString(".SH NAME\n")
+	page.WriteString("zcp \\- copy files and directories with a progress bar\n")
+
+	page.WriteString(".SH SYNOPSIS\n.nf\n")
+	for _, synopsis := range synopses {
+		command, _ := strings.CutPrefix(synopsis.command, "zcp")
+		fmt.Fprintf(&page, "\\fBzcp\\fR%s\n", roffEscape(command))
+	}
+	page.WriteString(".fi\n")
+
+	page.WriteString(".SH DESCRIPTION\n")
+	page.WriteString(roffEscape("zcp copies files and directories, locally or to [user@]host:path over SFTP, "+
+		"showing progress, throughput and an estimated time left. "+
+		"A - SOURCE reads standard input and a - DEST writes to standard output.") + "\n")
+	page.WriteString(".PP\n")
+	page.WriteString(roffEscape("Options must come before SOURCE and DEST. "+
+		"They can be written with one dash or two, and take their value as the next argument or after =.") + "\n")
+	page.WriteString(".PP\n")
+	page.WriteString(roffEscape(subcommandNote) + "\n")
+
+	page.WriteString(".SH OPTIONS\n")
+	for _, doc := range optionDocs(flags) {
+		page.WriteString(".TP\n")
+		if doc.short != "" {
+			fmt.Fprintf(&page, "\\fB%s\\fR, ", roffEscape("-"+doc.short))
+		}
+		fmt.Fprintf(&page, "\\fB%s\\fR", roffEscape("--"+doc.long))
+		if doc.value != "" {
+			fmt.Fprintf(&page, "=\\fI%s\\fR", roffEscape(doc.value))
+		}
+		page.WriteString("\n")
+		usage := doc.usage
+		if doc.defaultValue != "" {
+			usage += " (default " + doc.defaultValue + ")"
+		}
+		page.WriteString(roffEscape(usage) + "\n")
+	}
+
+	page.WriteString(".SH ENVIRONMENT\n")
+	page.WriteString(".TP\n.B ZCP_OPTIONS\n")
+	page.WriteString(roffEscape("Options applied to every run, split like a shell would. Options on the command line take precedence.") + "\n")
+	page.WriteString(".TP\n.B ZCP_QUEUE_SOCKET\n")
+	page.WriteString(roffEscape("The Unix socket of the --queue daemon, by default $XDG_RUNTIME_DIR/zcp-queue.sock. "+
+		"The queue is per user: copies by different users are not serialized with each other.") + "\n")
+	page.WriteString(".TP\n.B XDG_CONFIG_HOME\n")
+	page.WriteString(roffEscape("The directory holding zcp/config.toml, by default ~/.config.") + "\n")
+
+	page.WriteString(".SH FILES\n")
+	page.WriteString(".TP\n.I $XDG_CONFIG_HOME/zcp/config.toml\n")
+	page.WriteString(roffEscape("Default options as key = value lines keyed by long option name, "+
+		"and [profile.NAME] tables of options that --profile NAME applies. "+
+		"Options from the command line and ZCP_OPTIONS take precedence; --print-config shows the result.") + "\n")
+
+	page.WriteString(".SH EXIT STATUS\n")
+	page.WriteString(roffEscape("0 on success, and 1 on any error, or when --compare or --check-manifest finds differences.") + "\n")
+
+	page.WriteString(".SH SEE ALSO\n")
+	page.WriteString(".BR cp (1),\n.BR rsync (1),\n.BR scp (1),\n.BR sha256sum (1)\n")
+
+	_, err := io.WriteString(writer, page.String())
+	return err
+}
+
+// roffEscape escapes text for a roff text line.
+func roffEscape(text string) string {
+	text = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(text)
+	// Lines starting with a period or quote are requests.
+	if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+		text = `\&` + text
+	}
+	return text
+}
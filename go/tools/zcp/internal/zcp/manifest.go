@@ -0,0 +1,95 @@
+package zcp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// manifestFormat returns the --manifest-format value, or the format implied
+// by the manifest's extension when none was given.
+func manifestFormat(path string, value string) (copier.ManifestFormat, error) {
+	if value != "" {
+		return copier.ParseManifestFormat(value)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return copier.ManifestJSON, nil
+	}
+	return copier.ManifestSHA256Sum, nil
+}
+
+// manifestRoot is the directory manifest paths are relative to: DEST, or
+// its parent when DEST is the copied file itself. Archive entries are
+// already relative.
+func manifestRoot(opts options, plan copier.Plan, destination string) string {
+	if opts.ToArchive {
+		return ""
+	}
+	for _, op := range plan.Operations {
+		if op.Kind == copier.OperationCopyFile && op.Destination == destination {
+			return filepath.Dir(destination)
+		}
+	}
+	return destination
+}
+
+// writeManifest records the hashes of a finished copy for --manifest.
+func writeManifest(opts options, result copier.Result, destination string) error {
+	format, err := manifestFormat(opts.manifest, opts.manifestFormat)
+	if err != nil {
+		return err
+	}
+	entries, err := copier.NewManifest(result.Stats.Hashes, manifestRoot(opts, result.Plan, destination))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(opts.manifest)
+	if err != nil {
+		return fmt.Errorf("create manifest: %w", err)
+	}
+	if err := copier.WriteManifest(file, entries, format); err != nil {
+		file.Close()
+		return fmt.Errorf("write manifest %q: %w", opts.manifest, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write manifest %q: %w", opts.manifest, err)
+	}
+	return nil
+}
+
+// runCheckManifest validates directory against the manifest for
+// --check-manifest, listing every mismatch and failing if there are any.
+func runCheckManifest(opts options, directory string, stdout io.Writer) error {
+	file, err := os.Open(opts.checkManifest)
+	if err != nil {
+		return fmt.Errorf("open manifest: %w", err)
+	}
+	entries, err := copier.ReadManifest(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("read manifest %q: %w", opts.checkManifest, err)
+	}
+
+	ctx, stop := interruptible(opts.termProgress)
+	defer stop()
+	observer := newProgressObserver(!opts.quiet, stdout, opts.termProgress)
+	check, err := copier.New(opts.Options, observer).CheckManifest(ctx, entries, directory)
+	observer.stop()
+	if err != nil {
+		return err
+	}
+
+	printDifferences(stdout, check.Differences)
+	checked := fmt.Sprintf("Checked %d file(s), hashing %s", check.Files, humanizeBytes(check.BytesHashed))
+	if len(check.Differences) == 0 {
+		fmt.Fprintf(stdout, "%s: all match.\n", checked)
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s: %d mismatch(es).\n", checked, len(check.Differences))
+	return fmt.Errorf("%s does not match manifest %q", directory, opts.checkManifest)
+}
@@ -0,0 +1,49 @@
+package zcp
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// writeNameMap records the renames of a sanitized copy for --sanitize.
+func writeNameMap(name string, renamed []copier.RenamedPath) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("create name map: %w", err)
+	}
+	if err := copier.WriteNameMap(file, renamed); err != nil {
+		file.Close()
+		return fmt.Errorf("write name map %q: %w", name, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write name map %q: %w", name, err)
+	}
+	return nil
+}
+
+// runRestoreNames undoes the renames in a --sanitize name map inside
+// directory for --restore-names.
+func runRestoreNames(mapName string, directory string, stdout io.Writer) error {
+	file, err := os.Open(mapName)
+	if err != nil {
+		return fmt.Errorf("open name map: %w", err)
+	}
+	renamed, err := copier.ReadNameMap(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("read name map %q: %w", mapName, err)
+	}
+
+	missing, err := copier.RestoreNames(renamed, directory)
+	for _, name := range missing {
+		fmt.Fprintf(stdout, "missing  %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Restored %d name(s).\n", len(renamed)-len(missing))
+	return nil
+}
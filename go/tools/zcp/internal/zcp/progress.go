@@ -5,23 +5,38 @@ import (
 	"io"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
 )
 
 type progressBar struct {
-	total      uint64
-	startedAt  time.Time
-	completed  atomic.Uint64
-	enabled    bool
-	writer     io.Writer
-	terminal   bool
-	stopCh     chan struct{}
-	stopOnce   sync.Once
-	waitGroup  sync.WaitGroup
-	lastRender int
+	total uint64
+	// indeterminate bars have no known total and show a spinner instead of
+	// a percentage.
+	indeterminate bool
+	frame         int
+	startedAt     time.Time
+	completed     atomic.Uint64
+	flushing      atomic.Bool
+	enabled       bool
+	writer        io.Writer
+	terminal      bool
+	stopCh        chan struct{}
+	stopOnce      sync.Once
+	waitGroup     sync.WaitGroup
+	lastRender    int
+	// status, when set, mirrors the progress in the terminal's tab and
+	// title, naming the current file.
+	status  *terminalStatus
+	current atomic.Pointer[string]
+	// base is what was copied before the bar started, following a copy
+	// already under way, which does not count towards the rate.
+	base uint64
 }
 
 func newProgressBar(total uint64, enabled bool, writer io.Writer) *progressBar {
@@ -34,6 +49,17 @@ func newProgressBar(total uint64, enabled bool, writer io.Writer) *progressBar {
 	return bar
 }
 
+// newIndeterminateProgressBar returns a bar for copies of unknown size, such
+// as from standard input, which shows the bytes copied and rate.
+func newIndeterminateProgressBar(enabled bool, writer io.Writer) *progressBar {
+	return &progressBar{
+		indeterminate: true,
+		enabled:       enabled,
+		writer:        writer,
+		terminal:      isTerminalWriter(writer),
+	}
+}
+
 func (p *progressBar) start() {
 	if !p.enabled {
 		return
@@ -84,13 +110,34 @@ func (p *progressBar) add(value uint64) {
 	p.completed.Add(value)
 }
 
+// takeBack subtracts value from the bytes completed.
+func (p *progressBar) takeBack(value uint64) {
+	if !p.enabled || value == 0 {
+		return
+	}
+	p.completed.Add(^(value - 1))
+}
+
+// setCurrent names the file being copied, for the terminal title.
+func (p *progressBar) setCurrent(name string) {
+	p.current.Store(&name)
+}
+
+// setFlushing marks whether the copy is waiting for data to reach stable
+// storage, which render shows after the ETA.
+func (p *progressBar) setFlushing(flushing bool) {
+	p.flushing.Store(flushing)
+}
+
 func (p *progressBar) render(final bool) {
 	done := p.completed.Load()
-	if done > p.total {
-		done = p.total
-	}
-	if final {
-		done = p.total
+	if !p.indeterminate {
+		if done > p.total {
+			done = p.total
+		}
+		if final {
+			done = p.total
+		}
 	}
 
 	elapsed := time.Since(p.startedAt)
@@ -98,8 +145,20 @@ func (p *progressBar) render(final bool) {
 		elapsed = time.Millisecond
 	}
 
-	bytesPerSecond := float64(done) / elapsed.Seconds()
-	line := formatProgressLine(done, p.total, bytesPerSecond)
+	bytesPerSecond := float64(done-min(done, p.base)) / elapsed.Seconds()
+	var line string
+	if p.indeterminate {
+		line = formatSpinnerLine(done, bytesPerSecond, p.frame, final)
+		p.frame++
+	} else {
+		line = formatProgressLine(done, p.total, bytesPerSecond)
+	}
+	if p.flushing.Load() && !final {
+		line += " flushing..."
+	}
+	if p.status != nil {
+		p.updateStatus(done, final)
+	}
 
 	if p.terminal {
 		padding := ""
@@ -117,6 +176,87 @@ func (p *progressBar) render(final bool) {
 	fmt.Fprintln(p.writer, line)
 }
 
+func (p *progressBar) updateStatus(done uint64, final bool) {
+	if final {
+		p.status.clear()
+		return
+	}
+
+	label := ""
+	if current := p.current.Load(); current != nil {
+		label = *current
+	}
+	if p.indeterminate {
+		p.status.update(0, true, humanizeBytes(done), label)
+		return
+	}
+	percent := int(done * 100 / p.total)
+	p.status.update(percent, false, fmt.Sprintf("%d%%", percent), label)
+}
+
+// progressObserver drives a progressBar from copier notifications. The bar is
+// created once the plan, and so the total, is known.
+type progressObserver struct {
+	copier.NopObserver
+	enabled bool
+	writer  io.Writer
+	bar     *progressBar
+	// terminalStatus also shows progress in the terminal's tab and title
+	// when writer is a terminal.
+	terminalStatus bool
+	// base is passed on to the bar.
+	base uint64
+}
+
+func newProgressObserver(enabled bool, writer io.Writer, terminalStatus bool) *progressObserver {
+	return &progressObserver{enabled: enabled, writer: writer, terminalStatus: terminalStatus}
+}
+
+func (o *progressObserver) PlanReady(plan copier.Plan) {
+	if plan.SizeUnknown {
+		o.bar = newIndeterminateProgressBar(o.enabled, o.writer)
+	} else {
+		o.bar = newProgressBar(plan.TotalBytes, o.enabled, o.writer)
+	}
+	if o.terminalStatus && o.bar.terminal {
+		o.bar.status = &terminalStatus{writer: o.writer}
+	}
+	o.bar.base = o.base
+	o.bar.start()
+}
+
+func (o *progressObserver) FileStart(op copier.Operation) {
+	name := op.Destination
+	if name == "-" {
+		name = op.Source
+	}
+	o.bar.setCurrent(filepath.Base(name))
+}
+
+func (o *progressObserver) Bytes(n uint64) {
+	o.bar.add(n)
+}
+
+// FileRetry takes back a file's bytes before it is copied again, so the bar
+// does not count them twice.
+func (o *progressObserver) FileRetry(_ copier.Operation, n uint64) {
+	o.bar.takeBack(n)
+}
+
+func (o *progressObserver) FlushStart() {
+	o.bar.setFlushing(true)
+}
+
+func (o *progressObserver) FlushDone() {
+	o.bar.setFlushing(false)
+}
+
+func (o *progressObserver) stop() {
+	if o.bar != nil {
+		o.bar.stop()
+	}
+}
+
 func formatProgressLine(done uint64, total uint64, bytesPerSecond float64) string {
 	if total == 0 {
 		return "[==============================] 100.00% 0 B/0 B 0 B/s ETA 00:00"
@@ -144,6 +284,17 @@ func formatProgressLine(done uint64, total uint64, bytesPerSecond float64) strin
 	)
 }
 
+// spinnerFrames animate the indeterminate progress line.
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+func formatSpinnerLine(done uint64, bytesPerSecond float64, frame int, final bool) string {
+	spinner := spinnerFrames[frame%len(spinnerFrames)]
+	if final {
+		spinner = "done"
+	}
+	return fmt.Sprintf("[%s] %s %s/s", spinner, humanizeBytes(done), humanizeRate(bytesPerSecond))
+}
+
 func buildBar(percentage float64, width int) string {
 	if width <= 0 {
 		return ""
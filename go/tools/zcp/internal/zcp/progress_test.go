@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
 )
 
 func TestProgressFormattingHelpers(t *testing.T) {
@@ -198,4 +200,53 @@ func TestProgressBarLifecycle(t *testing.T) {
 			t.Fatalf("expected no output for zero-total progress, got %q", output.String())
 		}
 	})
+
+	t.Run("indeterminate_shows_bytes_without_percentage", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		bar := newIndeterminateProgressBar(true, &output)
+		bar.start()
+		bar.add(2048)
+		bar.stop()
+
+		got := output.String()
+		if !strings.Contains(got, "[done] 2.0 KiB") || strings.Contains(got, "%") {
+			t.Fatalf("expected a final byte count without percentage, got %q", got)
+		}
+	})
+
+	t.Run("shows_flushing_phase", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		bar := newProgressBar(1024, true, &output)
+		bar.startedAt = time.Now()
+		bar.add(1024)
+		bar.setFlushing(true)
+		bar.render(false)
+		if !strings.Contains(output.String(), "flushing") {
+			t.Fatalf("expected flushing phase in output, got %q", output.String())
+		}
+
+		output.Reset()
+		bar.render(true)
+		if strings.Contains(output.String(), "flushing") {
+			t.Fatalf("expected no flushing phase in final output, got %q", output.String())
+		}
+	})
+	t.Run("retried_files_are_counted_once", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		observer := newProgressObserver(true, &output, false)
+		observer.PlanReady(copier.Plan{TotalBytes: 1024})
+		observer.Bytes(512)
+		observer.FileRetry(copier.Operation{}, 512)
+		observer.Bytes(1024)
+		if done := observer.bar.completed.Load(); done != 1024 {
+			t.Fatalf("expected 1024 bytes completed of 1024, got %d", done)
+		}
+		observer.stop()
+	})
 }
@@ -0,0 +1,457 @@
+package zcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// The queue daemon runs copies submitted with --queue, one at a time per
+// destination device, so copies to one disk do not compete for it while
+// copies to different disks run side by side. Clients talk to it over a Unix
+// socket, sending one JSON request per connection.
+
+// queueSocketEnv overrides where the queue daemon listens.
+const queueSocketEnv = "ZCP_QUEUE_SOCKET"
+
+// Queue daemon commands.
+const (
+	queueSubmit = "submit"
+	queueList   = "ls"
+	queuePause  = "pause"
+	queueResume = "resume"
+	queueCancel = "cancel"
+	queueAttach = "attach"
+	queueDaemon = "daemon"
+)
+
+// Job states.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobPaused   = "paused"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// jobFinished reports whether a job in state will not change anymore.
+func jobFinished(state string) bool {
+	return state == jobDone || state == jobFailed || state == jobCanceled
+}
+
+type queueRequest struct {
+	Command string `json:"command"`
+	ID      int    `json:"id,omitempty"`
+	// Args are the submitted copy's options and paths, for submit.
+	Args []string `json:"args,omitempty"`
+}
+
+// queueResponse answers a request, or is one of the updates streamed to an
+// attached client.
+type queueResponse struct {
+	Error string      `json:"error,omitempty"`
+	Jobs  []jobStatus `json:"jobs,omitempty"`
+	Job   *jobStatus  `json:"job,omitempty"`
+}
+
+// jobStatus describes a queued copy.
+type jobStatus struct {
+	ID          int      `json:"id"`
+	State       string   `json:"state"`
+	Sources     []string `json:"sources"`
+	Destination string   `json:"destination"`
+	Device      string   `json:"device"`
+	// After is the job a queued job waits for, which holds its device.
+	After int `json:"after,omitempty"`
+	// Planned is set once the copy's total is known.
+	Planned bool   `json:"planned,omitempty"`
+	Total   uint64 `json:"total"`
+	Done    uint64 `json:"done"`
+	Current string `json:"current,omitempty"`
+	// Output is the summary the copy printed, once it finished.
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// errNoQueueDaemon is returned when no daemon listens on the socket.
+var errNoQueueDaemon = errors.New("no queue daemon is running")
+
+// queueSocket returns the path of the queue daemon's socket, which is per
+// user: copies run as the user whose daemon runs them, so a daemon cannot
+// serve other users.
+func queueSocket() (string, error) {
+	if path := os.Getenv(queueSocketEnv); path != "" {
+		return path, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "zcp-queue.sock"), nil
+	}
+
+	// The temporary directory is shared, so make sure no one else made ours
+	// and could listen in it.
+	name := "zcp"
+	if uid := os.Getuid(); uid >= 0 {
+		name += "-" + strconv.Itoa(uid)
+	}
+	dir := filepath.Join(os.TempDir(), name)
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return "", err
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "queue.sock"), nil
+}
+
+// queueCommand is a zcp queue subcommand.
+type queueCommand struct {
+	name string
+	id   string
+}
+
+// parseQueueCommand recognizes zcp queue subcommands in args, leaving any
+// other args to be parsed as a copy.
+func parseQueueCommand(args []string) (queueCommand, bool) {
+	if len(args) < 2 || args[0] != "queue" {
+		return queueCommand{}, false
+	}
+	switch args[1] {
+	case queueList, queueDaemon:
+		return queueCommand{name: args[1]}, len(args) == 2
+	case queuePause, queueResume, queueCancel, queueAttach:
+		if len(args) != 3 {
+			return queueCommand{}, false
+		}
+		return queueCommand{name: args[1], id: args[2]}, true
+	}
+	return queueCommand{}, false
+}
+
+func runQueueCommand(command queueCommand, stdout io.Writer) error {
+	socket, err := queueSocket()
+	if err != nil {
+		return err
+	}
+	switch command.name {
+	case queueDaemon:
+		return runQueueDaemon(socket, stdout)
+	case queueList:
+		response, err := callQueue(socket, queueRequest{Command: queueList})
+		if errors.Is(err, errNoQueueDaemon) {
+			return printJobs(stdout, nil)
+		}
+		if err != nil {
+			return err
+		}
+		return printJobs(stdout, response.Jobs)
+	}
+
+	id, err := strconv.Atoi(command.id)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("invalid job ID %q", command.id)
+	}
+	if command.name == queueAttach {
+		termProgress, _ := parseTermProgress("auto")
+		return attachJob(socket, id, true, termProgress, stdout)
+	}
+	response, err := callQueue(socket, queueRequest{Command: command.name, ID: id})
+	if err != nil {
+		return err
+	}
+	// A running job's copy may take a moment to stop, so report what was
+	// asked rather than its state.
+	done := map[string]string{queuePause: "Paused", queueResume: "Resumed", queueCancel: "Canceled"}[command.name]
+	fmt.Fprintf(stdout, "%s job %d.\n", done, response.Job.ID)
+	return nil
+}
+
+// runQueued submits the copy to the queue daemon, starting it if needed,
+// and follows the job until it finishes. Ctrl-C detaches, leaving the job
+// running.
+func runQueued(opts options, sources []string, destination string, stdout io.Writer) error {
+	if _, ok := parseRemoteTarget(destination); ok {
+		return fmt.Errorf("--queue cannot copy to [user@]host:path destinations")
+	}
+	if destination == "-" || slices.Contains(sources, "-") {
+		return fmt.Errorf("--queue cannot copy standard input or to standard output")
+	}
+
+	socket, err := queueSocket()
+	if err != nil {
+		return err
+	}
+	request := queueRequest{Command: queueSubmit, Args: opts.queueArgs}
+	response, err := callQueue(socket, request)
+	if errors.Is(err, errNoQueueDaemon) {
+		if err = startQueueDaemon(socket); err != nil {
+			return err
+		}
+		response, err = callQueue(socket, request)
+	}
+	if err != nil {
+		return err
+	}
+
+	job := response.Job
+	fmt.Fprintf(stdout, "Queued job %d.\n", job.ID)
+	return attachJob(socket, job.ID, !opts.quiet, opts.termProgress, stdout)
+}
+
+// queueArgs returns the options set on fs and the paths in remaining as the
+// args to submit to the queue daemon, which runs in another directory and
+// environment, and maybe much later: options from ZCP_OPTIONS or the config
+// file are spelled out, paths are made absolute, and times relative to now
+// are made absolute too.
+func queueArgs(fs *flag.FlagSet, remaining []string, now time.Time) ([]string, error) {
+	var args []string
+	var err error
+	seen := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		name := longName(f.Name)
+		if err != nil || seen[name] {
+			return
+		}
+		seen[name] = true
+		switch name {
+		case "queue", "profile", "print-config", "man", "term-progress":
+			return
+		}
+
+		value := f.Value.String()
+		kind, _ := flag.UnquoteUsage(f)
+		switch {
+		case (kind == "FILE" || kind == "PATH" || kind == "DIR") && value != "" && value != "-":
+			value, err = filepath.Abs(value)
+		case kind == "WHEN" && value != "":
+			var bound time.Time
+			if bound, err = parseTimeBound(value, now); err == nil {
+				value = bound.Format(time.RFC3339Nano)
+			}
+		}
+		args = append(args, "--"+name+"="+value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, "--")
+	for _, path := range remaining {
+		if _, remote := parseRemoteTarget(path); path != "-" && !remote {
+			if path, err = filepath.Abs(path); err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, path)
+	}
+	return args, nil
+}
+
+// dialQueue connects to the queue daemon listening on socket.
+func dialQueue(socket string) (net.Conn, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("%w on %s", errNoQueueDaemon, socket)
+	}
+	return conn, nil
+}
+
+// callQueue sends request to the queue daemon and returns its response.
+func callQueue(socket string, request queueRequest) (queueResponse, error) {
+	conn, err := dialQueue(socket)
+	if err != nil {
+		return queueResponse{}, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return queueResponse{}, fmt.Errorf("send to the queue daemon: %w", err)
+	}
+	var response queueResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return queueResponse{}, fmt.Errorf("read from the queue daemon: %w", err)
+	}
+	if response.Error != "" {
+		return queueResponse{}, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// startQueueDaemon runs zcp queue daemon in the background, detached so it
+// outlives this run, and waits until it listens on socket.
+func startQueueDaemon(socket string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("start the queue daemon: %w", err)
+	}
+	command := exec.Command(executable, "queue", queueDaemon)
+	command.Env = append(os.Environ(), queueSocketEnv+"="+socket)
+	command.SysProcAttr = detachedProcess()
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("start the queue daemon: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- command.Wait() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if conn, err := dialQueue(socket); err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case err := <-exited:
+			// Another client may have started a daemon first.
+			if conn, dialErr := dialQueue(socket); dialErr == nil {
+				conn.Close()
+				return nil
+			}
+			return fmt.Errorf("the queue daemon exited (%v); run zcp queue daemon to see why", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the queue daemon did not start listening on %s", socket)
+		}
+	}
+}
+
+// attachJob follows job id's progress until it finishes, then prints its
+// summary and returns its error. Ctrl-C detaches without stopping the job.
+func attachJob(socket string, id int, progress bool, termProgress bool, stdout io.Writer) error {
+	conn, err := dialQueue(socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(queueRequest{Command: queueAttach, ID: id}); err != nil {
+		return fmt.Errorf("send to the queue daemon: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, func() { conn.Close() })
+
+	observer := newProgressObserver(progress, stdout, termProgress)
+	defer observer.stop()
+	decoder := json.NewDecoder(conn)
+	var job jobStatus
+	var done uint64
+	current := ""
+	for first := true; ; first = false {
+		var response queueResponse
+		if err := decoder.Decode(&response); err != nil {
+			observer.stop()
+			if ctx.Err() != nil {
+				fmt.Fprintf(stdout, "Detached from job %d, which keeps going; follow it again with zcp queue attach %d.\n", id, id)
+				return nil
+			}
+			return fmt.Errorf("read from the queue daemon: %w", err)
+		}
+		if response.Error != "" {
+			return errors.New(response.Error)
+		}
+		job = *response.Job
+
+		if first && progress && job.State == jobQueued && job.After != 0 {
+			fmt.Fprintf(stdout, "Waiting for job %d, which copies to the same device.\n", job.After)
+		}
+		if first && jobFinished(job.State) {
+			break
+		}
+		if job.Planned {
+			if observer.bar == nil {
+				observer.base = job.Done
+				observer.PlanReady(copier.Plan{TotalBytes: job.Total})
+			}
+			if job.Current != current {
+				current = job.Current
+				observer.FileStart(copier.Operation{Destination: current})
+			}
+			observer.Bytes(job.Done - done)
+			done = job.Done
+		}
+		if jobFinished(job.State) {
+			break
+		}
+	}
+
+	observer.stop()
+	io.WriteString(stdout, job.Output)
+	switch job.State {
+	case jobFailed:
+		return errors.New(job.Error)
+	case jobCanceled:
+		return fmt.Errorf("job %d was canceled", id)
+	}
+	return nil
+}
+
+// printJobs lists jobs as a table, for zcp queue ls.
+func printJobs(writer io.Writer, jobs []jobStatus) error {
+	if len(jobs) == 0 {
+		_, err := fmt.Fprintln(writer, "No queued jobs.")
+		return err
+	}
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSTATE\tPROGRESS\tDEVICE\tSOURCE\tDEST")
+	for _, job := range jobs {
+		fmt.Fprintf(
+			table,
+			"%d\t%s\t%s\t%s\t%s\t%s\n",
+			job.ID,
+			job.State,
+			formatJobProgress(job),
+			job.Device,
+			strings.Join(job.Sources, " "),
+			job.Destination,
+		)
+	}
+	return table.Flush()
+}
+
+func formatJobProgress(job jobStatus) string {
+	switch {
+	case job.After != 0:
+		return fmt.Sprintf("after job %d", job.After)
+	case !job.Planned:
+		return "-"
+	case job.Total == 0:
+		return "0 B"
+	}
+	done := min(job.Done, job.Total)
+	return fmt.Sprintf("%d%% of %s", done*100/job.Total, humanizeBytes(job.Total))
+}
+
+// destinationDevice names the device a copy to destination writes to: that
+// of destination, or of the nearest directory above it that exists.
+func destinationDevice(destination string) (string, error) {
+	path := destination
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			return deviceName(path, info), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, fs.ErrNotExist) || parent == path {
+			return "", err
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,492 @@
+package zcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+// queueIdleTimeout is how long the queue daemon waits without jobs or
+// clients before exiting.
+const queueIdleTimeout = time.Minute
+
+// queueJobRetention is how long a finished job stays listed, for its
+// submitter to see how it went.
+const queueJobRetention = 10 * time.Minute
+
+// attachInterval is how often attached clients are sent a job's progress.
+const attachInterval = 250 * time.Millisecond
+
+// jobQueue holds the jobs submitted to the queue and runs them, one at a
+// time per destination device.
+type jobQueue struct {
+	// ctx is canceled when the daemon stops, canceling running jobs.
+	ctx context.Context
+
+	mu   sync.Mutex
+	jobs []*queueJob
+	// lastID is the ID of the last job submitted. IDs are not reused once
+	// finished jobs are dropped.
+	lastID int
+	// resumed wakes the copies of paused jobs when they are resumed or
+	// canceled.
+	resumed *sync.Cond
+	// clients counts open connections, which keep the daemon from exiting
+	// when idle.
+	clients  int
+	lastBusy time.Time
+	running  sync.WaitGroup
+}
+
+// queueJob is a submitted copy. Its fields are guarded by the queue's
+// mutex, except done.
+type queueJob struct {
+	status      jobStatus
+	args        []string
+	opts        options
+	sources     []string
+	destination string
+	// active is set while the job's copy runs, paused or not.
+	active   bool
+	canceled bool
+	cancel   context.CancelFunc
+	done     atomic.Uint64
+	// finished is when the job finished, for pruning.
+	finished time.Time
+}
+
+func newJobQueue(ctx context.Context) *jobQueue {
+	q := &jobQueue{ctx: ctx, lastBusy: time.Now()}
+	q.resumed = sync.NewCond(&q.mu)
+	return q
+}
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// runQueueDaemon serves the queue on socket until it is idle for
+// queueIdleTimeout, or interrupted.
+func runQueueDaemon(socket string, stdout io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+	// The lock is held for as long as the daemon runs, so a second daemon
+	// cannot remove the socket of one starting or listening.
+	lock, err := lockFile(socket + ".lock")
+	if errors.Is(err, errLocked) {
+		return fmt.Errorf("a queue daemon is already running on %s", socket)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if conn, err := dialQueue(socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a queue daemon is already running on %s", socket)
+	}
+	// Nothing listens, so a socket left here is stale.
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(stdout, "Queue daemon listening on %s.\n", socket)
+	return newJobQueue(ctx).serve(listener, queueIdleTimeout)
+}
+
+// serve handles connections on listener until the daemon's context is done
+// or it has been idle for idleTimeout, then waits for running copies to
+// stop.
+func (q *jobQueue) serve(listener net.Listener, idleTimeout time.Duration) error {
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	q.ctx = ctx
+	context.AfterFunc(ctx, func() {
+		listener.Close()
+		q.mu.Lock()
+		q.resumed.Broadcast()
+		q.mu.Unlock()
+	})
+	go q.closeWhenIdle(ctx, cancel, idleTimeout)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			cancel()
+			q.running.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		q.mu.Lock()
+		q.clients++
+		q.mu.Unlock()
+		go q.handle(conn)
+	}
+}
+
+// closeWhenIdle calls cancel once no job is unfinished and no client is
+// connected for idleTimeout. Meanwhile it drops jobs that finished more than
+// queueJobRetention ago.
+func (q *jobQueue) closeWhenIdle(ctx context.Context, cancel context.CancelFunc, idleTimeout time.Duration) {
+	ticker := time.NewTicker(min(idleTimeout/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		q.prune(time.Now().Add(-queueJobRetention))
+		busy := q.clients > 0
+		for _, job := range q.jobs {
+			busy = busy || !jobFinished(job.status.State)
+		}
+		if busy {
+			q.lastBusy = time.Now()
+		}
+		idle := time.Since(q.lastBusy) >= idleTimeout
+		q.mu.Unlock()
+		if idle {
+			cancel()
+			return
+		}
+	}
+}
+
+func (q *jobQueue) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		q.mu.Lock()
+		q.clients--
+		q.lastBusy = time.Now()
+		q.mu.Unlock()
+	}()
+
+	var request queueRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		return
+	}
+	encoder := json.NewEncoder(conn)
+	if request.Command == queueAttach {
+		q.attach(encoder, request.ID)
+		return
+	}
+	encoder.Encode(q.respond(request))
+}
+
+func (q *jobQueue) respond(request queueRequest) queueResponse {
+	if request.Command == queueList {
+		return queueResponse{Jobs: q.list()}
+	}
+
+	var job jobStatus
+	var err error
+	switch request.Command {
+	case queueSubmit:
+		job, err = q.submit(request.Args)
+	case queuePause:
+		job, err = q.pause(request.ID)
+	case queueResume:
+		job, err = q.resume(request.ID)
+	case queueCancel:
+		job, err = q.cancel(request.ID)
+	default:
+		err = fmt.Errorf("unknown queue command %q", request.Command)
+	}
+	if err != nil {
+		return queueResponse{Error: err.Error()}
+	}
+	return queueResponse{Job: &job}
+}
+
+// submit queues a copy with args, as built by queueArgs.
+func (q *jobQueue) submit(args []string) (jobStatus, error) {
+	opts, sources, destination, err := parseOptions(args, false, io.Discard)
+	if err != nil {
+		return jobStatus{}, err
+	}
+	device, err := destinationDevice(destination)
+	if err != nil {
+		return jobStatus{}, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastID++
+	job := &queueJob{
+		status: jobStatus{
+			ID:          q.lastID,
+			State:       jobQueued,
+			Sources:     sources,
+			Destination: destination,
+			Device:      device,
+		},
+		args:        args,
+		opts:        opts,
+		sources:     sources,
+		destination: destination,
+	}
+	q.jobs = append(q.jobs, job)
+	q.schedule()
+	return q.statusOf(job), nil
+}
+
+func (q *jobQueue) list() []jobStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]jobStatus, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, q.statusOf(job))
+	}
+	return jobs
+}
+
+// pause holds a queued job back, or stops a running one from reading more.
+func (q *jobQueue) pause(id int) (jobStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.job(id)
+	if err != nil {
+		return jobStatus{}, err
+	}
+	if job.status.State != jobQueued && job.status.State != jobRunning {
+		return jobStatus{}, fmt.Errorf("job %d is %s", id, job.status.State)
+	}
+	job.status.State = jobPaused
+	return q.statusOf(job), nil
+}
+
+func (q *jobQueue) resume(id int) (jobStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.job(id)
+	if err != nil {
+		return jobStatus{}, err
+	}
+	if job.status.State != jobPaused {
+		return jobStatus{}, fmt.Errorf("job %d is not paused", id)
+	}
+	job.status.State = jobQueued
+	if job.active {
+		job.status.State = jobRunning
+		q.resumed.Broadcast()
+	}
+	q.schedule()
+	return q.statusOf(job), nil
+}
+
+// cancel drops a job that has not started, or stops its copy.
+func (q *jobQueue) cancel(id int) (jobStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.job(id)
+	if err != nil {
+		return jobStatus{}, err
+	}
+	if jobFinished(job.status.State) {
+		return jobStatus{}, fmt.Errorf("job %d is already %s", id, job.status.State)
+	}
+	if !job.active {
+		job.status.State = jobCanceled
+		job.finished = time.Now()
+		return q.statusOf(job), nil
+	}
+	job.canceled = true
+	job.cancel()
+	q.resumed.Broadcast()
+	return q.statusOf(job), nil
+}
+
+// attach sends job id's status to a client until it finishes. Once the
+// client has the summary of a finished copy, the daemon drops it.
+func (q *jobQueue) attach(encoder *json.Encoder, id int) {
+	ticker := time.NewTicker(attachInterval)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		job, err := q.job(id)
+		var status jobStatus
+		if err == nil {
+			status = q.statusOf(job)
+		}
+		q.mu.Unlock()
+		if err != nil {
+			encoder.Encode(queueResponse{Error: err.Error()})
+			return
+		}
+
+		if err := encoder.Encode(queueResponse{Job: &status}); err != nil {
+			return
+		}
+		if jobFinished(status.State) {
+			q.mu.Lock()
+			job.status.Output = ""
+			q.mu.Unlock()
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// job returns the job with id. q.mu must be held.
+func (q *jobQueue) job(id int) (*queueJob, error) {
+	for _, job := range q.jobs {
+		if job.status.ID == id {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("no job %d", id)
+}
+
+// prune drops the jobs that finished before cutoff. q.mu must be held.
+func (q *jobQueue) prune(cutoff time.Time) {
+	q.jobs = slices.DeleteFunc(q.jobs, func(job *queueJob) bool {
+		return jobFinished(job.status.State) && job.finished.Before(cutoff)
+	})
+}
+
+// statusOf returns job's status, noting which job a queued one waits for.
+// q.mu must be held.
+func (q *jobQueue) statusOf(job *queueJob) jobStatus {
+	status := job.status
+	status.Done = job.done.Load()
+	if status.State == jobQueued {
+		for _, other := range q.jobs {
+			if other.active && other.status.Device == status.Device {
+				status.After = other.status.ID
+			}
+		}
+	}
+	return status
+}
+
+// schedule starts every queued job whose device no running job writes to,
+// in the order they were submitted. q.mu must be held.
+func (q *jobQueue) schedule() {
+	busy := map[string]bool{}
+	for _, job := range q.jobs {
+		if job.active {
+			busy[job.status.Device] = true
+		}
+	}
+	for _, job := range q.jobs {
+		if job.status.State != jobQueued || busy[job.status.Device] || q.ctx.Err() != nil {
+			continue
+		}
+		busy[job.status.Device] = true
+
+		ctx, cancel := context.WithCancel(q.ctx)
+		job.active = true
+		job.cancel = cancel
+		job.status.State = jobRunning
+		q.running.Add(1)
+		go q.run(ctx, job)
+	}
+}
+
+func (q *jobQueue) run(ctx context.Context, job *queueJob) {
+	defer q.running.Done()
+
+	// A job paused as it was started waits before planning.
+	observer := &jobObserver{queue: q, job: job}
+	observer.waitWhilePaused()
+
+	var output bytes.Buffer
+	err := runCopy(ctx, job.opts, job.args, job.sources, job.destination, observer, &output)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.cancel()
+	job.active = false
+	job.status.Output = output.String()
+	job.finished = time.Now()
+	switch {
+	case err != nil && (job.canceled || errors.Is(err, context.Canceled)):
+		job.status.State = jobCanceled
+	case err != nil:
+		job.status.State = jobFailed
+		job.status.Error = err.Error()
+	default:
+		job.status.State = jobDone
+	}
+	q.schedule()
+}
+
+// jobObserver records a job's progress for its status, and holds its copy
+// while the job is paused.
+type jobObserver struct {
+	copier.NopObserver
+	queue *jobQueue
+	job   *queueJob
+}
+
+func (o *jobObserver) PlanReady(plan copier.Plan) {
+	o.queue.mu.Lock()
+	defer o.queue.mu.Unlock()
+	o.job.status.Planned = true
+	o.job.status.Total = plan.TotalBytes
+	o.waitLocked()
+}
+
+func (o *jobObserver) FileStart(op copier.Operation) {
+	o.queue.mu.Lock()
+	defer o.queue.mu.Unlock()
+	o.job.status.Current = op.Destination
+	o.waitLocked()
+}
+
+func (o *jobObserver) Bytes(n uint64) {
+	o.job.done.Add(n)
+	o.waitWhilePaused()
+}
+
+func (o *jobObserver) FileRetry(_ copier.Operation, n uint64) {
+	o.job.done.Add(^(n - 1))
+}
+
+func (o *jobObserver) FlushStart() {
+	o.waitWhilePaused()
+}
+
+// waitWhilePaused blocks until the job is resumed or canceled. The observer
+// is called by the goroutines copying, so waiting here pauses the copy
+// between files, mid-file and before syncing.
+func (o *jobObserver) waitWhilePaused() {
+	o.queue.mu.Lock()
+	defer o.queue.mu.Unlock()
+	o.waitLocked()
+}
+
+// waitLocked is waitWhilePaused with o.queue.mu held.
+func (o *jobObserver) waitLocked() {
+	for o.job.status.State == jobPaused && !o.job.canceled && o.queue.ctx.Err() == nil {
+		o.queue.resumed.Wait()
+	}
+}
+
+func (o *jobObserver) stop() {}
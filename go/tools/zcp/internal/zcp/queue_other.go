@@ -0,0 +1,28 @@
+//go:build !unix && !windows
+
+package zcp
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+func detachedProcess() *syscall.SysProcAttr {
+	return nil
+}
+
+// deviceName cannot tell filesystems apart here, so every copy shares one
+// queue.
+func deviceName(path string, info fs.FileInfo) string {
+	return ""
+}
+
+func checkPrivateDir(dir string) error {
+	return nil
+}
+
+// lockFile opens path without locking it, which these systems cannot do.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+}
@@ -0,0 +1,319 @@
+package zcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+func TestQueueArgs(t *testing.T) {
+	t.Parallel()
+
+	opts := options{}
+	fs, _ := newFlagSet(&opts, io.Discard)
+	if err := fs.Parse([]string{
+		"--queue", "-r", "--recursive", "--log-file", "copy.log", "--profile", "usb", "--retries=2",
+		"--newer-than", "7d", "--older-than", "2024-05-01T12:00:00Z", "src", "host:backup",
+	}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	now := time.Date(2025, 3, 10, 8, 30, 0, 0, time.UTC)
+	args, err := queueArgs(fs, fs.Args(), now)
+	if err != nil {
+		t.Fatalf("queueArgs: %v", err)
+	}
+
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	want := []string{
+		"--log-file=" + filepath.Join(workingDirectory, "copy.log"),
+		// Durations count back from when the copy was queued.
+		"--newer-than=2025-03-03T08:30:00Z",
+		"--older-than=2024-05-01T12:00:00Z",
+		"--recursive=true",
+		"--retries=2",
+		"--",
+		filepath.Join(workingDirectory, "src"),
+		"host:backup",
+	}
+	if !slices.Equal(args, want) {
+		t.Fatalf("queueArgs = %q, want %q", args, want)
+	}
+}
+
+func TestParseQueueCommand(t *testing.T) {
+	t.Parallel()
+
+	for _, args := range [][]string{{"queue", "ls"}, {"queue", "daemon"}, {"queue", "cancel", "3"}} {
+		if _, ok := parseQueueCommand(args); !ok {
+			t.Fatalf("expected %q to be a queue command", args)
+		}
+	}
+	// Anything else is a copy of files that happen to have those names.
+	for _, args := range [][]string{{"queue", "backup"}, {"queue", "ls", "backup"}, {"queue", "cancel"}, {"-r", "queue", "ls"}} {
+		if _, ok := parseQueueCommand(args); ok {
+			t.Fatalf("expected %q to be a copy", args)
+		}
+	}
+}
+
+// queuedCopy returns a job copying a new file to dir on device.
+func queuedCopy(t *testing.T, id int, device string, dir string) *queueJob {
+	t.Helper()
+
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("queued"), 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	destination := filepath.Join(dir, "copy.txt")
+	opts, sources, destination, err := parseOptions([]string{"-q", source, destination}, false, io.Discard)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return &queueJob{
+		status:      jobStatus{ID: id, State: jobQueued, Device: device, Destination: destination},
+		opts:        opts,
+		sources:     sources,
+		destination: destination,
+	}
+}
+
+func TestJobQueueSchedulesPerDevice(t *testing.T) {
+	t.Parallel()
+
+	q := newJobQueue(context.Background())
+	// Job 1 holds disk-a, so job 2 waits for it while job 3 runs.
+	holder := &queueJob{status: jobStatus{ID: 1, State: jobRunning, Device: "disk-a"}, active: true}
+	waiting := queuedCopy(t, 2, "disk-a", t.TempDir())
+	other := queuedCopy(t, 3, "disk-b", t.TempDir())
+	q.jobs = []*queueJob{holder, waiting, other}
+
+	q.mu.Lock()
+	q.schedule()
+	if status := q.statusOf(waiting); status.State != jobQueued || status.After != 1 {
+		t.Fatalf("expected job 2 to wait for job 1, got %+v", status)
+	}
+	q.mu.Unlock()
+	q.running.Wait()
+	if other.status.State != jobDone {
+		t.Fatalf("expected job 3 to run on its own device, got %+v", other.status)
+	}
+	if _, err := os.Stat(other.destination); err != nil {
+		t.Fatalf("expected job 3 to copy: %v", err)
+	}
+
+	q.mu.Lock()
+	holder.active = false
+	holder.status.State = jobDone
+	q.schedule()
+	q.mu.Unlock()
+	q.running.Wait()
+	if waiting.status.State != jobDone || !strings.Contains(waiting.status.Output, "Copied 1 file(s)") {
+		t.Fatalf("expected job 2 to run once its device was free, got %+v", waiting.status)
+	}
+}
+
+func TestJobQueuePausesCopies(t *testing.T) {
+	t.Parallel()
+
+	// A paused job waits after planning, before each file, mid-file and
+	// before syncing.
+	calls := map[string]func(*jobObserver){
+		"plan_ready": func(o *jobObserver) { o.PlanReady(copier.Plan{}) },
+		"file_start": func(o *jobObserver) { o.FileStart(copier.Operation{}) },
+		"bytes":      func(o *jobObserver) { o.Bytes(1024) },
+		"flush":      func(o *jobObserver) { o.FlushStart() },
+	}
+	for name, call := range calls {
+		for _, resume := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s_resume_%t", name, resume), func(t *testing.T) {
+				t.Parallel()
+
+				q := newJobQueue(context.Background())
+				_, cancel := context.WithCancel(context.Background())
+				job := &queueJob{status: jobStatus{ID: 1, State: jobRunning}, active: true, cancel: cancel}
+				q.jobs = []*queueJob{job}
+				if _, err := q.pause(1); err != nil {
+					t.Fatalf("pause: %v", err)
+				}
+
+				copied := make(chan struct{})
+				go func() {
+					call(&jobObserver{queue: q, job: job})
+					close(copied)
+				}()
+				select {
+				case <-copied:
+					t.Fatalf("expected a paused job's copy to wait")
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				if resume {
+					if status, err := q.resume(1); err != nil || status.State != jobRunning {
+						t.Fatalf("expected the job to run again, got %+v, err=%v", status, err)
+					}
+				} else if _, err := q.cancel(1); err != nil {
+					t.Fatalf("cancel: %v", err)
+				}
+				select {
+				case <-copied:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("expected the copy to go on once the job was resumed or canceled")
+				}
+			})
+		}
+	}
+}
+
+func TestJobQueuePrunesFinishedJobs(t *testing.T) {
+	t.Parallel()
+
+	q := newJobQueue(context.Background())
+	now := time.Now()
+	q.jobs = []*queueJob{
+		{status: jobStatus{ID: 1, State: jobDone}, finished: now.Add(-time.Hour)},
+		{status: jobStatus{ID: 2, State: jobFailed}, finished: now},
+		{status: jobStatus{ID: 3, State: jobPaused}},
+	}
+	q.lastID = 3
+
+	q.mu.Lock()
+	q.prune(now.Add(-queueJobRetention))
+	q.mu.Unlock()
+	var kept []int
+	for _, status := range q.list() {
+		kept = append(kept, status.ID)
+	}
+	if !slices.Equal(kept, []int{2, 3}) {
+		t.Fatalf("expected only the job finished long ago to be dropped, got %v", kept)
+	}
+	if _, err := q.cancel(1); err == nil || err.Error() != "no job 1" {
+		t.Fatalf("expected the dropped job to be gone, got %v", err)
+	}
+
+	// IDs go on from the last job submitted.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "source.txt"), []byte("queued"), 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	status, err := q.submit([]string{"-q", "--", filepath.Join(dir, "source.txt"), filepath.Join(dir, "copy.txt")})
+	if err != nil || status.ID != 4 {
+		t.Fatalf("expected the next job to be job 4, got %+v, err=%v", status, err)
+	}
+	q.running.Wait()
+}
+
+func TestQueueSocketDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the temporary directory is per user on Windows")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		t.Fatalf("expected a private directory to be accepted: %v", err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := checkPrivateDir(dir); err == nil {
+		t.Fatalf("expected a directory others can write to be refused")
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := checkPrivateDir(link); err == nil {
+		t.Fatalf("expected a symlink to be refused")
+	}
+
+	// A second daemon cannot take the lock of a running one.
+	lock, err := lockFile(filepath.Join(dir, "queue.sock.lock"))
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if _, err := lockFile(filepath.Join(dir, "queue.sock.lock")); !errors.Is(err, errLocked) {
+		t.Fatalf("expected the lock to be held, got %v", err)
+	}
+	lock.Close()
+	lock, err = lockFile(filepath.Join(dir, "queue.sock.lock"))
+	if err != nil {
+		t.Fatalf("expected the lock to be free once released: %v", err)
+	}
+	lock.Close()
+}
+
+func TestQueueDaemon(t *testing.T) {
+	t.Parallel()
+
+	// Socket paths are limited to about 100 bytes, which test directories
+	// can exceed.
+	socketDir, err := os.MkdirTemp("", "zcpq")
+	if err != nil {
+		t.Fatalf("create socket directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+	socket := filepath.Join(socketDir, "queue.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets are unavailable: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- newJobQueue(context.Background()).serve(listener, 200*time.Millisecond) }()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "source.txt"), []byte("queued"), 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	response, err := callQueue(socket, queueRequest{Command: queueSubmit, Args: []string{"--", filepath.Join(dir, "source.txt"), filepath.Join(dir, "copy.txt")}})
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	var output bytes.Buffer
+	if err := attachJob(socket, response.Job.ID, false, false, &output); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	if !strings.Contains(output.String(), "Copied 1 file(s), 6 B total.") {
+		t.Fatalf("expected the job's summary, got %q", output.String())
+	}
+
+	// The daemon keeps the finished job, but not the summary it sent.
+	response, err = callQueue(socket, queueRequest{Command: queueList})
+	if err != nil || len(response.Jobs) != 1 || response.Jobs[0].State != jobDone || response.Jobs[0].Output != "" {
+		t.Fatalf("expected one finished job without its summary, got %+v, err=%v", response.Jobs, err)
+	}
+	if _, err := callQueue(socket, queueRequest{Command: queuePause, ID: 1}); err == nil || err.Error() != "job 1 is done" {
+		t.Fatalf("expected a finished job not to pause, got %v", err)
+	}
+	if _, err := callQueue(socket, queueRequest{Command: queueSubmit, Args: []string{"--", filepath.Join(dir, "missing.txt")}}); err == nil {
+		t.Fatalf("expected a submit without DEST to fail")
+	}
+
+	// With nothing left to do, the daemon exits.
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the idle daemon to exit")
+	}
+}
@@ -0,0 +1,50 @@
+package zcp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// detachedProcess starts the queue daemon without a console, so it outlives
+// the one zcp runs in.
+func detachedProcess() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// deviceName identifies the volume holding path by its name, such as C: or
+// \\server\share.
+func deviceName(path string, info fs.FileInfo) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	return filepath.VolumeName(path)
+}
+
+// checkPrivateDir does nothing, as the temporary directory is already per
+// user.
+func checkPrivateDir(dir string) error {
+	return nil
+}
+
+// lockFile takes an exclusive lock on path, creating it if needed, and holds
+// it until the returned file is closed.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		file.Close()
+		if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
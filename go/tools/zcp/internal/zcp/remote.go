@@ -0,0 +1,176 @@
+package zcp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/BoscoDomingo/utils/go/tools/zcp/copier"
+)
+
+type sshOptions struct {
+	port       int
+	key        string
+	knownHosts string
+}
+
+// remoteTarget is a DEST written as [user@]host:path.
+type remoteTarget struct {
+	user string
+	host string
+	path string
+}
+
+// parseRemoteTarget recognises scp-style destinations. Like scp, a colon only
+// marks a remote target when no slash comes before it, and single-letter hosts
+// are left alone so Windows drive letters stay local paths.
+func parseRemoteTarget(destination string) (remoteTarget, bool) {
+	colon := strings.Index(destination, ":")
+	if colon <= 0 {
+		return remoteTarget{}, false
+	}
+
+	prefix := destination[:colon]
+	if strings.ContainsAny(prefix, `/\`) {
+		return remoteTarget{}, false
+	}
+
+	target := remoteTarget{host: prefix, path: destination[colon+1:]}
+	if at := strings.LastIndex(prefix, "@"); at >= 0 {
+		target.user, target.host = prefix[:at], prefix[at+1:]
+	}
+	if len(target.host) < 2 {
+		return remoteTarget{}, false
+	}
+	if target.path == "" {
+		target.path = "."
+	}
+	return target, true
+}
+
+// dialSFTP connects to target over SSH and returns a backend writing to it.
+// The closer shuts down the SFTP session, the SSH connection and the
+// connection to the SSH agent.
+func dialSFTP(target remoteTarget, opts sshOptions) (*copier.SFTPBackend, io.Closer, error) {
+	username := target.user
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, nil, fmt.Errorf("determine ssh user: %w", err)
+		}
+		username = current.Username
+	}
+
+	knownHostsPath := opts.knownHosts
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, fmt.Errorf("locate known_hosts: %w", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load known hosts %q: %w", knownHostsPath, err)
+	}
+
+	authMethods, agentConnection, err := sshAuthMethods(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	address := net.JoinHostPort(target.host, strconv.Itoa(opts.port))
+	connection, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            username,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		agentConnection.Close()
+		return nil, nil, fmt.Errorf("connect to %s: %w", address, err)
+	}
+
+	client, err := sftp.NewClient(connection)
+	if err != nil {
+		connection.Close()
+		agentConnection.Close()
+		return nil, nil, fmt.Errorf("start sftp session on %s: %w", address, err)
+	}
+
+	return copier.NewSFTPBackend(client), closerFunc(func() error {
+		return errors.Join(client.Close(), connection.Close(), agentConnection.Close())
+	}), nil
+}
+
+// sshAuthMethods offers an explicit --ssh-key first, then the SSH agent, then
+// the default unencrypted keys in ~/.ssh. It also returns the connection to
+// the agent, which the caller closes along with the SSH connection.
+func sshAuthMethods(opts sshOptions) ([]ssh.AuthMethod, io.Closer, error) {
+	var signers []ssh.Signer
+	if opts.key != "" {
+		signer, err := loadSigner(opts.key)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	methods := make([]ssh.AuthMethod, 0, 2)
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	var agentConnection io.Closer = closerFunc(func() error { return nil })
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if connection, err := net.Dial("unix", socket); err == nil {
+			agentConnection = connection
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(connection).Signers))
+		}
+	}
+
+	if opts.key == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+				if signer, err := loadSigner(filepath.Join(home, ".ssh", name)); err == nil {
+					signers = append(signers, signer)
+				}
+			}
+		}
+		if len(signers) > 0 {
+			methods = append(methods, ssh.PublicKeys(signers...))
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, fmt.Errorf("no ssh credentials found (start ssh-agent or pass --ssh-key)")
+	}
+	return methods, agentConnection, nil
+}
+
+func loadSigner(path string) (ssh.Signer, error) {
+	keyBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh key %q: %w", path, err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh key %q: %w", path, err)
+	}
+	return signer, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
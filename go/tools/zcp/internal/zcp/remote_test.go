@@ -0,0 +1,264 @@
+package zcp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseRemoteTarget(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		destination string
+		want        remoteTarget
+		wantRemote  bool
+	}{
+		{
+			name:        "user_host_path",
+			destination: "backup@nas:/srv/backup",
+			want:        remoteTarget{user: "backup", host: "nas", path: "/srv/backup"},
+			wantRemote:  true,
+		},
+		{
+			name:        "host_only",
+			destination: "nas.local:photos",
+			want:        remoteTarget{host: "nas.local", path: "photos"},
+			wantRemote:  true,
+		},
+		{
+			name:        "empty_path_is_home",
+			destination: "nas:",
+			want:        remoteTarget{host: "nas", path: "."},
+			wantRemote:  true,
+		},
+		{name: "local_path", destination: "/mnt/backup"},
+		{name: "slash_before_colon", destination: "./odd:name"},
+		{name: "windows_drive", destination: `C:\backup`},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := parseRemoteTarget(testCase.destination)
+			if ok != testCase.wantRemote || got != testCase.want {
+				t.Fatalf(
+					"parseRemoteTarget(%q) = %+v, %v, want %+v, %v",
+					testCase.destination,
+					got,
+					ok,
+					testCase.want,
+					testCase.wantRemote,
+				)
+			}
+		})
+	}
+}
+
+func TestRemoteCopyOverSFTP(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	clientKeyPath, clientPublicKey := writeClientKey(t, tempDir)
+	address, hostPublicKey := startSFTPServer(t, clientPublicKey)
+
+	knownHostsPath := filepath.Join(tempDir, "known_hosts")
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(address)}, hostPublicKey)
+	if err := os.WriteFile(knownHostsPath, []byte(knownHostsLine+"\n"), 0o600); err != nil {
+		t.Fatalf("write known_hosts: %v", err)
+	}
+
+	sourceDir := filepath.Join(tempDir, "src")
+	if err := os.MkdirAll(filepath.Join(sourceDir, "nested"), 0o755); err != nil {
+		t.Fatalf("mkdir source: %v", err)
+	}
+	sourceFile := filepath.Join(sourceDir, "nested", "payload.txt")
+	if err := os.WriteFile(sourceFile, []byte("over the wire"), 0o640); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(sourceFile, modTime, modTime); err != nil {
+		t.Fatalf("set source times: %v", err)
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatalf("split address: %v", err)
+	}
+
+	sshArgs := []string{
+		"--ssh-port", port,
+		"--ssh-key", clientKeyPath,
+		"--ssh-known-hosts", knownHostsPath,
+	}
+
+	remoteRoot := filepath.Join(tempDir, "remote")
+	args := append([]string{"-q", "-r", "-p"}, sshArgs...)
+	args = append(args, sourceDir, "tester@127.0.0.1:"+remoteRoot)
+
+	var stdout bytes.Buffer
+	if err := Run(args, nil, &stdout, io.Discard); err != nil {
+		t.Fatalf("remote copy: %v", err)
+	}
+
+	copied := filepath.Join(remoteRoot, "nested", "payload.txt")
+	actual, err := os.ReadFile(copied)
+	if err != nil {
+		t.Fatalf("read copied file: %v", err)
+	}
+	if string(actual) != "over the wire" {
+		t.Fatalf("unexpected copied contents: %q", actual)
+	}
+
+	info, err := os.Stat(copied)
+	if err != nil {
+		t.Fatalf("stat copied file: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 || !info.ModTime().Equal(modTime) {
+		t.Fatalf("expected mode 0640 and modtime %v, got %o and %v", modTime, info.Mode().Perm(), info.ModTime())
+	}
+
+	overwriteArgs := append([]string{"-q"}, sshArgs...)
+	overwriteArgs = append(overwriteArgs, sourceFile, "tester@127.0.0.1:"+copied)
+	err = Run(overwriteArgs, nil, &stdout, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "use -f") {
+		t.Fatalf("expected overwrite error without -f, got %v", err)
+	}
+
+	// The test server lacks fsync@openssh.com, so --fsync is refused before
+	// anything is written.
+	unsynced := filepath.Join(remoteRoot, "unsynced.txt")
+	fsyncArgs := append([]string{"-q", "--fsync=file"}, sshArgs...)
+	fsyncArgs = append(fsyncArgs, sourceFile, "tester@127.0.0.1:"+unsynced)
+	err = Run(fsyncArgs, nil, &stdout, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "not supported by this destination") {
+		t.Fatalf("expected --fsync to be refused, got %v", err)
+	}
+	if _, err := os.Stat(unsynced); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written, got %v", err)
+	}
+}
+
+func writeClientKey(t *testing.T, directory string) (string, ssh.PublicKey) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatalf("marshal client key: %v", err)
+	}
+
+	keyPath := filepath.Join(directory, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("write client key: %v", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("convert client key: %v", err)
+	}
+	return keyPath, sshPublicKey
+}
+
+// startSFTPServer runs an in-process SSH server on a loopback port that only
+// accepts clientKey and serves the local filesystem over SFTP.
+func startSFTPServer(t *testing.T, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivateKey)
+	if err != nil {
+		t.Fatalf("create host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConnection(connection, config)
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), hostSigner.PublicKey()
+}
+
+func serveSFTPConnection(connection net.Conn, config *ssh.ServerConfig) {
+	defer connection.Close()
+
+	_, channels, requests, err := ssh.NewServerConn(connection, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for request := range channelRequests {
+				isSFTP := request.Type == "subsystem" && len(request.Payload) > 4 &&
+					string(request.Payload[4:]) == "sftp"
+				request.Reply(isSFTP, nil)
+			}
+		}()
+
+		go func() {
+			defer channel.Close()
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+		}()
+	}
+}
@@ -0,0 +1,79 @@
+package zcp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes parseSize accepts. One-letter and binary ones
+// are powers of 1024 to match humanizeBytes; KB, MB, GB and TB are decimal.
+// Longer suffixes come first, so they are matched before the B they end in.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{suffix: "KIB", multiplier: 1 << 10},
+	{suffix: "MIB", multiplier: 1 << 20},
+	{suffix: "GIB", multiplier: 1 << 30},
+	{suffix: "TIB", multiplier: 1 << 40},
+	{suffix: "KB", multiplier: 1e3},
+	{suffix: "MB", multiplier: 1e6},
+	{suffix: "GB", multiplier: 1e9},
+	{suffix: "TB", multiplier: 1e12},
+	{suffix: "K", multiplier: 1 << 10},
+	{suffix: "M", multiplier: 1 << 20},
+	{suffix: "G", multiplier: 1 << 30},
+	{suffix: "T", multiplier: 1 << 40},
+	{suffix: "B", multiplier: 1},
+}
+
+// parseSize parses a byte count such as 4096, 512K or 1.5GiB.
+func parseSize(value string) (uint64, error) {
+	number := strings.TrimSpace(value)
+	multiplier := uint64(1)
+	upper := strings.ToUpper(number)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			number = strings.TrimSpace(number[:len(number)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	if whole, err := strconv.ParseUint(number, 10, 64); err == nil {
+		if whole > ^uint64(0)/multiplier {
+			return 0, fmt.Errorf("size %q is too large", value)
+		}
+		return whole * multiplier, nil
+	}
+
+	fraction, err := strconv.ParseFloat(number, 64)
+	if err != nil || fraction < 0 || math.IsNaN(fraction) || math.IsInf(fraction, 0) {
+		return 0, fmt.Errorf("invalid size %q (expected bytes, optionally with a suffix such as K, MiB or MB)", value)
+	}
+	bytes := fraction * float64(multiplier)
+	if bytes >= float64(^uint64(0)) {
+		return 0, fmt.Errorf("size %q is too large", value)
+	}
+	return uint64(bytes), nil
+}
+
+// sizeFlag is a flag.Value holding a size parsed with parseSize.
+type sizeFlag struct {
+	size uint64
+}
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatUint(f.size, 10)
+}
+
+func (f *sizeFlag) Set(value string) error {
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	f.size = size
+	return nil
+}
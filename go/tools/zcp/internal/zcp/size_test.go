@@ -0,0 +1,37 @@
+package zcp
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value string
+		want  uint64
+	}{
+		{value: "0", want: 0},
+		{value: "4096", want: 4096},
+		{value: "512K", want: 512 * 1024},
+		{value: "512k", want: 512 * 1024},
+		{value: "10MiB", want: 10 * 1024 * 1024},
+		{value: "1.5G", want: 3 * 512 * 1024 * 1024},
+		{value: "2 TiB", want: 2 << 40},
+		{value: "100B", want: 100},
+		{value: "1KB", want: 1000},
+		{value: "250MB", want: 250_000_000},
+		{value: "1.5gb", want: 1_500_000_000},
+		{value: "2 TB", want: 2_000_000_000_000},
+	}
+	for _, testCase := range testCases {
+		got, err := parseSize(testCase.value)
+		if err != nil || got != testCase.want {
+			t.Fatalf("parseSize(%q) = %d, %v; want %d", testCase.value, got, err, testCase.want)
+		}
+	}
+
+	for _, value := range []string{"", "lots", "-1", "1X", "99999999999T", "NaN", "nanK", "Inf", "+Inf", "-Inf", "infinity", "InfB", "MB", "GB"} {
+		if _, err := parseSize(value); err == nil {
+			t.Fatalf("expected parseSize(%q) to fail", value)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package zcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// terminalStatus shows a copy's progress outside the progress line: in the
+// terminal's tab or taskbar with the OSC 9;4 sequence, and in its window
+// title. Both are cleared when the copy ends.
+type terminalStatus struct {
+	writer  io.Writer
+	started bool
+	last    string
+}
+
+// OSC 9;4 progress states.
+const (
+	oscProgressClear         = 0
+	oscProgressValue         = 1
+	oscProgressIndeterminate = 3
+)
+
+// update shows percent, or activity when indeterminate, with label after it
+// in the title. Nothing is written unless something changed.
+func (s *terminalStatus) update(percent int, indeterminate bool, progress string, label string) {
+	state := oscProgressValue
+	if indeterminate {
+		state, percent = oscProgressIndeterminate, 0
+	}
+	title := "zcp " + progress
+	if label != "" {
+		title += " " + label
+	}
+
+	sequences := oscProgress(state, percent) + oscTitle(title)
+	if sequences == s.last {
+		return
+	}
+	s.last = sequences
+	if !s.started {
+		// Save the title to restore it afterwards, where the terminal
+		// keeps a stack of them.
+		sequences = "\x1b[22;2t" + sequences
+		s.started = true
+	}
+	io.WriteString(s.writer, sequences)
+}
+
+// clear removes the progress and restores the title, resetting it first for
+// terminals that cannot restore one.
+func (s *terminalStatus) clear() {
+	if !s.started {
+		return
+	}
+	io.WriteString(s.writer, oscProgress(oscProgressClear, 0)+oscTitle("")+"\x1b[23;2t")
+	s.started = false
+	s.last = ""
+}
+
+func oscProgress(state int, percent int) string {
+	return fmt.Sprintf("\x1b]9;4;%d;%d\x1b\\", state, percent)
+}
+
+// oscTitle sets the window title, dropping control characters a file name
+// could use to end the sequence early.
+func oscTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f && r < 0xa0 {
+			return -1
+		}
+		return r
+	}, title)
+	return "\x1b]2;" + title + "\x1b\\"
+}
+
+// supportsTerminalStatus reports whether the terminal, as told by getenv, is
+// known to show OSC 9;4 progress. Others may show it as a notification, as
+// iTerm2 does, so --term-progress=auto leaves them alone.
+func supportsTerminalStatus(getenv func(string) string) bool {
+	switch {
+	case getenv("WT_SESSION") != "":
+		return true
+	case getenv("ConEmuANSI") == "ON":
+		return true
+	case getenv("TERM_PROGRAM") == "WezTerm" || getenv("TERM_PROGRAM") == "ghostty":
+		return true
+	}
+	return getenv("TERM") == "xterm-ghostty"
+}
+
+// parseTermProgress resolves a --term-progress value.
+func parseTermProgress(value string) (bool, error) {
+	switch value {
+	case "auto":
+		return supportsTerminalStatus(os.Getenv), nil
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported term-progress %q (expected auto, on or off)", value)
+	}
+}
+
+// interruptible returns the context to run a copy in. With terminal status,
+// Ctrl-C cancels the copy, so the status is cleared, rather than exiting at
+// once; a second Ctrl-C still does.
+func interruptible(terminalStatus bool) (context.Context, context.CancelFunc) {
+	if !terminalStatus {
+		return context.Background(), func() {}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
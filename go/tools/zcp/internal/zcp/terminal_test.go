@@ -0,0 +1,93 @@
+package zcp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTerminalStatus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("mirrors_progress_and_clears_it", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		bar := newProgressBar(1024, true, &output)
+		bar.status = &terminalStatus{writer: &output}
+		bar.startedAt = time.Now()
+		bar.setCurrent("image\x1b]2;evil\a.iso")
+		bar.add(512)
+		bar.render(false)
+
+		got := output.String()
+		for _, want := range []string{"\x1b[22;2t", "\x1b]9;4;1;50\x1b\\", "\x1b]2;zcp 50% image]2;evil.iso\x1b\\"} {
+			if !strings.Contains(got, want) {
+				t.Fatalf("expected %q in the output, got %q", want, got)
+			}
+		}
+
+		// Unchanged progress is not sent again.
+		bar.render(false)
+		if count := strings.Count(output.String(), "\x1b]9;4;"); count != 1 {
+			t.Fatalf("expected one progress sequence, got %d in %q", count, output.String())
+		}
+
+		output.Reset()
+		bar.render(true)
+		if want := "\x1b]9;4;0;0\x1b\\\x1b]2;\x1b\\\x1b[23;2t"; !strings.HasPrefix(output.String(), want) {
+			t.Fatalf("expected the status to be cleared with %q, got %q", want, output.String())
+		}
+	})
+
+	t.Run("indeterminate_shows_activity", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		bar := newIndeterminateProgressBar(true, &output)
+		bar.status = &terminalStatus{writer: &output}
+		bar.startedAt = time.Now()
+		bar.add(2048)
+		bar.render(false)
+		if got := output.String(); !strings.Contains(got, "\x1b]9;4;3;0\x1b\\") || !strings.Contains(got, "\x1b]2;zcp 2.0 KiB\x1b\\") {
+			t.Fatalf("expected indeterminate progress, got %q", got)
+		}
+	})
+
+	t.Run("clear_without_progress_writes_nothing", func(t *testing.T) {
+		t.Parallel()
+
+		var output bytes.Buffer
+		(&terminalStatus{writer: &output}).clear()
+		if output.Len() != 0 {
+			t.Fatalf("expected nothing to clear, got %q", output.String())
+		}
+	})
+
+	t.Run("detects_supporting_terminals", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := []struct {
+			env  map[string]string
+			want bool
+		}{
+			{env: map[string]string{"WT_SESSION": "b7c1"}, want: true},
+			{env: map[string]string{"ConEmuANSI": "ON"}, want: true},
+			{env: map[string]string{"TERM_PROGRAM": "WezTerm"}, want: true},
+			{env: map[string]string{"TERM": "xterm-ghostty"}, want: true},
+			{env: map[string]string{"TERM_PROGRAM": "iTerm.app"}, want: false},
+			{env: map[string]string{"TERM": "xterm-256color"}, want: false},
+		}
+		for _, testCase := range testCases {
+			getenv := func(key string) string { return testCase.env[key] }
+			if got := supportsTerminalStatus(getenv); got != testCase.want {
+				t.Fatalf("supportsTerminalStatus(%v) = %v, want %v", testCase.env, got, testCase.want)
+			}
+		}
+
+		if _, err := parseTermProgress("always"); err == nil {
+			t.Fatalf("expected an unknown --term-progress value to be rejected")
+		}
+	})
+}